@@ -1,45 +1,181 @@
 package main
 
 import (
-	"context"
+	"flag"
 	"fmt"
-	// "log"
+	"io/ioutil"
+	"log"
+	"log/slog"
 	"math/rand"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
 
 	loopnet "github.com/acruikshank/loopnet/net"
-	crypto "github.com/libp2p/go-libp2p-crypto"
-	peer "github.com/libp2p/go-libp2p-peer"
-	ps "github.com/libp2p/go-libp2p-peerstore"
-	swarm "github.com/libp2p/go-libp2p-swarm"
-	bhost "github.com/libp2p/go-libp2p/p2p/host/basic"
+	p2p "github.com/acruikshank/loopnet/pb"
 	ma "github.com/multiformats/go-multiaddr"
 )
 
+// session all the local nodes play in
+const session = "loopnet"
+
 // helper method - create a lib-p2p host to listen on a port
-func createNode(note int) *loopnet.Node {
-	// Ignoring most errors for brevity
-	// See echo example for more details and better implementation
-	// port := rand.Intn(1000) + 10000
-	priv, pub, _ := crypto.GenerateKeyPair(crypto.Secp256k1, 256)
-	pid, _ := peer.IDFromPublicKey(pub)
+// psk: optional private network key shared by all nodes
+func createNode(psk []byte) *loopnet.Node {
 	listen, err := ma.NewMultiaddr(fmt.Sprintf("/ip4/127.0.0.1/tcp/%d", 0))
 	if err != nil {
 		panic("Could not create multiaddress")
 	}
-	peerStore := ps.NewPeerstore()
-	peerStore.AddPrivKey(pid, priv)
-	peerStore.AddPubKey(pid, pub)
-	n, err := swarm.NewNetwork(context.Background(), []ma.Multiaddr{listen}, pid, peerStore, nil)
+
+	node, err := loopnet.NewNode(listen, psk)
 	if err != nil {
 		panic(err)
 	}
+	return node
+}
 
-	host := bhost.New(n)
+// join every node to the session, each playing a different note.
+// private: the first node hosts the session and invites the others
+func joinSessions(nodes []*loopnet.Node, private bool) []*loopnet.NotificationProtocol {
+	sessions := make([]*loopnet.NotificationProtocol, 0)
+	if !private {
+		for i, node := range nodes {
+			sessions = append(sessions, node.JoinSession(session, 60+i, false))
+		}
+		return sessions
+	}
 
-	node := loopnet.NewNode(host)
-	noteData := node.NewNoteData(0, note, false)
-	node.NotificationProtocol.NoteStore = loopnet.NewNoteStore(noteData)
-	return node
+	owner := nodes[0].HostSession(session, 60, false)
+	token, err := owner.Invite(loopnet.AnyNode, time.Hour)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println("Invitation:", token)
+
+	sessions = append(sessions, owner)
+	for i, node := range nodes[1:] {
+		np, err := node.JoinInvitation(token, 61+i, false)
+		if err != nil {
+			log.Fatal(err)
+		}
+		sessions = append(sessions, np)
+	}
+	return sessions
+}
+
+// configure the log levels of the nodes
+// spec: levels as accepted by Logging.Configure, e.g. "info,gossip=debug"
+// only: index of the only node to configure, or -1 for every node
+func configureLogging(nodes []*loopnet.Node, spec string, only int) {
+	for i, node := range nodes {
+		if only >= 0 && i != only {
+			continue
+		}
+		if err := node.Logging.Configure(spec); err != nil {
+			log.Fatal(err)
+		}
+	}
+}
+
+// join the Ableton Link session on the local network and follow or lead it
+// mode: "follow" to take the Link tempo, "lead" to set it
+func startLink(node *loopnet.Node, np *loopnet.NotificationProtocol, mode string, stop <-chan struct{}) *loopnet.Link {
+	// the address of the interface multicast is routed through
+	conn, err := net.Dial("udp4", loopnet.LinkGroup.String())
+	if err != nil {
+		log.Fatal(err)
+	}
+	address := conn.LocalAddr().(*net.UDPAddr).IP
+	conn.Close()
+
+	link, err := loopnet.NewLink(node.Clock, address, loopnet.LinkGroup)
+	if err != nil {
+		log.Fatal(err)
+	}
+	link.SetLogging(node.Logging)
+	go func() {
+		<-stop
+		link.Close()
+	}()
+
+	switch mode {
+	case "follow":
+		go np.FollowLink(link, stop)
+	case "lead":
+		go np.LeadLink(link, stop)
+	default:
+		log.Fatalf("unknown link mode %q", mode)
+	}
+	return link
+}
+
+// send the swarm state of the session to an OSC receiver. The returned
+// sender also sends the arpeggiator's steps.
+func startOSC(node *loopnet.Node, np *loopnet.NotificationProtocol, target string, stop <-chan struct{}) *loopnet.OSCSender {
+	sender, err := loopnet.NewOSCSender(target, loopnet.DefaultOSCAddresses)
+	if err != nil {
+		log.Fatal(err)
+	}
+	sender.SetLogging(node.Logging)
+
+	go sender.Watch(np.NoteStore, stop)
+	go func() {
+		<-stop
+		sender.Close()
+	}()
+	return sender
+}
+
+// serve the API, the live feed and the visualizer of the session and the
+// node's metrics. The returned feed also streams the arpeggiator's steps.
+func startHTTP(node *loopnet.Node, np *loopnet.NotificationProtocol, listen string, stop <-chan struct{}) *loopnet.Feed {
+	feed := loopnet.NewFeed(np)
+	api := loopnet.NewAPI(np)
+	api.Handle("/feed", feed)
+	api.Handle("/metrics", loopnet.MetricsHandler(node))
+	api.Handle("/", loopnet.Visualizer())
+
+	go feed.Watch(stop)
+	go func() {
+		log.Fatal(http.ListenAndServe(listen, api))
+	}()
+	return feed
+}
+
+// play the session with an arpeggiator on a clock, the swarm clock or a
+// followed Link
+func startArpeggiator(np *loopnet.NotificationProtocol, clock loopnet.Clock, onSteps []func(loopnet.Step), stop <-chan struct{}) *loopnet.Arpeggiator {
+	arpeggiator := loopnet.NewArpeggiator(np.NoteStore, np.Parameters, func(step loopnet.Step) {
+		for _, onStep := range onSteps {
+			onStep(step)
+		}
+	})
+	arpeggiator.SetClock(clock)
+	go arpeggiator.Run(stop)
+	return arpeggiator
+}
+
+// gossip every session at its own adaptive cadence until stop is closed
+func gossip(sessions []*loopnet.NotificationProtocol, stop <-chan struct{}) {
+	for _, np := range sessions {
+		go np.Gossip(stop)
+	}
+}
+
+// psk subcommand - manage private network keys
+func pskCommand(args []string) {
+	if len(args) != 1 || args[0] != "generate" {
+		fmt.Fprintln(os.Stderr, "usage: loopnet psk generate > swarm.key")
+		os.Exit(2)
+	}
+
+	psk, err := loopnet.GeneratePSK()
+	if err != nil {
+		log.Fatal(err)
+	}
+	os.Stdout.Write(psk)
 }
 
 // TODO:
@@ -49,7 +185,48 @@ func createNode(note int) *loopnet.Node {
 // Add UI and synthesis
 
 func main() {
-	logging.Configure(logging.LevelDebug)
+	pskFile := flag.String("psk", "", "pre-shared key file; only nodes holding the same key can connect")
+	private := flag.Bool("private", false, "admit nodes to the session with invitations from the first node")
+	link := flag.String("link", "", "follow or lead the Ableton Link session on the local network")
+	osc := flag.String("osc", "", "host:port to send OSC note and swarm state messages to")
+	httpListen := flag.String("http", "", "host:port to serve the HTTP/JSON API, live feed and visualizer on")
+	oscListen := flag.String("osc-listen", "", "host:port to receive OSC control messages on")
+	oscAllow := flag.String("osc-allow", "", "comma separated addresses or networks OSC control messages are accepted from (default loopback)")
+	logSpec := flag.String("log", "info", "log levels, optionally per component, e.g. warn,gossip=debug")
+	logNode := flag.Int("log-node", -1, "index of the only node to apply -log to; the others log at info")
+	seed := flag.Int64("seed", 0, "seed for picking the notes to gossip, for reproducible runs (default random)")
+	rumorSends := flag.Int("rumor-sends", loopnet.DefaultRumorSends, "notifications each changed note is sent in ahead of the random sample; 0 sends random samples only")
+	gossipMin := flag.Duration("gossip-min", loopnet.DefaultGossipBounds.MinInterval, "shortest interval between gossip rounds, while notes change quickly")
+	gossipMax := flag.Duration("gossip-max", loopnet.DefaultGossipBounds.MaxInterval, "longest interval between gossip rounds, while nothing changes")
+	fanoutMin := flag.Int("fanout-min", loopnet.DefaultGossipBounds.MinFanout, "fewest peers notified each gossip round")
+	fanoutMax := flag.Int("fanout-max", loopnet.DefaultGossipBounds.MaxFanout, "most peers notified each gossip round")
+	reconcile := flag.Duration("reconcile", 0, "interval between reconciling each session's notes with a random peer by comparing Merkle tree hashes (default never)")
+	divergenceWindow := flag.Duration("divergence-window", loopnet.DefaultDivergenceWindow, "how long peers must keep disagreeing on the notes of a session before a divergence alert")
+	shards := flag.Int("shards", 0, "spread each session's notes over this many independently locked shards (default one lock)")
+	traceFile := flag.String("trace", "", "file to write a JSON line to for every note revision arriving at a node")
+	flag.Parse()
+
+	switch flag.Arg(0) {
+	case "", "tui":
+	case "psk":
+		pskCommand(flag.Args()[1:])
+		return
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n", flag.Arg(0))
+		os.Exit(2)
+	}
+
+	// levels are filtered per node and component
+	slog.SetDefault(slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelDebug})))
+
+	var psk []byte
+	if *pskFile != "" {
+		var err error
+		psk, err = ioutil.ReadFile(*pskFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
 
 	// Choose random ports between 10000-10100
 	rand.Seed(666)
@@ -57,26 +234,133 @@ func main() {
 	// Make 10 nodes
 	nodes := make([]*loopnet.Node, 0)
 	for i := 0; i < 50; i++ {
-		nodes = append(nodes, createNode(60+i))
+		node := createNode(psk)
+		defer node.Close()
+		nodes = append(nodes, node)
+	}
+
+	configureLogging(nodes, *logSpec, *logNode)
+
+	if *shards > 0 {
+		for _, node := range nodes {
+			node.NewStore = func(self *p2p.NoteData) loopnet.Store {
+				return loopnet.NewShardedNoteStore(self, *shards)
+			}
+		}
+	}
+
+	sessions := joinSessions(nodes, *private)
+
+	for _, np := range sessions {
+		np.SetRumorSends(*rumorSends)
+		np.SetDivergenceWindow(*divergenceWindow)
+		np.SetGossipBounds(loopnet.GossipBounds{
+			MinInterval: *gossipMin,
+			MaxInterval: *gossipMax,
+			MinFanout:   *fanoutMin,
+			MaxFanout:   *fanoutMax,
+		})
+	}
+
+	if *seed != 0 {
+		for i, np := range sessions {
+			np.NoteStore.SetRandom(rand.New(rand.NewSource(*seed + int64(i))))
+		}
+	}
+
+	if *traceFile != "" {
+		f, err := os.Create(*traceFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer f.Close()
+
+		trace := loopnet.NewPropagationTrace(f)
+		for _, np := range sessions {
+			np.SetTrace(trace)
+		}
 	}
 
 	// connect round robin
-	for i, node := range nodes {
-		node.ConnectToHost(nodes[(i+1)%len(nodes)])
+	for i, np := range sessions {
+		np.ConnectToHost(nodes[(i+1)%len(nodes)])
 	}
 
 	done := make(chan bool, 1)
 
+	stop := make(chan struct{})
+	defer close(stop)
+
+	for _, node := range nodes {
+		go node.Clock.Run(stop)
+	}
+
+	if *reconcile > 0 {
+		for _, np := range sessions {
+			go np.RunReconciliation(*reconcile, stop)
+		}
+	}
+
+	// arpeggiators play on the swarm clock, or in phase with a followed Link
+	var clock loopnet.Clock = nodes[0].Clock
+	if *link != "" {
+		followed := startLink(nodes[0], sessions[0], *link, stop)
+		if *link == "follow" {
+			clock = followed
+		}
+	}
+
+	// handlers of the steps played in the first session
+	onSteps := make([]func(loopnet.Step), 0)
+
+	if *osc != "" {
+		onSteps = append(onSteps, startOSC(nodes[0], sessions[0], *osc, stop).OnStep)
+	}
+
+	if *httpListen != "" {
+		onSteps = append(onSteps, startHTTP(nodes[0], sessions[0], *httpListen, stop).OnStep)
+	}
+
+	tui := flag.Arg(0) == "tui"
+	var arpeggiator *loopnet.Arpeggiator
+	if len(onSteps) > 0 || tui {
+		arpeggiator = startArpeggiator(sessions[0], clock, onSteps, stop)
+	}
+
+	if *oscListen != "" {
+		allow := make([]string, 0)
+		if *oscAllow != "" {
+			allow = strings.Split(*oscAllow, ",")
+		}
+		server, err := loopnet.NewOSCServer(sessions[0], *oscListen, allow)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer server.Close()
+	}
+
+	if tui {
+		// logs would draw over the dashboard
+		log.SetOutput(ioutil.Discard)
+		slog.SetDefault(slog.New(slog.NewTextHandler(ioutil.Discard, nil)))
+		gossip(sessions, stop)
+		if err := runTUI(sessions[0], arpeggiator); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	// run 10 rounds of notifications
 	go func() {
 		for i := 0; i < 30; i++ {
-			for _, node := range nodes {
-				node.Notify()
+			for _, np := range sessions {
+				np.Notify()
 			}
 
 			fmt.Println("\n\n\n\nRound", i+1)
-			for j, node := range nodes {
-				fmt.Printf("Host %d: %v\n", j+1, node.NoteStore.ActiveNoteNumbers())
+			for j, np := range sessions {
+				fmt.Printf("Host %d: %v\n", j+1, np.NoteStore.ActiveNoteNumbers())
 			}
 		}
 		done <- true
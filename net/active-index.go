@@ -0,0 +1,124 @@
+package loopnet
+
+import (
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+// activeIndex is an immutable snapshot of the pitches played by a note
+// store, replaced whenever the store changes so that readers never wait
+// for writers.
+type activeIndex struct {
+	pitches   []int  // sorted pitches of the unmuted notes in effect, excluding scheduled notes
+	scheduled []Note // notes with a revision not in effect when last stored
+	muted     bool   // all notes muted by a conductor
+}
+
+// pitchesAt returns the sorted pitches played at a time. The result is
+// shared when no note is scheduled.
+func (index *activeIndex) pitchesAt(t time.Time) []int {
+	if index.muted {
+		return []int{}
+	}
+	if len(index.scheduled) == 0 {
+		return index.pitches
+	}
+
+	pitches := index.pitches
+	for _, note := range index.scheduled {
+		state := note.stateAt(t)
+		if state != nil && !state.Mute {
+			pitches = insertPitch(pitches, int(state.Note))
+		}
+	}
+	return pitches
+}
+
+// pitchIndex keeps the pitches of a store's notes up to date as notes
+// change and publishes them as an activeIndex. Callers serialize changes.
+type pitchIndex struct {
+	pitches   []int           // sorted pitches of unmuted notes in effect, replaced on change
+	scheduled map[string]Note // notes whose latest revision was not in effect when stored
+	active    atomic.Value    // *activeIndex published after every change
+}
+
+func newPitchIndex() *pitchIndex {
+	index := &pitchIndex{scheduled: make(map[string]Note)}
+	index.publish(false)
+	return index
+}
+
+// add a stored note to the index, as scheduled if its latest revision is
+// not in effect yet
+func (pi *pitchIndex) add(note Note, now time.Time) {
+	if !takesEffect(note.NoteData, now) {
+		pi.scheduled[note.NodeId] = note
+		return
+	}
+	if !note.Mute {
+		pi.pitches = insertPitch(pi.pitches, int(note.Note))
+	}
+}
+
+// remove a stored note from the index
+func (pi *pitchIndex) remove(note Note) {
+	if _, found := pi.scheduled[note.NodeId]; found {
+		delete(pi.scheduled, note.NodeId)
+		return
+	}
+	if !note.Mute {
+		pi.pitches = removePitch(pi.pitches, int(note.Note))
+	}
+}
+
+// index the scheduled notes that have taken effect, forgetting the
+// revisions they replaced, so later snapshots only answer from now on
+func (pi *pitchIndex) settle(now time.Time) {
+	for nodeId, note := range pi.scheduled {
+		if !takesEffect(note.NoteData, now) {
+			continue
+		}
+
+		delete(pi.scheduled, nodeId)
+		note.earlier = nil
+		pi.add(note, now)
+	}
+}
+
+// replace the snapshot read by pitchesAt
+func (pi *pitchIndex) publish(muted bool) {
+	index := &activeIndex{
+		pitches: pi.pitches,
+		muted:   muted,
+	}
+	for _, note := range pi.scheduled {
+		index.scheduled = append(index.scheduled, note)
+	}
+	pi.active.Store(index)
+}
+
+// the sorted pitches played at a time, read without locking
+func (pi *pitchIndex) pitchesAt(t time.Time) []int {
+	return pi.active.Load().(*activeIndex).pitchesAt(t)
+}
+
+// a sorted copy of pitches with pitch added
+func insertPitch(pitches []int, pitch int) []int {
+	i := sort.SearchInts(pitches, pitch)
+	out := make([]int, 0, len(pitches)+1)
+	out = append(out, pitches[:i]...)
+	out = append(out, pitch)
+	return append(out, pitches[i:]...)
+}
+
+// a sorted copy of pitches with one occurrence of pitch removed
+func removePitch(pitches []int, pitch int) []int {
+	i := sort.SearchInts(pitches, pitch)
+	if i == len(pitches) || pitches[i] != pitch {
+		return pitches
+	}
+	out := make([]int, 0, len(pitches)-1)
+	out = append(out, pitches[:i]...)
+	return append(out, pitches[i+1:]...)
+}
@@ -0,0 +1,110 @@
+package loopnet
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	p2p "github.com/acruikshank/loopnet/pb"
+)
+
+func TestActiveIndex(t *testing.T) {
+	t.Run("keeps duplicate pitches sorted", func(t *testing.T) {
+		pitches := []int{}
+		for _, pitch := range []int{64, 60, 67, 60, 72} {
+			pitches = insertPitch(pitches, pitch)
+		}
+		pitches = removePitch(pitches, 60)
+		pitches = removePitch(pitches, 61)
+
+		if expected := []int{60, 64, 67, 72}; !reflect.DeepEqual(pitches, expected) {
+			t.Errorf("expected %v, got %v", expected, pitches)
+		}
+	})
+
+	t.Run("follows revisions, mutes and removed notes", func(t *testing.T) {
+		noteStore := NewNoteStore(createNote("self", 0, 60, false))
+		noteStore.OnNote(*createNote("n1", 1, 64, false))
+		noteStore.OnNote(*createNote("n2", 1, 67, false))
+		noteStore.OnNote(*createNote("n1", 2, 62, false))
+		noteStore.OnNote(*createNote("n2", 2, 67, true))
+		noteStore.OnCommand(p2p.Command{Type: p2p.Command_KICK, Target: "n1", Revision: 1})
+
+		if expected := []int{60}; !reflect.DeepEqual(noteStore.ActiveNoteNumbers(), expected) {
+			t.Errorf("expected %v, got %v", expected, noteStore.ActiveNoteNumbers())
+		}
+	})
+
+	t.Run("returns snapshots unchanged by later notes", func(t *testing.T) {
+		noteStore := NewNoteStore(createNote("self", 0, 60, false))
+		noteStore.OnNote(*createNote("n1", 1, 64, false))
+		snapshot := noteStore.ActiveNoteNumbers()
+
+		noteStore.OnNote(*createNote("n1", 2, 48, false))
+		noteStore.OnNote(*createNote("n2", 1, 50, false))
+
+		if expected := []int{60, 64}; !reflect.DeepEqual(snapshot, expected) {
+			t.Errorf("snapshot changed to %v", snapshot)
+		}
+		if expected := []int{48, 50, 60}; !reflect.DeepEqual(noteStore.ActiveNoteNumbers(), expected) {
+			t.Errorf("expected %v, got %v", expected, noteStore.ActiveNoteNumbers())
+		}
+	})
+
+	t.Run("plays scheduled notes once they take effect", func(t *testing.T) {
+		clock := &testClock{now: time.Unix(100, 0)}
+		noteStore := NewNoteStore(createNote("self", 0, 60, false))
+		noteStore.SetClock(clock)
+		noteStore.OnNote(*createNote("n1", 1, 64, false))
+
+		scheduled := createNote("n1", 2, 67, false)
+		scheduled.Effective = time.Unix(101, 0).UnixNano()
+		noteStore.OnNote(*scheduled)
+
+		if expected := []int{60, 64}; !reflect.DeepEqual(noteStore.ActiveNoteNumbersAt(time.Unix(100, 0)), expected) {
+			t.Errorf("expected %v before the change, got %v", expected, noteStore.ActiveNoteNumbersAt(time.Unix(100, 0)))
+		}
+		if expected := []int{60, 67}; !reflect.DeepEqual(noteStore.ActiveNoteNumbersAt(time.Unix(102, 0)), expected) {
+			t.Errorf("expected %v after the change, got %v", expected, noteStore.ActiveNoteNumbersAt(time.Unix(102, 0)))
+		}
+
+		// the next change to the store settles the scheduled note
+		clock.now = time.Unix(102, 0)
+		noteStore.OnNote(*createNote("n2", 1, 72, false))
+		if len(noteStore.index.scheduled) != 0 {
+			t.Error("expected the scheduled note to be settled")
+		}
+		if expected := []int{60, 67, 72}; !reflect.DeepEqual(noteStore.ActiveNoteNumbers(), expected) {
+			t.Errorf("expected %v, got %v", expected, noteStore.ActiveNoteNumbers())
+		}
+	})
+
+	t.Run("is empty while every note is muted", func(t *testing.T) {
+		noteStore := NewNoteStore(createNote("self", 0, 60, false))
+		noteStore.OnCommand(p2p.Command{Type: p2p.Command_MUTE_ALL, Revision: 1})
+
+		if len(noteStore.ActiveNoteNumbers()) != 0 {
+			t.Errorf("expected no notes, got %v", noteStore.ActiveNoteNumbers())
+		}
+	})
+}
+
+func BenchmarkActiveNoteNumbers(b *testing.B) {
+	noteStore := NewNoteStore(createNote("self", 0, 60, false))
+	for _, note := range createNotes(10000) {
+		note.Note = note.Note % 128
+		noteStore.OnNote(note)
+	}
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		noteStore.ActiveNoteNumbers()
+	}
+}
+
+// a clock standing still until moved
+type testClock struct {
+	now time.Time
+}
+
+func (c *testClock) Now() time.Time { return c.now }
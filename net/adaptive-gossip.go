@@ -0,0 +1,157 @@
+package loopnet
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// revisions accepted per second at which gossip runs halfway between its
+// bounds
+const busyUpdateRate = 2.0
+
+// weight of the latest round in the smoothed update rate
+const updateRateSmoothing = 0.3
+
+// GossipBounds limits how the gossip rounds of a session adapt.
+type GossipBounds struct {
+	MinInterval time.Duration // between rounds while notes change quickly
+	MaxInterval time.Duration // between rounds while nothing changes
+	MinFanout   int           // peers notified each round while nothing changes
+	MaxFanout   int           // peers notified each round at most
+}
+
+// DefaultGossipBounds lets gossip slow down to a round a second while
+// nothing changes and speed up to 20 rounds a second.
+var DefaultGossipBounds = GossipBounds{
+	MinInterval: 50 * time.Millisecond,
+	MaxInterval: time.Second,
+	MinFanout:   2,
+	MaxFanout:   6,
+}
+
+// GossipStatus is the current cadence of a session's gossip and the
+// signals it follows.
+type GossipStatus struct {
+	Interval   time.Duration // until the next round
+	Fanout     int           // peers notified each round
+	UpdateRate float64       // note revisions accepted per second, smoothed
+	Notes      int           // notes stored
+}
+
+// adaptiveGossip picks the interval and fanout of gossip rounds from how
+// quickly the store changes. Changes push the interval from its maximum
+// towards its minimum and the fanout from its minimum towards the number
+// of rounds a rumor needs to reach every stored node, log2 of their count.
+type adaptiveGossip struct {
+	bounds    GossipBounds
+	status    GossipStatus
+	accepted  uint64    // revisions accepted at the last observation
+	observed  time.Time // time of the last observation, zero before the first
+	gossipMux *sync.Mutex
+}
+
+func newAdaptiveGossip(bounds GossipBounds) *adaptiveGossip {
+	g := &adaptiveGossip{gossipMux: &sync.Mutex{}}
+	g.setBounds(bounds)
+	return g
+}
+
+// observe updates the status from the revisions the store has accepted
+// so far and the number of notes it holds.
+func (g *adaptiveGossip) observe(accepted uint64, notes int, now time.Time) GossipStatus {
+	g.gossipMux.Lock()
+	defer g.gossipMux.Unlock()
+
+	if elapsed := now.Sub(g.observed).Seconds(); !g.observed.IsZero() && elapsed > 0 {
+		rate := float64(accepted-g.accepted) / elapsed
+		g.status.UpdateRate += updateRateSmoothing * (rate - g.status.UpdateRate)
+	}
+	g.accepted, g.observed = accepted, now
+	g.status.Notes = notes
+	g.adaptLocked()
+	return g.status
+}
+
+// callers must hold gossipMux
+func (g *adaptiveGossip) adaptLocked() {
+	b := g.bounds
+	churn := g.status.UpdateRate / (g.status.UpdateRate + busyUpdateRate)
+
+	// geometric, so that the same churn halves a long or a short interval
+	ratio := float64(b.MinInterval) / float64(b.MaxInterval)
+	g.status.Interval = time.Duration(float64(b.MaxInterval) * math.Pow(ratio, churn))
+
+	sizeFanout := b.MinFanout
+	if g.status.Notes > 1 {
+		sizeFanout = int(math.Ceil(math.Log2(float64(g.status.Notes))))
+	}
+	if sizeFanout < b.MinFanout {
+		sizeFanout = b.MinFanout
+	}
+	if sizeFanout > b.MaxFanout {
+		sizeFanout = b.MaxFanout
+	}
+	g.status.Fanout = b.MinFanout + int(math.Round(churn*float64(sizeFanout-b.MinFanout)))
+}
+
+// setBounds replaces the bounds, correcting any that are inverted or not
+// positive.
+func (g *adaptiveGossip) setBounds(bounds GossipBounds) {
+	g.gossipMux.Lock()
+	defer g.gossipMux.Unlock()
+
+	if bounds.MinInterval <= 0 {
+		bounds.MinInterval = DefaultGossipBounds.MinInterval
+	}
+	if bounds.MaxInterval < bounds.MinInterval {
+		bounds.MaxInterval = bounds.MinInterval
+	}
+	if bounds.MinFanout < 1 {
+		bounds.MinFanout = 1
+	}
+	if bounds.MaxFanout < bounds.MinFanout {
+		bounds.MaxFanout = bounds.MinFanout
+	}
+	g.bounds = bounds
+	g.adaptLocked()
+}
+
+func (g *adaptiveGossip) current() GossipStatus {
+	g.gossipMux.Lock()
+	defer g.gossipMux.Unlock()
+
+	return g.status
+}
+
+// Gossip notifies peers and clears dead notes in rounds until stop is
+// closed, adapting the interval and fanout of the rounds to how quickly
+// notes change within the session's gossip bounds.
+func (np *NotificationProtocol) Gossip(stop <-chan struct{}) {
+	for {
+		status := np.adaptGossip()
+		np.Notify()
+		np.NoteStore.ClearDeadNotes()
+
+		select {
+		case <-stop:
+			return
+		case <-time.After(status.Interval):
+		}
+	}
+}
+
+// update the gossip cadence from the store
+func (np *NotificationProtocol) adaptGossip() GossipStatus {
+	return np.gossip.observe(np.NoteStore.Metrics().NotesAccepted.Value(), np.NoteStore.ActiveNotes(), time.Now())
+}
+
+// GossipStatus returns the current gossip interval and fanout.
+func (np *NotificationProtocol) GossipStatus() GossipStatus {
+	return np.gossip.current()
+}
+
+// SetGossipBounds limits the interval and fanout of gossip rounds.
+func (np *NotificationProtocol) SetGossipBounds(bounds GossipBounds) {
+	np.gossip.setBounds(bounds)
+}
@@ -0,0 +1,92 @@
+package loopnet
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAdaptiveGossip(t *testing.T) {
+	bounds := GossipBounds{MinInterval: 50 * time.Millisecond, MaxInterval: time.Second, MinFanout: 2, MaxFanout: 6}
+	start := time.Unix(100, 0)
+
+	t.Run("slows down while nothing changes", func(t *testing.T) {
+		g := newAdaptiveGossip(bounds)
+		g.observe(10, 1000, start)
+		status := g.observe(10, 1000, start.Add(time.Second))
+
+		if status.Interval != time.Second || status.Fanout != 2 {
+			t.Errorf("expected the slowest cadence, got %+v", status)
+		}
+	})
+
+	t.Run("speeds up and widens during a flurry of changes", func(t *testing.T) {
+		g := newAdaptiveGossip(bounds)
+		g.observe(0, 1000, start)
+		status := GossipStatus{}
+		for i := 1; i <= 20; i++ {
+			status = g.observe(uint64(i*100), 1000, start.Add(time.Duration(i)*time.Second))
+		}
+
+		if status.Interval > 60*time.Millisecond || status.Fanout != 6 {
+			t.Errorf("expected nearly the fastest cadence, got %+v", status)
+		}
+	})
+
+	t.Run("fans out no wider than the session needs", func(t *testing.T) {
+		g := newAdaptiveGossip(bounds)
+		g.observe(0, 4, start)
+		status := GossipStatus{}
+		for i := 1; i <= 20; i++ {
+			status = g.observe(uint64(i*100), 4, start.Add(time.Duration(i)*time.Second))
+		}
+
+		if status.Fanout != 2 {
+			t.Errorf("expected a fanout of log2(4), got %d", status.Fanout)
+		}
+	})
+
+	t.Run("recovers once changes stop", func(t *testing.T) {
+		g := newAdaptiveGossip(bounds)
+		g.observe(0, 100, start)
+		busy := g.observe(50, 100, start.Add(time.Second))
+		quiet := busy
+		for i := 2; i <= 30; i++ {
+			quiet = g.observe(50, 100, start.Add(time.Duration(i)*time.Second))
+		}
+
+		if quiet.Interval <= busy.Interval || quiet.UpdateRate >= busy.UpdateRate {
+			t.Errorf("expected gossip to slow down from %+v, got %+v", busy, quiet)
+		}
+	})
+
+	t.Run("corrects inverted bounds", func(t *testing.T) {
+		g := newAdaptiveGossip(GossipBounds{MinInterval: time.Second, MaxInterval: time.Millisecond, MinFanout: 0, MaxFanout: -1})
+
+		if status := g.current(); status.Interval != time.Second || status.Fanout != 1 {
+			t.Errorf("unexpected status %+v", status)
+		}
+	})
+
+	t.Run("gossips until stopped", func(t *testing.T) {
+		node := createTestNode(t)
+		other := createTestNode(t)
+		jam := node.JoinSession("jam", 60, false)
+		other.JoinSession("jam", 62, false).ConnectToHost(node)
+		waitFor(t, func() bool { return jam.NoteStore.ActiveNotes() == 2 })
+		jam.SetGossipBounds(GossipBounds{MinInterval: time.Millisecond, MaxInterval: 10 * time.Millisecond})
+
+		stop := make(chan struct{})
+		done := make(chan struct{})
+		go func() {
+			jam.Gossip(stop)
+			close(done)
+		}()
+		waitFor(t, func() bool { return jam.Metrics.NotificationsSent.Value() >= 3 })
+		close(stop)
+		<-done
+
+		if jam.GossipStatus().Notes != 2 {
+			t.Errorf("expected 2 notes observed, got %d", jam.GossipStatus().Notes)
+		}
+	})
+}
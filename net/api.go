@@ -0,0 +1,200 @@
+package loopnet
+
+import (
+	"encoding/json"
+	"mime"
+	"net/http"
+	"time"
+
+	p2p "github.com/acruikshank/loopnet/pb"
+	peer "github.com/libp2p/go-libp2p-peer"
+)
+
+// NoteState is the JSON representation of a stored note.
+type NoteState struct {
+	NodeId    string `json:"nodeId"`
+	Note      int    `json:"note"`
+	Mute      bool   `json:"mute"`
+	Revision  uint32 `json:"revision"`
+	Address   string `json:"address"`
+	Effective int64  `json:"effective,omitempty"` // swarm time (unix ns) a scheduled note takes effect
+}
+
+// PeerState is the JSON representation of a peer known to the node.
+type PeerState struct {
+	NodeId    string   `json:"nodeId"`
+	Addresses []string `json:"addresses"`
+}
+
+// SelfState is the JSON representation of the local node in a session.
+type SelfState struct {
+	NoteState
+	Session string `json:"session"`
+}
+
+// StatusState is the JSON representation of how a session is gossiped and
+// whether peers agree on its notes.
+type StatusState struct {
+	Session    string  `json:"session"`
+	Notes      int     `json:"notes"`
+	IntervalMs float64 `json:"intervalMs"` // between gossip rounds
+	Fanout     int     `json:"fanout"`     // peers notified each round
+	UpdateRate float64 `json:"updateRate"` // note revisions accepted per second
+	Peers      int     `json:"peers"`      // peers that reported their state recently
+	Agreement  float64 `json:"agreement"`  // percentage of those peers agreeing with this node
+	Alert      string  `json:"alert"`      // "partition", "divergence" or empty
+}
+
+// SelfUpdate is the body of POST /self. Fields left out are unchanged.
+type SelfUpdate struct {
+	Note *int  `json:"note"`
+	Mute *bool `json:"mute"`
+}
+
+// API serves the state of a session over HTTP and lets clients change the
+// local note:
+//
+//	GET  /state  all stored notes
+//	GET  /peers  peers known to the node
+//	GET  /self   the local node's note
+//	POST /self   change the local node's note and mute
+//	GET  /status the current gossip interval and fanout, and agreement with peers
+type API struct {
+	np  *NotificationProtocol
+	mux *http.ServeMux
+}
+
+// NewAPI creates an HTTP handler for a session.
+func NewAPI(np *NotificationProtocol) *API {
+	a := &API{np: np, mux: http.NewServeMux()}
+	a.mux.HandleFunc("/state", a.getState)
+	a.mux.HandleFunc("/peers", a.getPeers)
+	a.mux.HandleFunc("/self", a.onSelf)
+	a.mux.HandleFunc("/status", a.getStatus)
+	return a
+}
+
+// Handle serves another handler alongside the API, e.g. a Feed.
+func (a *API) Handle(pattern string, handler http.Handler) {
+	a.mux.Handle(pattern, handler)
+}
+
+func (a *API) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	a.mux.ServeHTTP(w, r)
+}
+
+func (a *API) getState(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	notes := a.np.NoteStore.Notes()
+	state := make([]NoteState, 0, len(notes))
+	for i := range notes {
+		state = append(state, newNoteState(&notes[i]))
+	}
+	a.writeJSON(w, state)
+}
+
+func (a *API) getPeers(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	peerstore := a.np.node.Peerstore()
+	peers := make([]PeerState, 0)
+	for _, nodeId := range peerstore.Peers() {
+		if nodeId == a.np.node.ID() {
+			continue
+		}
+		addresses := make([]string, 0)
+		for _, addr := range peerstore.Addrs(nodeId) {
+			addresses = append(addresses, addr.String())
+		}
+		peers = append(peers, PeerState{NodeId: peer.IDB58Encode(nodeId), Addresses: addresses})
+	}
+	a.writeJSON(w, peers)
+}
+
+func (a *API) onSelf(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		// browsers only send JSON cross-site after a preflight, which isn't
+		// answered, and always send their Origin
+		if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType != "application/json" {
+			http.Error(w, "expected application/json", http.StatusUnsupportedMediaType)
+			return
+		}
+		if !sameOrigin(r) {
+			http.Error(w, "cross-origin request", http.StatusForbidden)
+			return
+		}
+
+		update := SelfUpdate{}
+		if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		note, mute := a.np.LocalNote()
+		if update.Note != nil {
+			if *update.Note < 0 || *update.Note > 127 {
+				http.Error(w, "note must be a midi note number", http.StatusBadRequest)
+				return
+			}
+			note = *update.Note
+		}
+		if update.Mute != nil {
+			mute = *update.Mute
+		}
+		a.np.SetNote(note, mute)
+		a.np.Notify()
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	self, _ := a.np.NoteStore.LastRevision(a.np.NoteStore.SelfId())
+	a.writeJSON(w, SelfState{NoteState: newNoteState(&self), Session: a.np.session})
+}
+
+func (a *API) getStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	status := a.np.GossipStatus()
+	agreement := a.np.Agreement()
+	a.writeJSON(w, StatusState{
+		Session:    a.np.session,
+		Notes:      a.np.NoteStore.ActiveNotes(),
+		IntervalMs: float64(status.Interval) / float64(time.Millisecond),
+		Fanout:     status.Fanout,
+		UpdateRate: status.UpdateRate,
+		Peers:      agreement.Peers,
+		Agreement:  agreement.Percent(),
+		Alert:      agreement.Alert,
+	})
+}
+
+func newNoteState(note *p2p.NoteData) NoteState {
+	return NoteState{
+		NodeId:    note.NodeId,
+		Note:      int(note.Note),
+		Mute:      note.Mute,
+		Revision:  note.Revision,
+		Address:   note.Address,
+		Effective: note.Effective,
+	}
+}
+
+func (a *API) writeJSON(w http.ResponseWriter, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(value); err != nil {
+		a.np.node.logger(logHTTP).Warn("failed to write JSON response", "err", err)
+	}
+}
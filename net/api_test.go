@@ -0,0 +1,169 @@
+package loopnet
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	peer "github.com/libp2p/go-libp2p-peer"
+)
+
+func TestAPI(t *testing.T) {
+	node := createTestNode(t)
+	other := createTestNode(t)
+	jam := node.JoinSession("jam", 60, false)
+	otherJam := other.JoinSession("jam", 64, true)
+	otherJam.ConnectToHost(node)
+	waitFor(t, func() bool { return jam.NoteStore.ActiveNotes() == 2 })
+
+	server := httptest.NewServer(NewAPI(jam))
+	defer server.Close()
+
+	get := func(path string, value interface{}) {
+		res, err := http.Get(server.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		if res.StatusCode != http.StatusOK {
+			t.Fatalf("GET %s returned %d", path, res.StatusCode)
+		}
+		if err := json.NewDecoder(res.Body).Decode(value); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	nodeId := peer.IDB58Encode(node.ID())
+	otherId := peer.IDB58Encode(other.ID())
+
+	t.Run("GET /state returns every stored note", func(t *testing.T) {
+		state := make([]NoteState, 0)
+		get("/state", &state)
+
+		if len(state) != 2 {
+			t.Fatalf("expected 2 notes, got %v", state)
+		}
+		for _, note := range state {
+			if note.NodeId == otherId && (note.Note != 64 || !note.Mute || note.Address == "") {
+				t.Errorf("unexpected note %v", note)
+			}
+		}
+	})
+
+	t.Run("GET /peers returns known peers", func(t *testing.T) {
+		peers := make([]PeerState, 0)
+		get("/peers", &peers)
+
+		if len(peers) != 1 || peers[0].NodeId != otherId || len(peers[0].Addresses) == 0 {
+			t.Errorf("unexpected peers %v", peers)
+		}
+	})
+
+	t.Run("GET /self returns the local note", func(t *testing.T) {
+		self := SelfState{}
+		get("/self", &self)
+
+		if self.NodeId != nodeId || self.Note != 60 || self.Session != "jam" {
+			t.Errorf("unexpected self %v", self)
+		}
+	})
+
+	t.Run("GET /status returns the gossip cadence", func(t *testing.T) {
+		waitFor(t, func() bool { return jam.Agreement().Peers == 1 })
+		status := StatusState{}
+		get("/status", &status)
+
+		if status.Session != "jam" || status.Notes != 2 || status.Fanout != DefaultGossipBounds.MinFanout {
+			t.Errorf("unexpected status %v", status)
+		}
+		if status.IntervalMs != float64(DefaultGossipBounds.MaxInterval/time.Millisecond) {
+			t.Errorf("expected the longest interval, got %vms", status.IntervalMs)
+		}
+		// the peer sent its state before it knew this node's note
+		if status.Peers != 1 || status.Agreement != 0 || status.Alert != "" {
+			t.Errorf("unexpected agreement %v", status)
+		}
+	})
+
+	t.Run("POST /self", func(t *testing.T) {
+		t.Run("changes the local note", func(t *testing.T) {
+			res, err := http.Post(server.URL+"/self", "application/json", strings.NewReader(`{"note": 67}`))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer res.Body.Close()
+
+			self := SelfState{}
+			json.NewDecoder(res.Body).Decode(&self)
+			if self.Note != 67 || self.Mute || self.Revision != 1 {
+				t.Errorf("unexpected self %v", self)
+			}
+		})
+
+		t.Run("changes mute and keeps the note", func(t *testing.T) {
+			http.Post(server.URL+"/self", "application/json", strings.NewReader(`{"mute": true}`))
+
+			if note, mute := jam.LocalNote(); note != 67 || !mute {
+				t.Errorf("expected muted 67, got %d %v", note, mute)
+			}
+		})
+
+		t.Run("gossips the change", func(t *testing.T) {
+			waitFor(t, func() bool {
+				note, _ := otherJam.NoteStore.LastRevision(nodeId)
+				return note.Note == 67 && note.Mute
+			})
+		})
+
+		t.Run("rejects invalid notes", func(t *testing.T) {
+			res, err := http.Post(server.URL+"/self", "application/json", strings.NewReader(`{"note": 200}`))
+			if err != nil {
+				t.Fatal(err)
+			}
+			res.Body.Close()
+
+			if res.StatusCode != http.StatusBadRequest {
+				t.Errorf("expected 400, got %d", res.StatusCode)
+			}
+		})
+
+		t.Run("rejects bodies that are not JSON", func(t *testing.T) {
+			res, err := http.Post(server.URL+"/self", "text/plain", strings.NewReader(`{"note": 0}`))
+			if err != nil {
+				t.Fatal(err)
+			}
+			res.Body.Close()
+
+			if res.StatusCode != http.StatusUnsupportedMediaType {
+				t.Errorf("expected 415, got %d", res.StatusCode)
+			}
+		})
+
+		t.Run("rejects requests from other origins", func(t *testing.T) {
+			req, _ := http.NewRequest(http.MethodPost, server.URL+"/self", strings.NewReader(`{"note": 0}`))
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("Origin", "http://example.com")
+			res, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			res.Body.Close()
+
+			if note, _ := jam.LocalNote(); res.StatusCode != http.StatusForbidden || note == 0 {
+				t.Errorf("expected 403 and no change, got %d and note %d", res.StatusCode, note)
+			}
+		})
+	})
+
+	t.Run("rejects other methods", func(t *testing.T) {
+		recorder := httptest.NewRecorder()
+		NewAPI(jam).ServeHTTP(recorder, httptest.NewRequest(http.MethodDelete, "/state", nil))
+
+		if recorder.Code != http.StatusMethodNotAllowed {
+			t.Errorf("expected 405, got %d", recorder.Code)
+		}
+	})
+}
@@ -0,0 +1,230 @@
+package loopnet
+
+import (
+	"sync"
+	"time"
+)
+
+// tempo used until a conductor sets one, in beats per minute
+const defaultTempo = 120
+
+// the arpeggiator plays sixteenth notes
+const stepsPerBeat = 4
+
+// semitones above the root of the scales notes can be quantized to
+var scales = map[string][]int{
+	"chromatic":  {0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11},
+	"major":      {0, 2, 4, 5, 7, 9, 11},
+	"minor":      {0, 2, 3, 5, 7, 8, 10},
+	"pentatonic": {0, 2, 4, 7, 9},
+	"blues":      {0, 3, 5, 6, 7, 10},
+}
+
+// Clock tells the arpeggiator the time. Arpeggiators sharing a clock play
+// their steps at the same moments.
+type Clock interface {
+	Now() time.Time
+}
+
+// the local clock, for arpeggiators playing on their own
+type localClock struct{}
+
+func (localClock) Now() time.Time { return time.Now() }
+
+// Step is a single note played by the arpeggiator, or a rest once there
+// are no notes left to play.
+type Step struct {
+	Index int  `json:"index"`          // position in the arpeggio sequence
+	Note  int  `json:"note"`           // midi note number
+	Rest  bool `json:"rest,omitempty"` // nothing plays until the next step
+}
+
+// Arpeggiator plays the active notes of a session from lowest to highest and
+// back down, using the parameters agreed by the session. Tempo and scale set
+// by a conductor override the agreed values.
+type Arpeggiator struct {
+	store      Store
+	parameters *ParameterStore
+	onStep     func(Step) // called for every step played
+	clock      Clock
+	position   int
+	mux        *sync.Mutex
+}
+
+// NewArpeggiator creates an arpeggiator playing the notes in store with the
+// shared parameters. onStep is called for every step played by Run.
+func NewArpeggiator(store Store, parameters *ParameterStore, onStep func(Step)) *Arpeggiator {
+	return &Arpeggiator{
+		store:      store,
+		parameters: parameters,
+		onStep:     onStep,
+		clock:      localClock{},
+		mux:        &sync.Mutex{},
+	}
+}
+
+// SetClock sets the clock step boundaries are aligned to, e.g. the node's
+// swarm clock so that steps line up across machines.
+func (a *Arpeggiator) SetClock(clock Clock) {
+	a.mux.Lock()
+	defer a.mux.Unlock()
+
+	a.clock = clock
+}
+
+// Parameters returns the parameters the arpeggiator plays with.
+func (a *Arpeggiator) Parameters() Parameters {
+	return sessionParameters(a.store, a.parameters)
+}
+
+// the agreed parameters of a session, overridden by conductor controls
+func sessionParameters(store Store, params *ParameterStore) Parameters {
+	parameters := params.Current()
+	controls := store.Controls()
+	if validTempo(controls.Tempo) {
+		parameters.Tempo = controls.Tempo
+	}
+	if controls.Scale != "" {
+		parameters.Scale = controls.Scale
+	}
+	return parameters
+}
+
+// Sequence returns one cycle of the arpeggio: the active notes quantized
+// to the session scale, lowest to highest and back down.
+func (a *Arpeggiator) Sequence() []int {
+	a.mux.Lock()
+	clock := a.clock
+	a.mux.Unlock()
+
+	return a.SequenceAt(clock.Now())
+}
+
+// SequenceAt returns the arpeggio of the notes in effect at a time.
+func (a *Arpeggiator) SequenceAt(t time.Time) []int {
+	parameters := a.Parameters()
+
+	up := make([]int, 0)
+	for _, note := range a.store.ActiveNoteNumbersAt(t) {
+		note = quantize(note, parameters.Scale, parameters.Root)
+		// notes are sorted, so quantized duplicates are adjacent
+		if len(up) == 0 || up[len(up)-1] != note {
+			up = append(up, note)
+		}
+	}
+
+	sequence := append(make([]int, 0, 2*len(up)), up...)
+	for i := len(up) - 2; i > 0; i-- {
+		sequence = append(sequence, up[i])
+	}
+	return sequence
+}
+
+// Next advances the arpeggio by one step. It returns false if there are
+// no notes to play.
+func (a *Arpeggiator) Next() (Step, bool) {
+	sequence := a.Sequence()
+	if len(sequence) == 0 {
+		return Step{}, false
+	}
+
+	a.mux.Lock()
+	defer a.mux.Unlock()
+
+	index := a.position % len(sequence)
+	a.position = index + 1
+	return Step{Index: index, Note: sequence[index]}, true
+}
+
+// StepAt returns the step played at a time. Steps are counted from the unix
+// epoch, so arpeggiators with synchronized clocks and the same notes play the
+// same step at the same time.
+func (a *Arpeggiator) StepAt(t time.Time) (Step, bool) {
+	sequence := a.SequenceAt(t)
+	if len(sequence) == 0 {
+		return Step{}, false
+	}
+
+	index := int(t.UnixNano() / int64(a.StepDuration()) % int64(len(sequence)))
+	return Step{Index: index, Note: sequence[index]}, true
+}
+
+// StepDuration returns the time between steps at the session tempo.
+func (a *Arpeggiator) StepDuration() time.Duration {
+	tempo := a.Parameters().Tempo
+	if tempo <= 0 {
+		tempo = defaultTempo
+	}
+	return minDuration(time.Duration(float64(time.Minute) / float64(tempo*stepsPerBeat)))
+}
+
+// at least a nanosecond, so that a duration computed from a bad tempo
+// can't divide by zero
+func minDuration(d time.Duration) time.Duration {
+	if d < 1 {
+		return 1
+	}
+	return d
+}
+
+// Run plays steps on the boundaries of the step grid of the arpeggiator's
+// clock until stop is closed. With swing, odd steps are played late. When
+// every note is muted or gone, a single rest step ends the last note.
+func (a *Arpeggiator) Run(stop <-chan struct{}) {
+	playing := false
+	for {
+		a.mux.Lock()
+		clock := a.clock
+		a.mux.Unlock()
+
+		now := clock.Now()
+		duration := int64(a.StepDuration())
+		stepNumber := now.UnixNano()/duration + 1
+		boundary := time.Unix(0, stepNumber*duration)
+		if stepNumber%2 == 1 {
+			boundary = boundary.Add(time.Duration(float64(duration) * float64(a.Parameters().Swing) / 2))
+		}
+
+		select {
+		case <-stop:
+			return
+		case <-time.After(boundary.Sub(now)):
+		}
+
+		step, ok := a.StepAt(time.Unix(0, stepNumber*duration))
+		if !ok {
+			if !playing {
+				continue
+			}
+			step = Step{Rest: true}
+		}
+		playing = ok
+		if a.onStep != nil {
+			a.onStep(step)
+		}
+	}
+}
+
+// snap a midi note to the nearest note in the scale starting root semitones
+// above C, preferring the lower note on ties
+func quantize(note int, scale string, root int) int {
+	intervals, found := scales[scale]
+	if !found {
+		return note
+	}
+
+	// work relative to the root, keeping the octave positive
+	note = note - root + 12
+	octave, degree := note/12, note%12
+	best, bestDistance := note, 12
+	for _, interval := range append(intervals, intervals[0]+12) {
+		distance := interval - degree
+		if distance < 0 {
+			distance = -distance
+		}
+		if distance < bestDistance {
+			best, bestDistance = octave*12+interval, distance
+		}
+	}
+	return best + root - 12
+}
@@ -0,0 +1,170 @@
+package loopnet
+
+import (
+	"math"
+	"reflect"
+	"testing"
+	"time"
+
+	p2p "github.com/acruikshank/loopnet/pb"
+)
+
+func TestArpeggiator(t *testing.T) {
+	selfNote := createNote("self", 0, 60, false)
+
+	t.Run("Sequence", func(t *testing.T) {
+		t.Run("plays notes up and back down", func(t *testing.T) {
+			noteStore := NewNoteStore(selfNote)
+			noteStore.OnNote(*createNote("n1", 1, 64, false))
+			noteStore.OnNote(*createNote("n2", 1, 67, false))
+			noteStore.OnNote(*createNote("n3", 1, 72, false))
+
+			sequence := NewArpeggiator(noteStore, NewParameterStore(), nil).Sequence()
+			expectation := []int{60, 64, 67, 72, 67, 64}
+			if !reflect.DeepEqual(sequence, expectation) {
+				t.Errorf("Expected sequence %v, got %v", expectation, sequence)
+			}
+		})
+
+		t.Run("quantizes notes to the scale", func(t *testing.T) {
+			noteStore := NewNoteStore(selfNote)
+			noteStore.OnNote(*createNote("n1", 1, 61, false))
+			noteStore.OnNote(*createNote("n2", 1, 63, false))
+			noteStore.OnNote(*createNote("n3", 1, 66, false))
+			noteStore.OnCommand(p2p.Command{Type: p2p.Command_SET_SCALE, Scale: "pentatonic", Revision: 1})
+
+			sequence := NewArpeggiator(noteStore, NewParameterStore(), nil).Sequence()
+			expectation := []int{60, 62, 67, 62}
+			if !reflect.DeepEqual(sequence, expectation) {
+				t.Errorf("Expected sequence %v, got %v", expectation, sequence)
+			}
+		})
+
+		t.Run("quantizes notes to the scale from the agreed root", func(t *testing.T) {
+			noteStore := NewNoteStore(selfNote)
+			noteStore.OnNote(*createNote("n1", 1, 64, false))
+			parameters := NewParameterStore()
+			parameters.OnParameter(p2p.Parameter{Name: p2p.Parameter_SCALE, Scale: "major", Revision: 1})
+			parameters.OnParameter(p2p.Parameter{Name: p2p.Parameter_ROOT, Value: 2, Revision: 1})
+
+			// D major has C# and F#, not C and E
+			sequence := NewArpeggiator(noteStore, parameters, nil).Sequence()
+			expectation := []int{59, 64}
+			if !reflect.DeepEqual(sequence, expectation) {
+				t.Errorf("Expected sequence %v, got %v", expectation, sequence)
+			}
+		})
+
+		t.Run("is empty while everyone is muted", func(t *testing.T) {
+			noteStore := NewNoteStore(selfNote)
+			noteStore.OnCommand(p2p.Command{Type: p2p.Command_MUTE_ALL, Revision: 1})
+
+			arpeggiator := NewArpeggiator(noteStore, NewParameterStore(), nil)
+			if _, ok := arpeggiator.Next(); ok {
+				t.Error("played a step while muted")
+			}
+		})
+	})
+
+	t.Run("Next cycles through the sequence", func(t *testing.T) {
+		noteStore := NewNoteStore(selfNote)
+		noteStore.OnNote(*createNote("n1", 1, 64, false))
+		arpeggiator := NewArpeggiator(noteStore, NewParameterStore(), nil)
+
+		notes := make([]int, 0)
+		for i := 0; i < 3; i++ {
+			step, _ := arpeggiator.Next()
+			notes = append(notes, step.Note)
+		}
+
+		expectation := []int{60, 64, 60}
+		if !reflect.DeepEqual(notes, expectation) {
+			t.Errorf("Expected steps %v, got %v", expectation, notes)
+		}
+	})
+
+	t.Run("StepAt plays the same step at the same time", func(t *testing.T) {
+		noteStore := NewNoteStore(selfNote)
+		noteStore.OnNote(*createNote("n1", 1, 64, false))
+		noteStore.OnNote(*createNote("n2", 1, 67, false))
+		arpeggiator := NewArpeggiator(noteStore, NewParameterStore(), nil)
+
+		// 125ms steps through a 4 step sequence
+		at := time.Unix(0, 0).Add(6 * 125 * time.Millisecond)
+		step, _ := arpeggiator.StepAt(at)
+		if step.Index != 2 || step.Note != 67 {
+			t.Errorf("unexpected step %v", step)
+		}
+
+		other := NewArpeggiator(noteStore, NewParameterStore(), nil)
+		if otherStep, _ := other.StepAt(at.Add(time.Millisecond)); otherStep != step {
+			t.Errorf("arpeggiators disagree: %v vs %v", step, otherStep)
+		}
+	})
+
+	t.Run("Run rests once when nothing is left to play", func(t *testing.T) {
+		noteStore := NewNoteStore(selfNote)
+		noteStore.OnCommand(p2p.Command{Type: p2p.Command_SET_TEMPO, Tempo: maxTempo, Revision: 1})
+		steps := make(chan Step, 100)
+		arpeggiator := NewArpeggiator(noteStore, NewParameterStore(), func(step Step) { steps <- step })
+		stop := make(chan struct{})
+		defer close(stop)
+		go arpeggiator.Run(stop)
+
+		if step := <-steps; step.Rest || step.Note != 60 {
+			t.Errorf("expected the note to play, got %v", step)
+		}
+		noteStore.OnNote(*createNote("self", 1, 60, true))
+		for step := range steps {
+			if step.Rest {
+				break
+			}
+		}
+
+		time.Sleep(20 * arpeggiator.StepDuration())
+		if len(steps) != 0 {
+			t.Errorf("expected a single rest, got %d more steps", len(steps))
+		}
+	})
+
+	t.Run("StepDuration follows the tempo", func(t *testing.T) {
+		noteStore := NewNoteStore(selfNote)
+		arpeggiator := NewArpeggiator(noteStore, NewParameterStore(), nil)
+
+		if arpeggiator.StepDuration() != 125*time.Millisecond {
+			t.Errorf("unexpected default step duration %v", arpeggiator.StepDuration())
+		}
+
+		noteStore.OnCommand(p2p.Command{Type: p2p.Command_SET_TEMPO, Tempo: 60, Revision: 1})
+		if arpeggiator.StepDuration() != 250*time.Millisecond {
+			t.Errorf("unexpected step duration %v at 60bpm", arpeggiator.StepDuration())
+		}
+	})
+
+	t.Run("conductor tempo out of bounds is ignored", func(t *testing.T) {
+		noteStore := NewNoteStore(selfNote)
+		arpeggiator := NewArpeggiator(noteStore, NewParameterStore(), nil)
+
+		noteStore.OnCommand(p2p.Command{Type: p2p.Command_SET_TEMPO, Tempo: float32(math.Inf(1)), Revision: 1})
+		if arpeggiator.StepDuration() != 125*time.Millisecond {
+			t.Errorf("unexpected step duration %v", arpeggiator.StepDuration())
+		}
+		arpeggiator.StepAt(time.Now())
+	})
+
+	t.Run("conductor tempo overrides the agreed tempo", func(t *testing.T) {
+		noteStore := NewNoteStore(selfNote)
+		parameters := NewParameterStore()
+		arpeggiator := NewArpeggiator(noteStore, parameters, nil)
+
+		parameters.OnParameter(p2p.Parameter{Name: p2p.Parameter_TEMPO, Value: 60, Revision: 1})
+		if arpeggiator.Parameters().Tempo != 60 {
+			t.Errorf("did not use agreed tempo, got %v", arpeggiator.Parameters().Tempo)
+		}
+
+		noteStore.OnCommand(p2p.Command{Type: p2p.Command_SET_TEMPO, Tempo: 90, Revision: 1})
+		if arpeggiator.Parameters().Tempo != 90 {
+			t.Errorf("did not use conductor tempo, got %v", arpeggiator.Parameters().Tempo)
+		}
+	})
+}
@@ -0,0 +1,217 @@
+package loopnet
+
+import (
+	"bufio"
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	p2p "github.com/acruikshank/loopnet/pb"
+	inet "github.com/libp2p/go-libp2p-net"
+	peer "github.com/libp2p/go-libp2p-peer"
+	protobufCodec "github.com/multiformats/go-multicodec/protobuf"
+)
+
+// pattern: /protocol-name/request-or-response-message/version
+const clockRequest = "/loopnet/clock/0.0.1"
+
+// number of peers sampled in each sync round
+const clockPeersPerRound = 3
+
+// number of exchanges with each peer sampled in a round, for outlier rejection
+const clockSamplesPerPeer = 4
+
+// time between sync rounds in Run
+const clockSyncInterval = 2 * time.Second
+
+// time a peer has to answer a clock exchange before it is skipped
+var clockExchangeTimeout = time.Second
+
+// ClockSample is the result of one clock exchange with a peer.
+type ClockSample struct {
+	Offset    time.Duration // peer swarm clock minus our local clock
+	RoundTrip time.Duration // network round trip, excluding the peer's processing time
+}
+
+// ClockProtocol keeps a clock synchronized with the other nodes in the swarm.
+// Each node estimates the offset of its peers' swarm clocks with NTP style
+// exchanges and moves its own swarm clock towards the median of its peers.
+type ClockProtocol struct {
+	node     *Node
+	now      func() time.Time          // local clock
+	offset   time.Duration             // swarm clock minus local clock
+	samples  map[peer.ID][]ClockSample // samples of the current round by peer, oldest first
+	clockMux *sync.RWMutex
+}
+
+// NewClockProtocol creates the clock protocol for a node and starts answering
+// clock requests from its peers.
+func NewClockProtocol(node *Node) *ClockProtocol {
+	c := &ClockProtocol{
+		node:     node,
+		now:      time.Now,
+		samples:  make(map[peer.ID][]ClockSample),
+		clockMux: &sync.RWMutex{},
+	}
+	node.SetStreamHandler(clockRequest, c.onClockRequest)
+	return c
+}
+
+// Now returns the current swarm time.
+func (c *ClockProtocol) Now() time.Time {
+	return c.now().Add(c.Offset())
+}
+
+// Offset returns the difference between the swarm clock and the local clock.
+func (c *ClockProtocol) Offset() time.Duration {
+	c.clockMux.RLock()
+	defer c.clockMux.RUnlock()
+
+	return c.offset
+}
+
+// remote peer requests handler
+func (c *ClockProtocol) onClockRequest(s inet.Stream) {
+	defer s.Close()
+	s.SetDeadline(time.Now().Add(clockExchangeTimeout))
+
+	sample := &p2p.ClockSample{}
+	decoder := protobufCodec.Multicodec(nil).Decoder(bufio.NewReader(s))
+	err := decoder.Decode(sample)
+	if err != nil {
+		c.node.logger(logClock).Warn("failed to decode clock request", "err", err, "peer", peer.IDB58Encode(s.Conn().RemotePeer()))
+		return
+	}
+
+	sample.Receive = c.Now().UnixNano()
+	sample.Transmit = c.Now().UnixNano()
+	c.node.sendProtoMessage(sample, s)
+}
+
+// SyncPeer performs one clock exchange with a peer and records the sample.
+func (c *ClockProtocol) SyncPeer(nodeId peer.ID) (ClockSample, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), clockExchangeTimeout)
+	defer cancel()
+	s, err := c.node.NewStream(ctx, nodeId, clockRequest)
+	if err != nil {
+		return ClockSample{}, err
+	}
+	defer s.Close()
+	s.SetDeadline(time.Now().Add(clockExchangeTimeout))
+
+	request := &p2p.ClockSample{Originate: c.now().UnixNano()}
+	c.node.sendProtoMessage(request, s)
+
+	response := &p2p.ClockSample{}
+	decoder := protobufCodec.Multicodec(nil).Decoder(bufio.NewReader(s))
+	err = decoder.Decode(response)
+	if err != nil {
+		return ClockSample{}, err
+	}
+
+	sample := newClockSample(request.Originate, response.Receive, response.Transmit, c.now().UnixNano())
+
+	c.clockMux.Lock()
+	defer c.clockMux.Unlock()
+	samples := append(c.samples[nodeId], sample)
+	if len(samples) > clockSamplesPerPeer {
+		samples = samples[len(samples)-clockSamplesPerPeer:]
+	}
+	c.samples[nodeId] = samples
+
+	return sample, nil
+}
+
+// Sync samples a few known peers and moves the swarm clock towards them.
+// Peers move their swarm clocks every round, so only the samples of this
+// round are used; peers that left are forgotten with them.
+func (c *ClockProtocol) Sync() {
+	c.clockMux.Lock()
+	c.samples = make(map[peer.ID][]ClockSample)
+	c.clockMux.Unlock()
+
+	peers := make([]peer.ID, 0)
+	for _, nodeId := range c.node.Peerstore().Peers() {
+		if nodeId != c.node.ID() && len(c.node.Peerstore().Addrs(nodeId)) > 0 {
+			peers = append(peers, nodeId)
+		}
+	}
+
+	for i := 0; i < clockPeersPerRound && len(peers) > 0; i++ {
+		index := randomInt(len(peers))
+		for j := 0; j < clockSamplesPerPeer; j++ {
+			if _, err := c.SyncPeer(peers[index]); err != nil {
+				c.node.logger(logClock).Warn("failed to sync clock", "err", err, "peer", peer.IDB58Encode(peers[index]))
+				break
+			}
+		}
+		peers = append(peers[:index], peers[index+1:]...)
+	}
+
+	c.adjust()
+}
+
+// Run syncs the clock periodically until stop is closed.
+func (c *ClockProtocol) Run(stop <-chan struct{}) {
+	for {
+		c.Sync()
+
+		select {
+		case <-stop:
+			return
+		case <-time.After(clockSyncInterval):
+		}
+	}
+}
+
+// move the swarm clock to the median of our own clock and our peers' clocks
+func (c *ClockProtocol) adjust() {
+	c.clockMux.Lock()
+	defer c.clockMux.Unlock()
+
+	// offsets of peer clocks relative to our swarm clock, ours being 0
+	offsets := []time.Duration{0}
+	for _, samples := range c.samples {
+		if sample, ok := bestClockSample(samples); ok {
+			offsets = append(offsets, sample.Offset-c.offset)
+		}
+	}
+
+	c.offset += median(offsets)
+}
+
+// compute offset and round trip from NTP timestamps:
+// t1 request sent, t2 request received, t3 response sent, t4 response received
+func newClockSample(t1, t2, t3, t4 int64) ClockSample {
+	return ClockSample{
+		Offset:    time.Duration(((t2 - t1) + (t3 - t4)) / 2),
+		RoundTrip: time.Duration((t4 - t1) - (t3 - t2)),
+	}
+}
+
+// the sample with the shortest round trip has the least error from
+// asymmetric network delays, so it is preferred over the others
+func bestClockSample(samples []ClockSample) (ClockSample, bool) {
+	if len(samples) == 0 {
+		return ClockSample{}, false
+	}
+	best := samples[0]
+	for _, sample := range samples[1:] {
+		if sample.RoundTrip < best.RoundTrip {
+			best = sample
+		}
+	}
+	return best, true
+}
+
+// median of a non-empty list, averaging the middle values of even lists
+func median(durations []time.Duration) time.Duration {
+	sorted := append([]time.Duration{}, durations...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	middle := len(sorted) / 2
+	if len(sorted)%2 == 0 {
+		return (sorted[middle-1] + sorted[middle]) / 2
+	}
+	return sorted[middle]
+}
@@ -0,0 +1,103 @@
+package loopnet
+
+import (
+	"testing"
+	"time"
+
+	inet "github.com/libp2p/go-libp2p-net"
+	peer "github.com/libp2p/go-libp2p-peer"
+	ps "github.com/libp2p/go-libp2p-peerstore"
+)
+
+func TestClock(t *testing.T) {
+	t.Run("samples", func(t *testing.T) {
+		t.Run("compute offset and round trip from timestamps", func(t *testing.T) {
+			// peer is 100 ahead, 10 each way and 5 processing
+			sample := newClockSample(1000, 1110, 1115, 1025)
+
+			if sample.Offset != 100 || sample.RoundTrip != 20 {
+				t.Errorf("unexpected sample %v", sample)
+			}
+		})
+
+		t.Run("prefer the shortest round trip", func(t *testing.T) {
+			samples := []ClockSample{{Offset: 150, RoundTrip: 90}, {Offset: 100, RoundTrip: 10}, {Offset: 80, RoundTrip: 40}}
+
+			best, _ := bestClockSample(samples)
+			if best.Offset != 100 {
+				t.Errorf("expected the 10ns round trip sample, got %v", best)
+			}
+		})
+
+		t.Run("median ignores outliers", func(t *testing.T) {
+			if median([]time.Duration{0, 5, 1000, 3, 4}) != 4 {
+				t.Error("unexpected median")
+			}
+			if median([]time.Duration{0, 10}) != 5 {
+				t.Error("unexpected median of even list")
+			}
+		})
+	})
+
+	t.Run("SyncPeer gives up on a peer that stops answering", func(t *testing.T) {
+		node, other := createTestNode(t), createTestNode(t)
+		node.Peerstore().AddAddrs(other.ID(), other.Addrs(), ps.PermanentAddrTTL)
+		timeout := clockExchangeTimeout
+		clockExchangeTimeout = 50 * time.Millisecond
+		defer func() { clockExchangeTimeout = timeout }()
+
+		stop := make(chan struct{})
+		defer close(stop)
+		other.SetStreamHandler(clockRequest, func(s inet.Stream) {
+			<-stop
+		})
+
+		start := time.Now()
+		if _, err := node.Clock.SyncPeer(other.ID()); err == nil {
+			t.Error("expected a silent peer to fail the exchange")
+		}
+		if elapsed := time.Since(start); elapsed > time.Second {
+			t.Errorf("took %v to give up", elapsed)
+		}
+	})
+
+	t.Run("Sync forgets the samples of earlier rounds", func(t *testing.T) {
+		node := createTestNode(t)
+		node.Clock.samples[peer.ID("gone")] = []ClockSample{{Offset: time.Hour, RoundTrip: 0}}
+
+		node.Clock.Sync()
+		if node.Clock.Offset() != 0 {
+			t.Errorf("expected a stale sample to be ignored, offset %v", node.Clock.Offset())
+		}
+		if len(node.Clock.samples) != 0 {
+			t.Error("expected the departed peer to be forgotten")
+		}
+	})
+
+	t.Run("Sync converges on a swarm clock", func(t *testing.T) {
+		nodes := []*Node{createTestNode(t), createTestNode(t), createTestNode(t)}
+		skews := []time.Duration{0, 400 * time.Millisecond, -300 * time.Millisecond}
+		for i, node := range nodes {
+			skew := skews[i]
+			node.Clock.now = func() time.Time { return time.Now().Add(skew) }
+			for _, other := range nodes {
+				if other != node {
+					node.Peerstore().AddAddrs(other.ID(), other.Addrs(), ps.PermanentAddrTTL)
+				}
+			}
+		}
+
+		for round := 0; round < 10; round++ {
+			for _, node := range nodes {
+				node.Clock.Sync()
+			}
+		}
+
+		for _, node := range nodes[1:] {
+			difference := node.Clock.Now().Sub(nodes[0].Clock.Now())
+			if difference > 20*time.Millisecond || difference < -20*time.Millisecond {
+				t.Errorf("clocks differ by %v after syncing", difference)
+			}
+		}
+	})
+}
@@ -0,0 +1,228 @@
+package loopnet
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/gogo/protobuf/proto"
+
+	p2p "github.com/acruikshank/loopnet/pb"
+	peer "github.com/libp2p/go-libp2p-peer"
+)
+
+// GrantConductor issues a credential allowing nodeId to send conductor
+// commands to the session. Only the session owner, who is always a
+// conductor, can grant the role.
+func (np *NotificationProtocol) GrantConductor(nodeId string) (*p2p.Credential, error) {
+	selfId := peer.IDB58Encode(np.node.ID())
+	if np.owner != selfId {
+		return nil, errors.New("only the session owner can grant the conductor role")
+	}
+
+	nodePubKey, err := np.node.Peerstore().PubKey(np.node.ID()).Bytes()
+	if err != nil {
+		return nil, err
+	}
+
+	credential := &p2p.Credential{
+		Session:      np.session,
+		NodeId:       nodeId,
+		IssuerId:     selfId,
+		IssuerPubKey: nodePubKey,
+		Sign:         make([]byte, 0),
+	}
+
+	data, err := proto.Marshal(credential)
+	if err != nil {
+		return nil, err
+	}
+	credential.Sign, err = np.node.signData(data)
+	if err != nil {
+		return nil, err
+	}
+	return credential, nil
+}
+
+// SetConductorCredential stores the credential this node presents with its commands.
+func (np *NotificationProtocol) SetConductorCredential(credential *p2p.Credential) error {
+	if !np.isConductor(peer.IDB58Encode(np.node.ID()), credential) {
+		return errors.New("credential does not grant this node the conductor role")
+	}
+
+	np.credentialMux.Lock()
+	defer np.credentialMux.Unlock()
+	np.credential = credential
+	return nil
+}
+
+// MuteAll mutes every note in the session.
+func (np *NotificationProtocol) MuteAll() error {
+	return np.issueCommand(&p2p.Command{Type: p2p.Command_MUTE_ALL})
+}
+
+// UnmuteAll lifts a previous MuteAll.
+func (np *NotificationProtocol) UnmuteAll() error {
+	return np.issueCommand(&p2p.Command{Type: p2p.Command_UNMUTE_ALL})
+}
+
+// SetTempo sets the tempo of every arpeggiator in the session in beats per minute.
+func (np *NotificationProtocol) SetTempo(bpm float32) error {
+	return np.issueCommand(&p2p.Command{Type: p2p.Command_SET_TEMPO, Tempo: bpm})
+}
+
+// SetScale sets the scale every arpeggiator in the session quantizes notes to.
+func (np *NotificationProtocol) SetScale(scale string) error {
+	return np.issueCommand(&p2p.Command{Type: p2p.Command_SET_SCALE, Scale: scale})
+}
+
+// Kick removes a node from the session. Its notes are ignored from then on.
+func (np *NotificationProtocol) Kick(nodeId string) error {
+	return np.issueCommand(&p2p.Command{Type: p2p.Command_KICK, Target: nodeId})
+}
+
+// validateCommand checks that a command can be applied, whichever
+// conductor signed it. Kicks can't be undone, so the owner can't be kicked.
+func (np *NotificationProtocol) validateCommand(command *p2p.Command) error {
+	switch command.Type {
+	case p2p.Command_MUTE_ALL, p2p.Command_UNMUTE_ALL:
+	case p2p.Command_SET_TEMPO:
+		if !validTempo(command.Tempo) {
+			return fmt.Errorf("invalid tempo %v", command.Tempo)
+		}
+	case p2p.Command_SET_SCALE:
+		if _, found := scales[command.Scale]; !found {
+			return fmt.Errorf("unknown scale %s", command.Scale)
+		}
+	case p2p.Command_KICK:
+		if command.Target == "" {
+			return errors.New("kick without a target")
+		}
+		if command.Target == np.owner {
+			return errors.New("the session owner cannot be kicked")
+		}
+	default:
+		return fmt.Errorf("unknown command %v", command.Type)
+	}
+	return nil
+}
+
+// sign a command as this node, apply it locally and let gossip spread it
+func (np *NotificationProtocol) issueCommand(command *p2p.Command) error {
+	if err := np.validateCommand(command); err != nil {
+		return err
+	}
+	if err := np.signCommand(command); err != nil {
+		return err
+	}
+
+	np.NoteStore.OnCommand(*command)
+	return nil
+}
+
+// sign a command as this node with its conductor credential
+func (np *NotificationProtocol) signCommand(command *p2p.Command) error {
+	selfId := peer.IDB58Encode(np.node.ID())
+
+	np.credentialMux.Lock()
+	credential := np.credential
+	np.credentialMux.Unlock()
+
+	if !np.isConductor(selfId, credential) {
+		return errors.New("this node is not a conductor of the session")
+	}
+
+	nodePubKey, err := np.node.Peerstore().PubKey(np.node.ID()).Bytes()
+	if err != nil {
+		return err
+	}
+
+	command.Revision = np.NoteStore.NextCommandRevision()
+	command.Session = np.session
+	command.NodeId = selfId
+	command.NodePubKey = nodePubKey
+	command.Credential = credential
+	command.Sign = make([]byte, 0)
+
+	data, err := proto.Marshal(command)
+	if err != nil {
+		return err
+	}
+	command.Sign, err = np.node.signData(data)
+	return err
+}
+
+// apply the authentic commands issued by conductors of the session
+func (np *NotificationProtocol) onCommands(commands []*p2p.Command) {
+	for _, command := range commands {
+		if command.Session != np.session || !np.node.authenticateCommand(command) {
+			np.logger.Warn("failed to authenticate command", "author", command.NodeId)
+			continue
+		}
+
+		if !np.isConductor(command.NodeId, command.Credential) {
+			np.logger.Warn("rejecting command without conductor role", "author", command.NodeId)
+			continue
+		}
+
+		if err := np.validateCommand(command); err != nil {
+			np.logger.Warn("rejecting invalid command", "err", err, "author", command.NodeId)
+			continue
+		}
+
+		np.NoteStore.OnCommand(*command)
+	}
+}
+
+// whether nodeId may issue commands: the session owner, or a node
+// holding a credential granted by the owner
+func (np *NotificationProtocol) isConductor(nodeId string, credential *p2p.Credential) bool {
+	if np.owner == "" {
+		return false
+	}
+	if nodeId == np.owner {
+		return true
+	}
+	if credential == nil || credential.NodeId != nodeId || credential.Session != np.session {
+		return false
+	}
+	return credential.IssuerId == np.owner && np.node.authenticateCredential(credential)
+}
+
+// Authenticate a command by the signature of the conductor that issued it
+func (n *Node) authenticateCommand(command *p2p.Command) bool {
+	// marshal a copy without the signature, commands are shared with gossip
+	unsigned := *command
+	unsigned.Sign = make([]byte, 0)
+	bin, err := proto.Marshal(&unsigned)
+	if err != nil {
+		n.logger(logNode).Error("failed to marshal command", "err", err)
+		return false
+	}
+
+	nodeId, err := peer.IDB58Decode(command.NodeId)
+	if err != nil {
+		n.logger(logNode).Warn("failed to decode node id from base58", "err", err, "id", command.NodeId)
+		return false
+	}
+
+	return n.verifyData(bin, command.Sign, nodeId, command.NodePubKey)
+}
+
+// Authenticate a conductor credential by the signature of the session owner
+func (n *Node) authenticateCredential(credential *p2p.Credential) bool {
+	unsigned := *credential
+	unsigned.Sign = make([]byte, 0)
+	bin, err := proto.Marshal(&unsigned)
+	if err != nil {
+		n.logger(logNode).Error("failed to marshal credential", "err", err)
+		return false
+	}
+
+	issuerId, err := peer.IDB58Decode(credential.IssuerId)
+	if err != nil {
+		n.logger(logNode).Warn("failed to decode issuer id from base58", "err", err, "id", credential.IssuerId)
+		return false
+	}
+
+	return n.verifyData(bin, credential.Sign, issuerId, credential.IssuerPubKey)
+}
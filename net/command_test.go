@@ -0,0 +1,104 @@
+package loopnet
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	p2p "github.com/acruikshank/loopnet/pb"
+	peer "github.com/libp2p/go-libp2p-peer"
+)
+
+func TestConductorCommands(t *testing.T) {
+	owner := createTestNode(t)
+	jam := owner.HostSession("jam", 60, false)
+	token, _ := jam.Invite(AnyNode, time.Hour)
+
+	conductor := createTestNode(t)
+	conductorJam, _ := conductor.JoinInvitation(token, 62, false)
+	player := createTestNode(t)
+	playerJam, _ := player.JoinInvitation(token, 64, false)
+
+	waitFor(t, func() bool {
+		jam.Notify()
+		return playerJam.NoteStore.ActiveNotes() == 3
+	})
+
+	t.Run("owner commands are applied by every node", func(t *testing.T) {
+		if err := jam.SetTempo(90); err != nil {
+			t.Fatal(err)
+		}
+
+		waitFor(t, func() bool {
+			jam.Notify()
+			return playerJam.NoteStore.Controls().Tempo == 90 && conductorJam.NoteStore.Controls().Tempo == 90
+		})
+	})
+
+	t.Run("nodes without a credential cannot issue commands", func(t *testing.T) {
+		if err := playerJam.MuteAll(); err == nil {
+			t.Error("player issued a command")
+		}
+	})
+
+	t.Run("granted conductors can issue commands", func(t *testing.T) {
+		credential, err := jam.GrantConductor(peer.IDB58Encode(conductor.ID()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := playerJam.SetConductorCredential(credential); err == nil {
+			t.Error("accepted another node's credential")
+		}
+		if err := conductorJam.SetConductorCredential(credential); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := conductorJam.MuteAll(); err != nil {
+			t.Fatal(err)
+		}
+
+		waitFor(t, func() bool {
+			conductorJam.Notify()
+			jam.Notify()
+			return playerJam.NoteStore.Controls().Muted && jam.NoteStore.Controls().Muted
+		})
+	})
+
+	t.Run("invalid commands from conductors are rejected", func(t *testing.T) {
+		if err := conductorJam.Kick(jam.owner); err == nil {
+			t.Error("kicked the session owner")
+		}
+		if err := conductorJam.SetTempo(float32(math.Inf(1))); err == nil {
+			t.Error("set an infinite tempo")
+		}
+
+		// signed by a conductor but never validated by it
+		invalid := []*p2p.Command{
+			{Type: p2p.Command_KICK, Target: jam.owner},
+			{Type: p2p.Command_SET_TEMPO, Tempo: float32(math.NaN())},
+			{Type: p2p.Command_UNKNOWN},
+		}
+		for _, command := range invalid {
+			if err := conductorJam.signCommand(command); err != nil {
+				t.Fatal(err)
+			}
+		}
+		notes := playerJam.NoteStore.ActiveNotes()
+		playerJam.onCommands(invalid)
+
+		if playerJam.NoteStore.ActiveNotes() != notes || playerJam.NoteStore.Controls().Tempo != 90 {
+			t.Errorf("applied invalid commands, controls %v", playerJam.NoteStore.Controls())
+		}
+	})
+
+	t.Run("kicked nodes are removed everywhere", func(t *testing.T) {
+		if err := jam.Kick(peer.IDB58Encode(player.ID())); err != nil {
+			t.Fatal(err)
+		}
+
+		waitFor(t, func() bool {
+			jam.Notify()
+			return conductorJam.NoteStore.ActiveNotes() == 2
+		})
+	})
+}
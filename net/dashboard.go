@@ -0,0 +1,137 @@
+package loopnet
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// characters of node ids shown in the peer table, taken from the end
+// since ids share their multihash prefix
+const shortIdLength = 10
+
+var noteNames = []string{"C", "C#", "D", "D#", "E", "F", "F#", "G", "G#", "A", "A#", "B"}
+
+// NoteName returns the name of a midi note number, e.g. C4 for 60.
+func NoteName(note int) string {
+	return fmt.Sprintf("%s%d", noteNames[note%12], note/12-1)
+}
+
+// Dashboard renders the state of a session for a terminal and changes the
+// local note from key presses.
+type Dashboard struct {
+	np          *NotificationProtocol
+	arpeggiator *Arpeggiator
+
+	// gossip rates measured between renders
+	sampled     time.Time
+	sent        uint64
+	received    uint64
+	sendRate    float64
+	receiveRate float64
+}
+
+// NewDashboard creates a dashboard for a session and the arpeggiator playing it.
+func NewDashboard(np *NotificationProtocol, arpeggiator *Arpeggiator) *Dashboard {
+	d := &Dashboard{np: np, arpeggiator: arpeggiator, sampled: time.Now()}
+	d.sent, d.received = np.GossipCounts()
+	return d
+}
+
+// Render clears the terminal and draws the dashboard.
+func (d *Dashboard) Render(w io.Writer) {
+	d.sampleRates()
+
+	b := &strings.Builder{}
+	b.WriteString("\x1b[H\x1b[2J") // home, clear screen
+	note, mute := d.np.LocalNote()
+	fmt.Fprintf(b, "loopnet  session %s  node %s  %s%s\r\n\r\n", d.np.Session(), shortId(d.np.NoteStore.SelfId()), NoteName(note), muteLabel(mute))
+
+	fmt.Fprintf(b, "%-12s %-5s %-5s %9s %4s\r\n", "NODE", "NOTE", "MUTE", "REVISION", "LAG")
+	for _, status := range d.np.NoteStore.Statuses() {
+		fmt.Fprintf(b, "%-12s %-5s %-5v %9d %4d\r\n",
+			shortId(status.NodeId), NoteName(int(status.Note)), status.Mute, status.Revision, status.Lag)
+	}
+
+	sequence := make([]string, 0)
+	for _, note := range d.arpeggiator.Sequence() {
+		sequence = append(sequence, NoteName(note))
+	}
+	parameters := d.arpeggiator.Parameters()
+	fmt.Fprintf(b, "\r\narpeggio  %s\r\n", strings.Join(sequence, " "))
+	fmt.Fprintf(b, "tempo     %.1f bpm  %s  root %s\r\n", parameters.Tempo, parameters.Scale, noteNames[parameters.Root%12])
+	status := d.np.GossipStatus()
+	fmt.Fprintf(b, "gossip    %.1f sent/s  %.1f received/s  every %v to %d peers  %.1f changes/s\r\n",
+		d.sendRate, d.receiveRate, status.Interval.Round(time.Millisecond), status.Fanout, status.UpdateRate)
+	agreement := d.np.Agreement()
+	fmt.Fprintf(b, "agreement %.0f%% of %d peers%s\r\n", agreement.Percent(), agreement.Peers, alertLabel(agreement))
+	b.WriteString("\r\n+/- semitone  [/] octave  m mute  q quit\r\n")
+
+	io.WriteString(w, b.String())
+}
+
+// HandleKey applies a key press to the local note. It returns false when
+// the key asks to quit.
+func (d *Dashboard) HandleKey(key byte) bool {
+	note, mute := d.np.LocalNote()
+	switch key {
+	case '+', '=':
+		note++
+	case '-', '_':
+		note--
+	case ']':
+		note += 12
+	case '[':
+		note -= 12
+	case 'm', 'M':
+		mute = !mute
+	case 'q', 'Q', 3: // ctrl-c
+		return false
+	default:
+		return true
+	}
+
+	if note < 0 || note > 127 {
+		return true
+	}
+	d.np.SetNote(note, mute)
+	d.np.Notify()
+	return true
+}
+
+// update gossip rates from the counts since the last sample
+func (d *Dashboard) sampleRates() {
+	now := time.Now()
+	elapsed := now.Sub(d.sampled).Seconds()
+	if elapsed < 0.5 {
+		return
+	}
+
+	sent, received := d.np.GossipCounts()
+	d.sendRate = float64(sent-d.sent) / elapsed
+	d.receiveRate = float64(received-d.received) / elapsed
+	d.sampled, d.sent, d.received = now, sent, received
+}
+
+// the alert shown after the agreement, if any
+func alertLabel(agreement Agreement) string {
+	if agreement.Alert == "" {
+		return ""
+	}
+	return fmt.Sprintf("  %s for %v", strings.ToUpper(agreement.Alert), time.Since(agreement.Since).Round(time.Second))
+}
+
+func shortId(nodeId string) string {
+	if len(nodeId) > shortIdLength {
+		return nodeId[len(nodeId)-shortIdLength:]
+	}
+	return nodeId
+}
+
+func muteLabel(mute bool) string {
+	if mute {
+		return " (muted)"
+	}
+	return ""
+}
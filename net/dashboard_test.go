@@ -0,0 +1,63 @@
+package loopnet
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDashboard(t *testing.T) {
+	t.Run("NoteName", func(t *testing.T) {
+		for note, name := range map[int]string{60: "C4", 61: "C#4", 69: "A4", 0: "C-1", 127: "G9"} {
+			if NoteName(note) != name {
+				t.Errorf("expected %s for %d, got %s", name, note, NoteName(note))
+			}
+		}
+	})
+
+	node := createTestNode(t)
+	jam := node.JoinSession("jam", 60, false)
+	jam.NoteStore.OnNote(*createNote("QmPeerNodeIdentifier", 3, 64, true))
+	dashboard := NewDashboard(jam, NewArpeggiator(jam.NoteStore, jam.Parameters, nil))
+
+	t.Run("Render", func(t *testing.T) {
+		out := &strings.Builder{}
+		dashboard.Render(out)
+
+		t.Run("shows every peer", func(t *testing.T) {
+			if !strings.Contains(out.String(), "Identifier") || !strings.Contains(out.String(), "E4") {
+				t.Errorf("peer missing from\n%s", out)
+			}
+		})
+
+		t.Run("shows the arpeggio", func(t *testing.T) {
+			if !strings.Contains(out.String(), "arpeggio  C4\r\n") {
+				t.Errorf("arpeggio missing from\n%s", out)
+			}
+		})
+	})
+
+	t.Run("HandleKey", func(t *testing.T) {
+		t.Run("changes the pitch", func(t *testing.T) {
+			dashboard.HandleKey('+')
+			dashboard.HandleKey(']')
+
+			if note, _ := jam.LocalNote(); note != 73 {
+				t.Errorf("expected 73, got %d", note)
+			}
+		})
+
+		t.Run("toggles mute", func(t *testing.T) {
+			dashboard.HandleKey('m')
+
+			if _, mute := jam.LocalNote(); !mute {
+				t.Error("did not mute")
+			}
+		})
+
+		t.Run("quits", func(t *testing.T) {
+			if dashboard.HandleKey('q') {
+				t.Error("did not quit")
+			}
+		})
+	})
+}
@@ -0,0 +1,241 @@
+package loopnet
+
+import (
+	"bytes"
+	"sync"
+	"time"
+)
+
+// DefaultDivergenceWindow is how long a peer must keep disagreeing with
+// this node's notes before the disagreement is reported as divergence.
+const DefaultDivergenceWindow = 30 * time.Second
+
+// interval between recomputing the state hash sent with notifications
+const stateHashInterval = time.Second
+
+// recent state hashes of this node a peer's hash is compared to, so that a
+// peer a few changes behind still agrees
+const stateHashHistory = 5
+
+// peers that have not reported their state for this long are forgotten
+const peerStateExpiry = 2 * time.Minute
+
+// divergence alerts
+const (
+	AlertDivergence = "divergence" // peers keep disagreeing with this node
+	AlertPartition  = "partition"  // peers keep disagreeing with this node but agree among themselves
+)
+
+// Agreement is how many peers share this node's view of the session's
+// notes, by comparing the state hashes they gossip.
+type Agreement struct {
+	Peers    int       // peers that reported their state recently
+	Agreeing int       // peers whose latest state matched a recent state of this node
+	Diverged int       // peers disagreeing for longer than the divergence window
+	Alert    string    // AlertPartition, AlertDivergence or empty
+	Since    time.Time // when the alert was raised, zero without an alert
+}
+
+// Percent returns the share of peers in agreement, 100 without peers.
+func (a Agreement) Percent() float64 {
+	if a.Peers == 0 {
+		return 100
+	}
+	return 100 * float64(a.Agreeing) / float64(a.Peers)
+}
+
+// the latest state a peer reported
+type peerState struct {
+	hash     []byte
+	received time.Time
+	diverged time.Time // first report disagreeing since the peer last agreed, zero while agreeing
+}
+
+// divergence compares the state hash of this node, the Merkle root of its
+// notes, with the hashes its peers gossip. Notes changing keep hashes apart
+// briefly, so a peer only counts as diverged once its reports have
+// disagreed for a whole window. Diverged peers sharing a hash point to a
+// partition: groups of nodes converging on different states.
+type divergence struct {
+	window        time.Duration
+	hashes        [][]byte              // recent state hashes of this node, latest last
+	computed      time.Time             // when the latest hash was computed
+	peers         map[string]*peerState // latest state of each peer by node id
+	alert         string
+	since         time.Time
+	divergenceMux *sync.Mutex
+}
+
+func newDivergence(window time.Duration) *divergence {
+	return &divergence{
+		window:        window,
+		hashes:        make([][]byte, 0, stateHashHistory),
+		peers:         make(map[string]*peerState),
+		divergenceMux: &sync.Mutex{},
+	}
+}
+
+// latest returns the latest state hash of this node and whether it was
+// computed recently enough to send.
+func (d *divergence) latest(now time.Time) ([]byte, bool) {
+	d.divergenceMux.Lock()
+	defer d.divergenceMux.Unlock()
+
+	if len(d.hashes) == 0 {
+		return nil, false
+	}
+	return d.hashes[len(d.hashes)-1], now.Sub(d.computed) < stateHashInterval
+}
+
+// update records a newly computed state hash of this node. Peers that
+// reported it agree again.
+func (d *divergence) update(hash []byte, now time.Time) {
+	d.divergenceMux.Lock()
+	defer d.divergenceMux.Unlock()
+
+	d.computed = now
+	if len(d.hashes) > 0 && bytes.Equal(d.hashes[len(d.hashes)-1], hash) {
+		return
+	}
+	if len(d.hashes) == stateHashHistory {
+		d.hashes = append(d.hashes[:0], d.hashes[1:]...)
+	}
+	d.hashes = append(d.hashes, hash)
+
+	for _, peer := range d.peers {
+		if bytes.Equal(peer.hash, hash) {
+			peer.diverged = time.Time{}
+		}
+	}
+}
+
+// report records the state hash a peer sent.
+func (d *divergence) report(nodeId string, hash []byte, now time.Time) {
+	d.divergenceMux.Lock()
+	defer d.divergenceMux.Unlock()
+
+	peer, found := d.peers[nodeId]
+	if !found {
+		peer = &peerState{}
+		d.peers[nodeId] = peer
+	}
+	peer.hash, peer.received = hash, now
+
+	if d.recentLocked(hash) {
+		peer.diverged = time.Time{}
+	} else if peer.diverged.IsZero() {
+		peer.diverged = now
+	}
+}
+
+// callers must hold divergenceMux
+func (d *divergence) recentLocked(hash []byte) bool {
+	for _, recent := range d.hashes {
+		if bytes.Equal(recent, hash) {
+			return true
+		}
+	}
+	return false
+}
+
+// evaluate forgets the peers that stopped reporting and returns the
+// current agreement and whether its alert changed.
+func (d *divergence) evaluate(now time.Time) (Agreement, bool) {
+	d.divergenceMux.Lock()
+	defer d.divergenceMux.Unlock()
+
+	agreement := Agreement{}
+	camps := make(map[string]int) // diverged peers by hash
+	for nodeId, peer := range d.peers {
+		if now.Sub(peer.received) > peerStateExpiry {
+			delete(d.peers, nodeId)
+			continue
+		}
+		agreement.Peers++
+		if peer.diverged.IsZero() {
+			agreement.Agreeing++
+		} else if peer.received.Sub(peer.diverged) >= d.window {
+			agreement.Diverged++
+			camps[string(peer.hash)]++
+		}
+	}
+
+	alert := ""
+	if agreement.Diverged > 0 {
+		alert = AlertDivergence
+	}
+	for _, count := range camps {
+		if count > 1 {
+			alert = AlertPartition
+		}
+	}
+
+	changed := alert != d.alert
+	if changed {
+		d.alert, d.since = alert, now
+		if alert == "" {
+			d.since = time.Time{}
+		}
+	}
+	agreement.Alert, agreement.Since = d.alert, d.since
+	return agreement, changed
+}
+
+func (d *divergence) setWindow(window time.Duration) {
+	d.divergenceMux.Lock()
+	defer d.divergenceMux.Unlock()
+
+	d.window = window
+}
+
+// stateHash returns the hash of the notes this node stores, recomputed at
+// most every stateHashInterval. It is the root of the tree reconciliation
+// compares, so a diverged peer can be reconciled with.
+func (np *NotificationProtocol) stateHash() []byte {
+	now := time.Now()
+	if hash, fresh := np.divergence.latest(now); fresh {
+		return hash
+	}
+	hash := newMerkleTree(np.NoteStore.Notes()).root().Hash
+	np.divergence.update(hash, now)
+	return hash
+}
+
+// compare the state hash a peer sent with this node's
+func (np *NotificationProtocol) onStateHash(hash []byte, from string) {
+	if len(hash) == 0 || from == "" {
+		return
+	}
+	np.stateHash()
+	np.divergence.report(from, hash, time.Now())
+	np.Agreement()
+}
+
+// Agreement returns how many peers share this node's view of the session's
+// notes, raising an alert when peers keep disagreeing with it.
+func (np *NotificationProtocol) Agreement() Agreement {
+	agreement, changed := np.divergence.evaluate(time.Now())
+	if !changed {
+		return agreement
+	}
+
+	switch agreement.Alert {
+	case AlertPartition:
+		np.Metrics.DivergenceAlerts.Inc()
+		np.logger.Warn("swarm partitioned, groups of peers agree on different notes",
+			"agreement", agreement.Percent(), "peers", agreement.Peers, "diverged", agreement.Diverged)
+	case AlertDivergence:
+		np.Metrics.DivergenceAlerts.Inc()
+		np.logger.Warn("notes diverged from peers",
+			"agreement", agreement.Percent(), "peers", agreement.Peers, "diverged", agreement.Diverged)
+	default:
+		np.logger.Info("notes in agreement with peers again", "agreement", agreement.Percent(), "peers", agreement.Peers)
+	}
+	return agreement
+}
+
+// SetDivergenceWindow sets how long peers must keep disagreeing with this
+// node before an alert is raised.
+func (np *NotificationProtocol) SetDivergenceWindow(window time.Duration) {
+	np.divergence.setWindow(window)
+}
@@ -0,0 +1,141 @@
+package loopnet
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestDivergence(t *testing.T) {
+	start := time.Unix(1000, 0)
+	window := 10 * time.Second
+	a, b := []byte{1}, []byte{2}
+
+	t.Run("counts peers reporting a recent state as agreeing", func(t *testing.T) {
+		d := newDivergence(window)
+		d.update(a, start)
+		d.update(b, start.Add(time.Second))
+		d.report("p1", a, start.Add(time.Second))
+		d.report("p2", b, start.Add(time.Second))
+		d.report("p3", []byte{3}, start.Add(time.Second))
+
+		agreement, _ := d.evaluate(start.Add(time.Second))
+		if agreement.Peers != 3 || agreement.Agreeing != 2 || agreement.Alert != "" {
+			t.Errorf("unexpected agreement %+v", agreement)
+		}
+		if percent := agreement.Percent(); percent < 66 || percent > 67 {
+			t.Errorf("expected 2 of 3 peers, got %v%%", percent)
+		}
+	})
+
+	t.Run("agrees with a peer once this node reaches its state", func(t *testing.T) {
+		d := newDivergence(window)
+		d.update(a, start)
+		d.report("p1", b, start)
+		d.update(b, start.Add(time.Second))
+
+		if agreement, _ := d.evaluate(start.Add(time.Second)); agreement.Agreeing != 1 {
+			t.Errorf("unexpected agreement %+v", agreement)
+		}
+	})
+
+	t.Run("compares with the last few states only", func(t *testing.T) {
+		d := newDivergence(window)
+		for i := 0; i <= stateHashHistory; i++ {
+			d.update([]byte{byte(10 + i)}, start)
+		}
+		d.report("p1", []byte{10}, start)
+
+		if agreement, _ := d.evaluate(start); agreement.Agreeing != 0 {
+			t.Errorf("expected the oldest state to be forgotten, got %+v", agreement)
+		}
+	})
+
+	t.Run("alerts when a peer keeps disagreeing for the window", func(t *testing.T) {
+		d := newDivergence(window)
+		d.update(a, start)
+		d.report("p1", a, start)
+		d.report("p2", b, start)
+
+		d.report("p2", b, start.Add(window/2))
+		if agreement, changed := d.evaluate(start.Add(window / 2)); changed || agreement.Alert != "" {
+			t.Errorf("expected no alert within the window, got %+v", agreement)
+		}
+
+		d.report("p2", b, start.Add(window))
+		agreement, changed := d.evaluate(start.Add(window))
+		if !changed || agreement.Alert != AlertDivergence || agreement.Diverged != 1 || !agreement.Since.Equal(start.Add(window)) {
+			t.Errorf("expected a divergence alert, got %+v", agreement)
+		}
+		if _, changed := d.evaluate(start.Add(window)); changed {
+			t.Error("expected the alert to be raised once")
+		}
+
+		d.report("p2", a, start.Add(2*window))
+		agreement, changed = d.evaluate(start.Add(2 * window))
+		if !changed || agreement.Alert != "" || !agreement.Since.IsZero() {
+			t.Errorf("expected the alert to clear, got %+v", agreement)
+		}
+	})
+
+	t.Run("does not alert without reports spanning the window", func(t *testing.T) {
+		d := newDivergence(window)
+		d.update(a, start)
+		d.report("p1", b, start)
+
+		if agreement, _ := d.evaluate(start.Add(2 * window)); agreement.Alert != "" {
+			t.Errorf("expected no alert for a single report, got %+v", agreement)
+		}
+	})
+
+	t.Run("alerts a partition when diverged peers agree among themselves", func(t *testing.T) {
+		d := newDivergence(window)
+		d.update(a, start)
+		for _, at := range []time.Time{start, start.Add(window)} {
+			d.report("p1", b, at)
+			d.report("p2", b, at)
+		}
+
+		if agreement, _ := d.evaluate(start.Add(window)); agreement.Alert != AlertPartition || agreement.Diverged != 2 {
+			t.Errorf("expected a partition alert, got %+v", agreement)
+		}
+	})
+
+	t.Run("forgets peers that stop reporting", func(t *testing.T) {
+		d := newDivergence(window)
+		d.update(a, start)
+		d.report("p1", a, start)
+
+		agreement, _ := d.evaluate(start.Add(peerStateExpiry + time.Second))
+		if agreement.Peers != 0 || agreement.Percent() != 100 {
+			t.Errorf("expected no peers, got %+v", agreement)
+		}
+	})
+
+	t.Run("peers agree once their notes converge", func(t *testing.T) {
+		node, other := createTestNode(t), createTestNode(t)
+		jam := node.JoinSession("jam", 60, false)
+		otherJam := other.JoinSession("jam", 64, false)
+
+		otherJam.ConnectToHost(node)
+		waitFor(t, func() bool { return jam.Agreement().Peers == 1 })
+		if agreement := jam.Agreement(); agreement.Agreeing != 0 {
+			t.Errorf("expected the peer to disagree before it knows this node, got %+v", agreement)
+		}
+
+		// fresh hashes once both stores hold both notes
+		jam.ConnectToHost(other)
+		waitFor(t, func() bool { return otherJam.NoteStore.ActiveNotes() == 2 })
+		for _, np := range []*NotificationProtocol{jam, otherJam} {
+			np.divergence.divergenceMux.Lock()
+			np.divergence.computed = time.Time{}
+			np.divergence.divergenceMux.Unlock()
+		}
+		otherJam.sendNotification(node.ID())
+
+		waitFor(t, func() bool { return jam.Agreement().Agreeing == 1 })
+		if !bytes.Equal(jam.stateHash(), otherJam.stateHash()) {
+			t.Error("expected equal state hashes")
+		}
+	})
+}
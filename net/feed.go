@@ -0,0 +1,174 @@
+package loopnet
+
+import (
+	"embed"
+	"encoding/json"
+	"io/fs"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// time between checks for note changes in Watch
+const feedInterval = 50 * time.Millisecond
+
+// events queued for a client before it is considered too slow and dropped
+const feedClientBuffer = 256
+
+//go:embed web
+var webFiles embed.FS
+
+// FeedEvent is a message streamed to feed clients.
+type FeedEvent struct {
+	Type   string     `json:"type"`             // "note", "remove" or "step"
+	Note   *NoteState `json:"note,omitempty"`   // new or changed note
+	NodeId string     `json:"nodeId,omitempty"` // node whose note was removed
+	Step   *Step      `json:"step,omitempty"`   // step played by the arpeggiator
+}
+
+// Feed streams note changes and arpeggiator steps of a session to
+// WebSocket clients as JSON events.
+type Feed struct {
+	np      *NotificationProtocol
+	notes   map[string]NoteState // notes as last sent to clients
+	clients map[chan []byte]bool
+	feedMux *sync.Mutex
+}
+
+// NewFeed creates a feed for a session.
+func NewFeed(np *NotificationProtocol) *Feed {
+	f := &Feed{
+		np:      np,
+		notes:   make(map[string]NoteState),
+		clients: make(map[chan []byte]bool),
+		feedMux: &sync.Mutex{},
+	}
+	f.update()
+	return f
+}
+
+// ServeHTTP accepts a WebSocket client and streams events to it, starting
+// with every stored note.
+func (f *Feed) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ws, err := upgradeWebsocket(w, r)
+	if err != nil {
+		f.np.node.logger(logHTTP).Warn("failed to accept feed client", "err", err, "remote", r.RemoteAddr)
+		return
+	}
+	defer ws.Close()
+
+	f.feedMux.Lock()
+	events := make(chan []byte, len(f.notes)+feedClientBuffer)
+	for _, note := range f.notes {
+		note := note
+		events <- f.encode(FeedEvent{Type: "note", Note: &note})
+	}
+	f.clients[events] = true
+	f.feedMux.Unlock()
+
+	closed := make(chan struct{})
+	go func() {
+		ws.ReadUntilClose()
+		close(closed)
+	}()
+
+	defer f.removeClient(events)
+	for {
+		select {
+		case <-closed:
+			return
+		case data, ok := <-events:
+			if !ok {
+				return
+			}
+			if err := ws.WriteText(data); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// OnStep streams a step played by the arpeggiator. Pass it to
+// NewArpeggiator, along with any other step handlers.
+func (f *Feed) OnStep(step Step) {
+	f.feedMux.Lock()
+	defer f.feedMux.Unlock()
+
+	f.broadcast(FeedEvent{Type: "step", Step: &step})
+}
+
+// Watch streams note changes until stop is closed.
+func (f *Feed) Watch(stop <-chan struct{}) {
+	for {
+		select {
+		case <-stop:
+			return
+		case <-time.After(feedInterval):
+		}
+
+		f.update()
+	}
+}
+
+// Visualizer serves the web page that renders the feed.
+func Visualizer() http.Handler {
+	web, err := fs.Sub(webFiles, "web")
+	if err != nil {
+		panic(err)
+	}
+	return http.FileServer(http.FS(web))
+}
+
+// compare the stored notes to the notes last sent and stream the changes
+func (f *Feed) update() {
+	f.feedMux.Lock()
+	defer f.feedMux.Unlock()
+
+	seen := make(map[string]bool)
+	for _, data := range f.np.NoteStore.Notes() {
+		note := newNoteState(&data)
+		seen[note.NodeId] = true
+		if f.notes[note.NodeId] != note {
+			f.notes[note.NodeId] = note
+			f.broadcast(FeedEvent{Type: "note", Note: &note})
+		}
+	}
+
+	for nodeId := range f.notes {
+		if !seen[nodeId] {
+			delete(f.notes, nodeId)
+			f.broadcast(FeedEvent{Type: "remove", NodeId: nodeId})
+		}
+	}
+}
+
+// callers must hold feedMux. Clients that fall behind are dropped.
+func (f *Feed) broadcast(event FeedEvent) {
+	data := f.encode(event)
+	for events := range f.clients {
+		select {
+		case events <- data:
+		default:
+			delete(f.clients, events)
+			close(events)
+		}
+	}
+}
+
+func (f *Feed) removeClient(events chan []byte) {
+	f.feedMux.Lock()
+	defer f.feedMux.Unlock()
+
+	if f.clients[events] {
+		delete(f.clients, events)
+		close(events)
+	}
+}
+
+func (f *Feed) encode(event FeedEvent) []byte {
+	data, err := json.Marshal(event)
+	if err != nil {
+		f.np.node.logger(logHTTP).Error("failed to encode feed event", "err", err)
+	}
+	return data
+}
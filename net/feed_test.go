@@ -0,0 +1,171 @@
+package loopnet
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	p2p "github.com/acruikshank/loopnet/pb"
+)
+
+// a minimal WebSocket client reading text frames from a feed
+type testFeedClient struct {
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+func dialTestFeed(t *testing.T, url string) *testFeedClient {
+	conn, err := net.Dial("tcp", strings.TrimPrefix(url, "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key := make([]byte, 16)
+	rand.Read(key)
+	req, _ := http.NewRequest(http.MethodGet, url+"/feed", nil)
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Sec-WebSocket-Key", base64.StdEncoding.EncodeToString(key))
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	req.Header.Set("Origin", url)
+	req.Write(conn)
+
+	reader := bufio.NewReader(conn)
+	res, err := http.ReadResponse(reader, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("expected 101, got %d", res.StatusCode)
+	}
+	return &testFeedClient{conn: conn, reader: reader}
+}
+
+// read events until one matches or the feed goes quiet
+func (c *testFeedClient) waitForEvent(t *testing.T, match func(FeedEvent) bool) FeedEvent {
+	for {
+		c.conn.SetReadDeadline(time.Now().Add(time.Second))
+		header := make([]byte, 2)
+		if _, err := io.ReadFull(c.reader, header); err != nil {
+			t.Fatal("no matching event:", err)
+		}
+		size := int(header[1] & 0x7f)
+		if size == 126 {
+			extended := make([]byte, 2)
+			io.ReadFull(c.reader, extended)
+			size = int(extended[0])<<8 | int(extended[1])
+		}
+		payload := make([]byte, size)
+		io.ReadFull(c.reader, payload)
+
+		event := FeedEvent{}
+		if err := json.Unmarshal(payload, &event); err != nil {
+			t.Fatal(err)
+		}
+		if match(event) {
+			return event
+		}
+	}
+}
+
+func TestFeed(t *testing.T) {
+	node := createTestNode(t)
+	jam := node.JoinSession("jam", 60, false)
+	feed := NewFeed(jam)
+	stop := make(chan struct{})
+	defer close(stop)
+	go feed.Watch(stop)
+
+	api := NewAPI(jam)
+	api.Handle("/feed", feed)
+	api.Handle("/", Visualizer())
+	server := httptest.NewServer(api)
+	defer server.Close()
+
+	client := dialTestFeed(t, server.URL)
+	defer client.conn.Close()
+
+	t.Run("starts with the stored notes", func(t *testing.T) {
+		event := client.waitForEvent(t, func(e FeedEvent) bool { return e.Type == "note" })
+		if event.Note.Note != 60 {
+			t.Errorf("unexpected note %v", event.Note)
+		}
+	})
+
+	t.Run("streams note changes", func(t *testing.T) {
+		jam.SetNote(64, false)
+
+		client.waitForEvent(t, func(e FeedEvent) bool {
+			return e.Type == "note" && e.Note.Note == 64 && e.Note.Revision == 1
+		})
+	})
+
+	t.Run("streams new and removed notes", func(t *testing.T) {
+		other := createNote("other", 1, 67, false)
+		jam.NoteStore.OnNote(*other)
+		client.waitForEvent(t, func(e FeedEvent) bool { return e.Type == "note" && e.Note.NodeId == "other" })
+
+		jam.NoteStore.OnCommand(p2p.Command{Type: p2p.Command_KICK, Target: "other", Revision: 1})
+		client.waitForEvent(t, func(e FeedEvent) bool { return e.Type == "remove" && e.NodeId == "other" })
+	})
+
+	t.Run("streams arpeggiator steps", func(t *testing.T) {
+		feed.OnStep(Step{Index: 2, Note: 64})
+
+		client.waitForEvent(t, func(e FeedEvent) bool {
+			return e.Type == "step" && e.Step.Index == 2 && e.Step.Note == 64
+		})
+	})
+
+	t.Run("serves the visualizer", func(t *testing.T) {
+		res, err := http.Get(server.URL + "/")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		page, _ := ioutil.ReadAll(res.Body)
+
+		if res.StatusCode != http.StatusOK || !strings.Contains(string(page), "/feed") {
+			t.Errorf("unexpected page (%d)", res.StatusCode)
+		}
+	})
+
+	t.Run("rejects requests that are not upgrades", func(t *testing.T) {
+		res, err := http.Get(server.URL + "/feed")
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+
+		if res.StatusCode != http.StatusBadRequest {
+			t.Errorf("expected 400, got %d", res.StatusCode)
+		}
+	})
+
+	t.Run("rejects upgrades from other origins", func(t *testing.T) {
+		req, _ := http.NewRequest(http.MethodGet, server.URL+"/feed", nil)
+		req.Header.Set("Upgrade", "websocket")
+		req.Header.Set("Connection", "Upgrade")
+		req.Header.Set("Sec-WebSocket-Key", base64.StdEncoding.EncodeToString([]byte("0123456789abcdef")))
+		req.Header.Set("Sec-WebSocket-Version", "13")
+		req.Header.Set("Origin", "http://example.com")
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+
+		if res.StatusCode != http.StatusForbidden {
+			t.Errorf("expected 403, got %d", res.StatusCode)
+		}
+	})
+}
@@ -0,0 +1,146 @@
+package loopnet
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/gogo/protobuf/proto"
+
+	p2p "github.com/acruikshank/loopnet/pb"
+	peer "github.com/libp2p/go-libp2p-peer"
+)
+
+// invitation node id that admits any node holding the invitation
+const AnyNode = "*"
+
+// HostSession starts a private session owned by this node. Only this node can
+// invite others, and notes are only accepted from nodes it has admitted.
+func (n *Node) HostSession(session string, note int, mute bool) *NotificationProtocol {
+	return n.joinSession(session, peer.IDB58Encode(n.ID()), nil, note, mute)
+}
+
+// JoinInvitation joins the private session described by an invitation token
+// and introduces this node to the invitation's bootstrap nodes.
+func (n *Node) JoinInvitation(token string, note int, mute bool) (*NotificationProtocol, error) {
+	invitation, err := DecodeInvitation(token)
+	if err != nil {
+		return nil, err
+	}
+
+	selfId := peer.IDB58Encode(n.ID())
+	if err := n.validateInvitation(invitation, selfId); err != nil {
+		return nil, err
+	}
+
+	proof := &p2p.Admission{NodeId: selfId, Invitation: invitation}
+	np := n.joinSession(invitation.Session, invitation.IssuerId, proof, note, mute)
+
+	for _, address := range invitation.Bootstrap {
+		if err := np.ConnectToAddress(address); err != nil {
+			np.logger.Warn("failed to connect to bootstrap node", "err", err, "address", address)
+		}
+	}
+	return np, nil
+}
+
+// Invite issues an invitation token to the session for nodeId (or AnyNode)
+// that expires after ttl. Only the session owner can issue invitations.
+func (np *NotificationProtocol) Invite(nodeId string, ttl time.Duration) (string, error) {
+	selfId := peer.IDB58Encode(np.node.ID())
+	if np.owner != selfId {
+		return "", errors.New("only the session owner can issue invitations")
+	}
+
+	nodePubKey, err := np.node.Peerstore().PubKey(np.node.ID()).Bytes()
+	if err != nil {
+		return "", err
+	}
+
+	bootstrap := make([]string, 0)
+	for _, addr := range np.node.Addrs() {
+		bootstrap = append(bootstrap, fmt.Sprintf("%s/ipfs/%s", addr, selfId))
+	}
+
+	invitation := &p2p.Invitation{
+		Session:      np.session,
+		Bootstrap:    bootstrap,
+		NodeId:       nodeId,
+		Expires:      time.Now().Add(ttl).Unix(),
+		IssuerId:     selfId,
+		IssuerPubKey: nodePubKey,
+		Sign:         make([]byte, 0),
+	}
+
+	data, err := proto.Marshal(invitation)
+	if err != nil {
+		return "", err
+	}
+	invitation.Sign, err = np.node.signData(data)
+	if err != nil {
+		return "", err
+	}
+
+	return EncodeInvitation(invitation)
+}
+
+// EncodeInvitation serializes an invitation into a copy-pasteable token.
+func EncodeInvitation(invitation *p2p.Invitation) (string, error) {
+	data, err := proto.Marshal(invitation)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+// DecodeInvitation parses a token created by EncodeInvitation.
+// The invitation is not authenticated.
+func DecodeInvitation(token string) (*p2p.Invitation, error) {
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, err
+	}
+	invitation := &p2p.Invitation{}
+	err = proto.Unmarshal(data, invitation)
+	if err != nil {
+		return nil, err
+	}
+	return invitation, nil
+}
+
+// check that an invitation was signed by its issuer, has not expired
+// and admits nodeId
+func (n *Node) validateInvitation(invitation *p2p.Invitation, nodeId string) error {
+	if !n.authenticateInvitation(invitation) {
+		return errors.New("invitation signature is invalid")
+	}
+	if time.Now().Unix() > invitation.Expires {
+		return errors.New("invitation has expired")
+	}
+	if invitation.NodeId != AnyNode && invitation.NodeId != nodeId {
+		return errors.New("invitation was issued to another node")
+	}
+	return nil
+}
+
+// Authenticate an invitation by the signature of its issuer
+func (n *Node) authenticateInvitation(invitation *p2p.Invitation) bool {
+	// marshal the invitation without its signature, then restore it
+	sign := invitation.Sign
+	invitation.Sign = make([]byte, 0)
+	bin, err := proto.Marshal(invitation)
+	invitation.Sign = sign
+	if err != nil {
+		n.logger(logNode).Error("failed to marshal invitation", "err", err)
+		return false
+	}
+
+	issuerId, err := peer.IDB58Decode(invitation.IssuerId)
+	if err != nil {
+		n.logger(logNode).Warn("failed to decode issuer id from base58", "err", err, "id", invitation.IssuerId)
+		return false
+	}
+
+	return n.verifyData(bin, sign, issuerId, invitation.IssuerPubKey)
+}
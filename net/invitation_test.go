@@ -0,0 +1,113 @@
+package loopnet
+
+import (
+	"testing"
+	"time"
+
+	peer "github.com/libp2p/go-libp2p-peer"
+)
+
+func TestInvitations(t *testing.T) {
+	owner := createTestNode(t)
+	jam := owner.HostSession("jam", 60, false)
+
+	t.Run("Invite", func(t *testing.T) {
+		t.Run("issues tokens that decode to the session", func(t *testing.T) {
+			token, err := jam.Invite(AnyNode, time.Hour)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			invitation, err := DecodeInvitation(token)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if invitation.Session != "jam" || invitation.NodeId != AnyNode || len(invitation.Bootstrap) == 0 {
+				t.Errorf("unexpected invitation %v", invitation)
+			}
+			if err := owner.validateInvitation(invitation, "anyone"); err != nil {
+				t.Error(err)
+			}
+		})
+
+		t.Run("only the owner can invite", func(t *testing.T) {
+			other := createTestNode(t)
+			open := other.JoinSession("open", 60, false)
+
+			if _, err := open.Invite(AnyNode, time.Hour); err == nil {
+				t.Error("issued an invitation to an open session")
+			}
+		})
+	})
+
+	t.Run("JoinInvitation", func(t *testing.T) {
+		t.Run("rejects tampered invitations", func(t *testing.T) {
+			token, _ := jam.Invite(AnyNode, time.Hour)
+			invitation, _ := DecodeInvitation(token)
+			invitation.Session = "other"
+			tampered, _ := EncodeInvitation(invitation)
+
+			if _, err := createTestNode(t).JoinInvitation(tampered, 62, false); err == nil {
+				t.Error("joined with a tampered invitation")
+			}
+		})
+
+		t.Run("rejects expired invitations", func(t *testing.T) {
+			token, _ := jam.Invite(AnyNode, -time.Minute)
+
+			if _, err := createTestNode(t).JoinInvitation(token, 62, false); err == nil {
+				t.Error("joined with an expired invitation")
+			}
+		})
+
+		t.Run("rejects invitations issued to other nodes", func(t *testing.T) {
+			token, _ := jam.Invite(peer.IDB58Encode(createTestNode(t).ID()), time.Hour)
+
+			if _, err := createTestNode(t).JoinInvitation(token, 62, false); err == nil {
+				t.Error("joined with another node's invitation")
+			}
+		})
+
+		t.Run("admits the invited node", func(t *testing.T) {
+			guest := createTestNode(t)
+			token, _ := jam.Invite(peer.IDB58Encode(guest.ID()), time.Hour)
+
+			_, err := guest.JoinInvitation(token, 62, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			waitFor(t, func() bool { return jam.NoteStore.Admitted(peer.IDB58Encode(guest.ID())) })
+			waitFor(t, func() bool { return jam.NoteStore.ActiveNotes() == 2 })
+		})
+	})
+
+	t.Run("private sessions", func(t *testing.T) {
+		owner := createTestNode(t)
+		jam := owner.HostSession("jam", 60, false)
+		token, _ := jam.Invite(AnyNode, time.Hour)
+
+		t.Run("reject notes from uninvited nodes", func(t *testing.T) {
+			intruder := createTestNode(t)
+			intruder.JoinSession("jam", 62, false).ConnectToHost(owner)
+			time.Sleep(50 * time.Millisecond)
+
+			if jam.NoteStore.ActiveNotes() != 1 {
+				t.Error("stored a note from an uninvited node")
+			}
+		})
+
+		t.Run("guests admit each other through gossip", func(t *testing.T) {
+			guest1 := createTestNode(t)
+			guest2 := createTestNode(t)
+			jam1, _ := guest1.JoinInvitation(token, 62, false)
+			jam2, _ := guest2.JoinInvitation(token, 64, false)
+
+			// guests only know the owner, which forwards their admissions
+			waitFor(t, func() bool {
+				jam.Notify()
+				return jam1.NoteStore.ActiveNotes() == 3 && jam2.NoteStore.ActiveNotes() == 3
+			})
+		})
+	})
+}
@@ -0,0 +1,237 @@
+package loopnet
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"time"
+)
+
+// Wire format of the Ableton Link discovery and measurement protocols.
+// All integers are big endian. Messages start with a protocol header, then a
+// message header, then a payload of entries, each a four character key, a
+// 32 bit size and the value.
+
+// LinkGroup is the multicast group Link peers discover each other on.
+var LinkGroup = &net.UDPAddr{IP: net.IPv4(224, 76, 78, 75), Port: 20808}
+
+var linkDiscoveryHeader = []byte{'_', 'a', 's', 'd', 'p', '_', 'v', 1}
+var linkMeasurementHeader = []byte{'_', 'l', 'i', 'n', 'k', '_', 'v', 1}
+
+// discovery message types
+const (
+	linkAlive    = 1
+	linkResponse = 2
+	linkByeBye   = 3
+)
+
+// measurement message types
+const (
+	linkPing = 1
+	linkPong = 2
+)
+
+// payload entry keys
+const (
+	linkTimelineKey      = 't'<<24 | 'm'<<16 | 'l'<<8 | 'n'
+	linkSessionKey       = 's'<<24 | 'e'<<16 | 's'<<8 | 's'
+	linkStartStopKey     = 's'<<24 | 't'<<16 | 's'<<8 | 't'
+	linkEndpointKey      = 'm'<<24 | 'e'<<16 | 'p'<<8 | '4'
+	linkHostTimeKey      = '_'<<24 | '_'<<16 | 'h'<<8 | 't'
+	linkGhostTimeKey     = '_'<<24 | '_'<<16 | 'g'<<8 | 't'
+	linkPrevGhostTimeKey = '_'<<24 | 'p'<<16 | 'g'<<8 | 't'
+)
+
+// LinkId identifies a Link peer or session.
+type LinkId [8]byte
+
+func (id LinkId) String() string {
+	return fmt.Sprintf("%x", id[:])
+}
+
+// LinkTimeline maps ghost time, the clock shared by a Link session, to beats.
+type LinkTimeline struct {
+	Tempo      float64       // beats per minute
+	BeatOrigin float64       // beat at TimeOrigin
+	TimeOrigin time.Duration // ghost time of BeatOrigin
+}
+
+// BeatAt returns the beat at a ghost time.
+func (tl LinkTimeline) BeatAt(ghost time.Duration) float64 {
+	return tl.BeatOrigin + (ghost-tl.TimeOrigin).Minutes()*tl.Tempo
+}
+
+// TimeAt returns the ghost time of a beat.
+func (tl LinkTimeline) TimeAt(beat float64) time.Duration {
+	return tl.TimeOrigin + time.Duration((beat-tl.BeatOrigin)/tl.Tempo*float64(time.Minute))
+}
+
+// linkPeerState is the payload of alive and response messages
+type linkPeerState struct {
+	session  LinkId
+	timeline LinkTimeline
+	endpoint *net.UDPAddr // measurement endpoint
+}
+
+// linkMessage is a decoded discovery message
+type linkMessage struct {
+	messageType byte
+	ttl         byte
+	ident       LinkId
+	state       linkPeerState
+}
+
+func encodeLinkMessage(m linkMessage) []byte {
+	buf := &bytes.Buffer{}
+	buf.Write(linkDiscoveryHeader)
+	buf.WriteByte(m.messageType)
+	buf.WriteByte(m.ttl)
+	binary.Write(buf, binary.BigEndian, uint16(0)) // session group, always 0
+	buf.Write(m.ident[:])
+	if m.messageType == linkByeBye {
+		return buf.Bytes()
+	}
+
+	timeline := &bytes.Buffer{}
+	binary.Write(timeline, binary.BigEndian, int64(math.Round(60e6/m.state.timeline.Tempo)))
+	binary.Write(timeline, binary.BigEndian, int64(math.Round(m.state.timeline.BeatOrigin*1e6)))
+	binary.Write(timeline, binary.BigEndian, int64(m.state.timeline.TimeOrigin/time.Microsecond))
+	writeLinkEntry(buf, linkTimelineKey, timeline.Bytes())
+	writeLinkEntry(buf, linkSessionKey, m.state.session[:])
+
+	if m.state.endpoint != nil {
+		endpoint := &bytes.Buffer{}
+		endpoint.Write(m.state.endpoint.IP.To4())
+		binary.Write(endpoint, binary.BigEndian, uint16(m.state.endpoint.Port))
+		writeLinkEntry(buf, linkEndpointKey, endpoint.Bytes())
+	}
+	return buf.Bytes()
+}
+
+func decodeLinkMessage(data []byte) (linkMessage, error) {
+	m := linkMessage{}
+	if !bytes.HasPrefix(data, linkDiscoveryHeader) {
+		return m, errors.New("not a Link discovery message")
+	}
+	data = data[len(linkDiscoveryHeader):]
+	if len(data) < 12 {
+		return m, errors.New("truncated Link message header")
+	}
+	m.messageType, m.ttl = data[0], data[1]
+	copy(m.ident[:], data[4:12])
+
+	err := readLinkEntries(data[12:], func(key uint32, value []byte) error {
+		switch key {
+		case linkTimelineKey:
+			if len(value) < 24 {
+				return errors.New("truncated Link timeline")
+			}
+			microsPerBeat := int64(binary.BigEndian.Uint64(value))
+			if microsPerBeat <= 0 {
+				return errors.New("invalid Link tempo")
+			}
+			m.state.timeline = LinkTimeline{
+				Tempo:      60e6 / float64(microsPerBeat),
+				BeatOrigin: float64(int64(binary.BigEndian.Uint64(value[8:]))) / 1e6,
+				TimeOrigin: time.Duration(int64(binary.BigEndian.Uint64(value[16:]))) * time.Microsecond,
+			}
+		case linkSessionKey:
+			if len(value) < 8 {
+				return errors.New("truncated Link session")
+			}
+			copy(m.state.session[:], value)
+		case linkEndpointKey:
+			if len(value) < 6 {
+				return errors.New("truncated Link endpoint")
+			}
+			m.state.endpoint = &net.UDPAddr{
+				IP:   net.IPv4(value[0], value[1], value[2], value[3]),
+				Port: int(binary.BigEndian.Uint16(value[4:])),
+			}
+		}
+		// other entries, e.g. start/stop state, are not used
+		return nil
+	})
+	return m, err
+}
+
+// linkMeasurement is a decoded ping or pong
+type linkMeasurement struct {
+	messageType byte
+	session     LinkId
+	hostTime    time.Duration // pinging peer's host time when the ping was sent
+	ghostTime   time.Duration // session ghost time when the pong was sent
+}
+
+func encodeLinkMeasurement(m linkMeasurement) []byte {
+	buf := &bytes.Buffer{}
+	buf.Write(linkMeasurementHeader)
+	buf.WriteByte(m.messageType)
+	if m.messageType == linkPong {
+		writeLinkEntry(buf, linkSessionKey, m.session[:])
+		writeLinkEntry(buf, linkGhostTimeKey, linkMicros(m.ghostTime))
+	}
+	// pongs echo the host time of the ping
+	writeLinkEntry(buf, linkHostTimeKey, linkMicros(m.hostTime))
+	return buf.Bytes()
+}
+
+func decodeLinkMeasurement(data []byte) (linkMeasurement, error) {
+	m := linkMeasurement{}
+	if !bytes.HasPrefix(data, linkMeasurementHeader) || len(data) < len(linkMeasurementHeader)+1 {
+		return m, errors.New("not a Link measurement message")
+	}
+	m.messageType = data[len(linkMeasurementHeader)]
+
+	err := readLinkEntries(data[len(linkMeasurementHeader)+1:], func(key uint32, value []byte) error {
+		if key == linkSessionKey || key == linkHostTimeKey || key == linkGhostTimeKey || key == linkPrevGhostTimeKey {
+			if len(value) < 8 {
+				return errors.New("truncated Link measurement entry")
+			}
+		}
+		switch key {
+		case linkSessionKey:
+			copy(m.session[:], value)
+		case linkHostTimeKey:
+			m.hostTime = time.Duration(int64(binary.BigEndian.Uint64(value))) * time.Microsecond
+		case linkGhostTimeKey:
+			m.ghostTime = time.Duration(int64(binary.BigEndian.Uint64(value))) * time.Microsecond
+		}
+		return nil
+	})
+	return m, err
+}
+
+func writeLinkEntry(buf *bytes.Buffer, key uint32, value []byte) {
+	binary.Write(buf, binary.BigEndian, key)
+	binary.Write(buf, binary.BigEndian, uint32(len(value)))
+	buf.Write(value)
+}
+
+// call onEntry for each key and value of a payload
+func readLinkEntries(data []byte, onEntry func(key uint32, value []byte) error) error {
+	for len(data) > 0 {
+		if len(data) < 8 {
+			return errors.New("truncated Link payload entry")
+		}
+		key, size := binary.BigEndian.Uint32(data), binary.BigEndian.Uint32(data[4:])
+		data = data[8:]
+		if uint32(len(data)) < size {
+			return errors.New("truncated Link payload entry")
+		}
+		if err := onEntry(key, data[:size]); err != nil {
+			return err
+		}
+		data = data[size:]
+	}
+	return nil
+}
+
+func linkMicros(d time.Duration) []byte {
+	value := make([]byte, 8)
+	binary.BigEndian.PutUint64(value, uint64(int64(d/time.Microsecond)))
+	return value
+}
@@ -0,0 +1,480 @@
+package loopnet
+
+import (
+	"crypto/rand"
+	"errors"
+	"log/slog"
+	"math"
+	"net"
+	"sync"
+	"time"
+)
+
+// seconds peers remember each other without hearing an alive message
+const linkTTL = 5
+
+// time between alive messages
+const linkBroadcastInterval = time.Second
+
+// pings sent to measure the ghost time of another session
+const linkMeasurementPings = 5
+
+// time to wait for each pong
+const linkPongTimeout = 100 * time.Millisecond
+
+// sessions with ghost times closer than this are considered equally old
+const linkSessionEpsilon = 500 * time.Millisecond
+
+// beats in a bar; Link peers align their phase within a bar
+const linkQuantum = 4
+
+// time between tempo checks when following or leading a Link session
+const linkFollowInterval = 100 * time.Millisecond
+
+// a Link peer and when we stop remembering it
+type linkPeer struct {
+	state   linkPeerState
+	expires time.Time
+}
+
+// Link joins an Ableton Link session on the local network. It follows the
+// tempo and beat timeline of the session, and can lead it by changing the
+// tempo or aligning its bars to another clock.
+//
+// Peers in a Link session share a ghost clock. The session is founded by
+// one peer; others measure its ghost time with pings and join the session
+// whose ghost clock is furthest ahead.
+type Link struct {
+	clock       Clock         // host clock
+	ident       LinkId        // our peer id
+	session     LinkId        // session we are in, our own id until we join another
+	timeline    LinkTimeline  // beat timeline of the session
+	ghostOffset time.Duration // session ghost time minus host time
+	peers       map[LinkId]*linkPeer
+	measuring   bool // whether another session is being measured
+	group       *net.UDPAddr
+	multicast   *net.UDPConn // receives alive and bye bye messages
+	unicast     *net.UDPConn // sends to the group, receives responses and measurements
+	pongs       chan linkMeasurement
+	stop        chan struct{}
+	logger      *slog.Logger
+	linkMux     *sync.RWMutex
+}
+
+// NewLink starts a Link peer in its own session at the default tempo and
+// starts discovering other peers.
+// clock: host clock, e.g. the node's swarm clock
+// address: local address to reach other peers on
+// group: multicast group to discover peers on, usually LinkGroup
+func NewLink(clock Clock, address net.IP, group *net.UDPAddr) (*Link, error) {
+	l := &Link{
+		clock:    clock,
+		timeline: LinkTimeline{Tempo: defaultTempo},
+		peers:    make(map[LinkId]*linkPeer),
+		group:    group,
+		pongs:    make(chan linkMeasurement, linkMeasurementPings),
+		stop:     make(chan struct{}),
+		logger:   NewLogging(nil).Logger(logLink),
+		linkMux:  &sync.RWMutex{},
+	}
+	if _, err := rand.Read(l.ident[:]); err != nil {
+		return nil, err
+	}
+	l.session = l.ident
+	// ghost time starts when the session is founded, so sessions founded
+	// earlier are ahead and peers join the oldest
+	l.ghostOffset = -l.hostTime()
+
+	var err error
+	l.multicast, err = net.ListenMulticastUDP("udp4", linkInterface(address), group)
+	if err != nil {
+		return nil, err
+	}
+	l.unicast, err = net.ListenUDP("udp4", &net.UDPAddr{IP: address})
+	if err != nil {
+		l.multicast.Close()
+		return nil, err
+	}
+
+	go l.receiveMulticast()
+	go l.receiveUnicast()
+	go l.broadcast()
+	return l, nil
+}
+
+// SetLogging logs with the levels and handler of a node's logging rather
+// than the defaults.
+func (l *Link) SetLogging(logging *Logging) {
+	l.linkMux.Lock()
+	defer l.linkMux.Unlock()
+
+	l.logger = logging.Logger(logLink)
+}
+
+// Close leaves the Link session, telling the other peers.
+func (l *Link) Close() {
+	close(l.stop)
+	l.send(linkByeBye, l.group)
+	l.multicast.Close()
+	l.unicast.Close()
+}
+
+// Session returns the id of the Link session.
+func (l *Link) Session() LinkId {
+	l.linkMux.RLock()
+	defer l.linkMux.RUnlock()
+
+	return l.session
+}
+
+// Peers returns the number of other peers known to be in the same session.
+func (l *Link) Peers() int {
+	l.linkMux.RLock()
+	defer l.linkMux.RUnlock()
+
+	count := 0
+	for _, peer := range l.peers {
+		if peer.state.session == l.session {
+			count++
+		}
+	}
+	return count
+}
+
+// Tempo returns the tempo of the session in beats per minute.
+func (l *Link) Tempo() float64 {
+	l.linkMux.RLock()
+	defer l.linkMux.RUnlock()
+
+	return l.timeline.Tempo
+}
+
+// Beat returns the current beat of the session.
+func (l *Link) Beat() float64 {
+	l.linkMux.RLock()
+	defer l.linkMux.RUnlock()
+
+	return l.timeline.BeatAt(l.hostTime() + l.ghostOffset)
+}
+
+// SetTempo changes the tempo of the session, keeping the current beat.
+func (l *Link) SetTempo(bpm float64) {
+	l.linkMux.Lock()
+	ghost := l.hostTime() + l.ghostOffset
+	l.setTimeline(LinkTimeline{Tempo: bpm, BeatOrigin: l.timeline.BeatAt(ghost), TimeOrigin: ghost})
+	l.linkMux.Unlock()
+
+	l.send(linkAlive, l.group)
+}
+
+// Lead sets the tempo of the session and shifts its beats so that bars
+// start on the beat grid of a clock at that tempo, the grid loopnet
+// arpeggiators and scheduled notes use.
+func (l *Link) Lead(clock Clock, bpm float64) {
+	l.linkMux.Lock()
+	ghost := l.hostTime() + l.ghostOffset
+	beat := l.timeline.BeatAt(ghost)
+	origin := beat + linkPhase(gridBeat(clock.Now(), bpm)-beat)
+	// move a bar ahead rather than behind the current origin
+	for origin <= l.timeline.BeatOrigin {
+		origin += linkQuantum
+	}
+	l.setTimeline(LinkTimeline{Tempo: bpm, BeatOrigin: origin, TimeOrigin: ghost})
+	l.linkMux.Unlock()
+
+	l.send(linkAlive, l.group)
+}
+
+// Now returns a time whose position on the beat grid at the session tempo
+// matches the position of the session in its bar. An arpeggiator using a
+// Link as its clock plays in phase with the Link session, as long as it
+// plays at the session tempo.
+func (l *Link) Now() time.Time {
+	l.linkMux.RLock()
+	defer l.linkMux.RUnlock()
+
+	host := l.hostTime()
+	phase := linkPhase(l.timeline.BeatAt(host+l.ghostOffset) - gridBeat(time.Unix(0, int64(host)), l.timeline.Tempo))
+	return time.Unix(0, int64(host)).Add(time.Duration(phase / l.timeline.Tempo * float64(time.Minute)))
+}
+
+// callers must hold linkMux. Peers only adopt timelines with a later beat
+// origin, so the origin never moves back. The tempo is rounded to whole
+// microseconds per beat as it is sent, so all peers count the same beats.
+func (l *Link) setTimeline(timeline LinkTimeline) {
+	timeline.Tempo = 60e6 / math.Round(60e6/timeline.Tempo)
+	if timeline.BeatOrigin <= l.timeline.BeatOrigin {
+		timeline.BeatOrigin = l.timeline.BeatOrigin + 1e-6
+	}
+	l.timeline = timeline
+}
+
+func (l *Link) hostTime() time.Duration {
+	return time.Duration(l.clock.Now().UnixNano())
+}
+
+// receive alive and bye bye messages from the group
+func (l *Link) receiveMulticast() {
+	buf := make([]byte, 512)
+	for {
+		n, from, err := l.multicast.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+
+		m, err := decodeLinkMessage(buf[:n])
+		if err != nil || m.ident == l.ident {
+			continue
+		}
+
+		switch m.messageType {
+		case linkAlive:
+			l.send(linkResponse, from)
+			l.onPeerState(m)
+		case linkByeBye:
+			l.linkMux.Lock()
+			delete(l.peers, m.ident)
+			l.linkMux.Unlock()
+		}
+	}
+}
+
+// receive responses to our alive messages, pings and pongs
+func (l *Link) receiveUnicast() {
+	buf := make([]byte, 512)
+	for {
+		n, from, err := l.unicast.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+
+		if m, err := decodeLinkMessage(buf[:n]); err == nil {
+			if m.messageType == linkResponse && m.ident != l.ident {
+				l.onPeerState(m)
+			}
+			continue
+		}
+
+		measurement, err := decodeLinkMeasurement(buf[:n])
+		if err != nil {
+			continue
+		}
+		switch measurement.messageType {
+		case linkPing:
+			l.linkMux.RLock()
+			pong := linkMeasurement{
+				messageType: linkPong,
+				session:     l.session,
+				hostTime:    measurement.hostTime,
+				ghostTime:   l.hostTime() + l.ghostOffset,
+			}
+			l.linkMux.RUnlock()
+			l.unicast.WriteToUDP(encodeLinkMeasurement(pong), from)
+		case linkPong:
+			select {
+			case l.pongs <- measurement:
+			default:
+			}
+		}
+	}
+}
+
+// send alive messages and forget peers that have gone quiet
+func (l *Link) broadcast() {
+	for {
+		l.send(linkAlive, l.group)
+
+		select {
+		case <-l.stop:
+			return
+		case <-time.After(linkBroadcastInterval):
+		}
+
+		l.linkMux.Lock()
+		for ident, peer := range l.peers {
+			if time.Now().After(peer.expires) {
+				delete(l.peers, ident)
+			}
+		}
+		l.linkMux.Unlock()
+	}
+}
+
+func (l *Link) send(messageType byte, to *net.UDPAddr) {
+	l.linkMux.RLock()
+	m := linkMessage{
+		messageType: messageType,
+		ttl:         linkTTL,
+		ident:       l.ident,
+		state: linkPeerState{
+			session:  l.session,
+			timeline: l.timeline,
+			endpoint: l.unicast.LocalAddr().(*net.UDPAddr),
+		},
+	}
+	logger := l.logger
+	l.linkMux.RUnlock()
+
+	_, err := l.unicast.WriteToUDP(encodeLinkMessage(m), to)
+	if err != nil {
+		logger.Warn("failed to send Link message", "err", err, "to", to)
+	}
+}
+
+// remember a peer and follow its session
+func (l *Link) onPeerState(m linkMessage) {
+	l.linkMux.Lock()
+	defer l.linkMux.Unlock()
+
+	l.peers[m.ident] = &linkPeer{
+		state:   m.state,
+		expires: time.Now().Add(time.Duration(m.ttl) * time.Second),
+	}
+
+	if m.state.session == l.session {
+		// concurrent tempo changes are resolved by the latest beat origin
+		if m.state.timeline.BeatOrigin > l.timeline.BeatOrigin {
+			l.timeline = m.state.timeline
+		}
+		return
+	}
+
+	if !l.measuring && m.state.endpoint != nil {
+		l.measuring = true
+		go l.measure(m.state)
+	}
+}
+
+// measure the ghost time of another session and join it if its ghost
+// clock is ahead of ours, or close and its id is lower
+func (l *Link) measure(state linkPeerState) {
+	defer func() {
+		l.linkMux.Lock()
+		l.measuring = false
+		l.linkMux.Unlock()
+	}()
+
+	offset, err := l.measureOffset(state.endpoint, state.session)
+	if err != nil {
+		return
+	}
+
+	l.linkMux.Lock()
+	difference := offset - l.ghostOffset
+	join := difference > linkSessionEpsilon ||
+		(difference > -linkSessionEpsilon && string(state.session[:]) < string(l.session[:]))
+	if join {
+		l.session = state.session
+		l.ghostOffset = offset
+		l.timeline = state.timeline
+	}
+	l.linkMux.Unlock()
+
+	if join {
+		l.send(linkAlive, l.group)
+	}
+}
+
+// median difference between a session's ghost time and our host time
+// over a few pings
+func (l *Link) measureOffset(endpoint *net.UDPAddr, session LinkId) (time.Duration, error) {
+	// drop pongs left over from an earlier measurement
+	for len(l.pongs) > 0 {
+		<-l.pongs
+	}
+
+	offsets := make([]time.Duration, 0, linkMeasurementPings)
+	for i := 0; i < linkMeasurementPings; i++ {
+		ping := linkMeasurement{messageType: linkPing, hostTime: l.hostTime()}
+		if _, err := l.unicast.WriteToUDP(encodeLinkMeasurement(ping), endpoint); err != nil {
+			return 0, err
+		}
+
+		select {
+		case pong := <-l.pongs:
+			if pong.session == session && pong.hostTime == ping.hostTime/time.Microsecond*time.Microsecond {
+				received := l.hostTime()
+				offsets = append(offsets, pong.ghostTime-(ping.hostTime+received)/2)
+			}
+		case <-time.After(linkPongTimeout):
+		case <-l.stop:
+			return 0, errors.New("link closed")
+		}
+	}
+
+	if len(offsets) == 0 {
+		return 0, errors.New("no pongs from Link session")
+	}
+	return median(offsets), nil
+}
+
+// beats since the unix epoch at a tempo
+func gridBeat(t time.Time, bpm float64) float64 {
+	return float64(t.UnixNano()) / float64(time.Minute) * bpm
+}
+
+// a beat difference wrapped into the bar, between -quantum/2 and quantum/2
+func linkPhase(beats float64) float64 {
+	phase := math.Mod(beats, linkQuantum)
+	if phase >= linkQuantum/2 {
+		phase -= linkQuantum
+	} else if phase < -linkQuantum/2 {
+		phase += linkQuantum
+	}
+	return phase
+}
+
+// the interface with an address, nil for the system default
+func linkInterface(address net.IP) *net.Interface {
+	interfaces, err := net.Interfaces()
+	if err != nil || address == nil {
+		return nil
+	}
+	for _, iface := range interfaces {
+		addrs, _ := iface.Addrs()
+		for _, addr := range addrs {
+			if ipnet, ok := addr.(*net.IPNet); ok && ipnet.IP.Equal(address) {
+				return &iface
+			}
+		}
+	}
+	return nil
+}
+
+// FollowLink proposes the tempo of a Link session to the loopnet session
+// whenever it changes, until stop is closed. Arpeggiators using the link as
+// their clock then play in phase with the Link session.
+func (np *NotificationProtocol) FollowLink(link *Link, stop <-chan struct{}) {
+	for {
+		tempo := link.Tempo()
+		if math.Abs(tempo-float64(sessionParameters(np.NoteStore, np.Parameters).Tempo)) > 0.01 {
+			if err := np.ProposeTempo(float32(tempo)); err != nil {
+				np.node.logger(logLink).Warn("failed to follow Link tempo", "err", err, "tempo", tempo)
+			}
+		}
+
+		select {
+		case <-stop:
+			return
+		case <-time.After(linkFollowInterval):
+		}
+	}
+}
+
+// LeadLink sets the tempo of a Link session to the loopnet session tempo and
+// keeps its bars on the beat grid of the swarm clock, until stop is closed.
+func (np *NotificationProtocol) LeadLink(link *Link, stop <-chan struct{}) {
+	for {
+		tempo := float64(sessionParameters(np.NoteStore, np.Parameters).Tempo)
+		phase := linkPhase(gridBeat(np.clock().Now(), tempo) - link.Beat())
+		if math.Abs(link.Tempo()-tempo) > 0.01 || math.Abs(phase) > 0.01 {
+			link.Lead(np.clock(), tempo)
+		}
+
+		select {
+		case <-stop:
+			return
+		case <-time.After(linkFollowInterval):
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package loopnet
+
+import (
+	"bytes"
+	"math"
+	"net"
+	"testing"
+	"time"
+)
+
+// keep test peers away from Link sessions running on the machine
+var testLinkGroup = &net.UDPAddr{IP: net.IPv4(224, 76, 78, 75), Port: 20818}
+
+func TestLink(t *testing.T) {
+	t.Run("messages", func(t *testing.T) {
+		t.Run("encode the discovery header", func(t *testing.T) {
+			m := linkMessage{messageType: linkByeBye, ident: LinkId{1, 2, 3, 4, 5, 6, 7, 8}}
+			expected := []byte{'_', 'a', 's', 'd', 'p', '_', 'v', 1, 3, 0, 0, 0, 1, 2, 3, 4, 5, 6, 7, 8}
+
+			if !bytes.Equal(encodeLinkMessage(m), expected) {
+				t.Errorf("unexpected bye bye %v", encodeLinkMessage(m))
+			}
+		})
+
+		t.Run("decode encoded alive messages", func(t *testing.T) {
+			m := linkMessage{
+				messageType: linkAlive,
+				ttl:         linkTTL,
+				ident:       LinkId{1},
+				state: linkPeerState{
+					session:  LinkId{2},
+					timeline: LinkTimeline{Tempo: 125, BeatOrigin: 16.5, TimeOrigin: 3 * time.Second},
+					endpoint: &net.UDPAddr{IP: net.IPv4(192, 168, 1, 2), Port: 5000},
+				},
+			}
+
+			decoded, err := decodeLinkMessage(encodeLinkMessage(m))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if decoded.ident != m.ident || decoded.state.session != m.state.session || decoded.state.timeline != m.state.timeline {
+				t.Errorf("expected %v, decoded %v", m, decoded)
+			}
+			if decoded.state.endpoint.String() != "192.168.1.2:5000" {
+				t.Errorf("unexpected endpoint %v", decoded.state.endpoint)
+			}
+		})
+
+		t.Run("decode encoded pongs", func(t *testing.T) {
+			m := linkMeasurement{messageType: linkPong, session: LinkId{3}, hostTime: time.Second, ghostTime: time.Minute}
+
+			decoded, err := decodeLinkMeasurement(encodeLinkMeasurement(m))
+			if err != nil || decoded != m {
+				t.Errorf("expected %v, decoded %v (%v)", m, decoded, err)
+			}
+		})
+
+		t.Run("reject truncated messages", func(t *testing.T) {
+			data := encodeLinkMessage(linkMessage{messageType: linkAlive, state: linkPeerState{timeline: LinkTimeline{Tempo: 120}}})
+
+			if _, err := decodeLinkMessage(data[:len(data)-3]); err == nil {
+				t.Error("decoded a truncated message")
+			}
+		})
+	})
+
+	t.Run("LinkTimeline", func(t *testing.T) {
+		timeline := LinkTimeline{Tempo: 120, BeatOrigin: 8, TimeOrigin: time.Second}
+
+		if timeline.BeatAt(2*time.Second) != 10 {
+			t.Errorf("unexpected beat %v", timeline.BeatAt(2*time.Second))
+		}
+		if timeline.TimeAt(9) != 1500*time.Millisecond {
+			t.Errorf("unexpected time %v", timeline.TimeAt(9))
+		}
+	})
+
+	t.Run("peers", func(t *testing.T) {
+		localhost := net.IPv4(127, 0, 0, 1)
+		link1, err := NewLink(localClock{}, localhost, testLinkGroup)
+		if err != nil {
+			t.Skip("multicast unavailable:", err)
+		}
+		defer link1.Close()
+		link2, err := NewLink(localClock{}, localhost, testLinkGroup)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer link2.Close()
+
+		t.Run("join the same session", func(t *testing.T) {
+			waitFor(t, func() bool { return link1.Session() == link2.Session() && link1.Peers() == 1 })
+		})
+
+		t.Run("share tempo changes", func(t *testing.T) {
+			link1.SetTempo(133)
+
+			waitFor(t, func() bool { return math.Abs(link2.Tempo()-133) < 0.01 })
+			if math.Abs(link1.Beat()-link2.Beat()) > 0.01 {
+				t.Errorf("beats differ: %v and %v", link1.Beat(), link2.Beat())
+			}
+		})
+
+		t.Run("align bars to the beat grid when leading", func(t *testing.T) {
+			link2.Lead(localClock{}, 90)
+
+			waitFor(t, func() bool { return math.Abs(link1.Tempo()-90) < 0.01 })
+			if phase := linkPhase(gridBeat(time.Now(), 90) - link1.Beat()); math.Abs(phase) > 0.01 {
+				t.Errorf("bars are %v beats off the grid", phase)
+			}
+		})
+
+		t.Run("clocks arpeggiators in phase with the session", func(t *testing.T) {
+			link1.SetTempo(100)
+			waitFor(t, func() bool { return math.Abs(link2.Tempo()-100) < 0.01 })
+
+			phase := linkPhase(gridBeat(link2.Now(), 100) - link1.Beat())
+			if math.Abs(phase) > 0.01 {
+				t.Errorf("clock is %v beats out of phase", phase)
+			}
+		})
+	})
+
+	t.Run("joins a session founded earlier", func(t *testing.T) {
+		localhost := net.IPv4(127, 0, 0, 1)
+		// a peer such as Live, its ghost clock started a minute ago
+		live, err := NewLink(localClock{}, localhost, testLinkGroup)
+		if err != nil {
+			t.Skip("multicast unavailable:", err)
+		}
+		defer live.Close()
+		live.linkMux.Lock()
+		live.ghostOffset = time.Minute - live.hostTime()
+		live.linkMux.Unlock()
+		live.SetTempo(97)
+
+		link, err := NewLink(localClock{}, localhost, testLinkGroup)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer link.Close()
+
+		waitFor(t, func() bool { return link.Session() == live.Session() && math.Abs(link.Tempo()-97) < 0.01 })
+		if live.Session() != live.ident {
+			t.Error("expected the earlier session to be kept")
+		}
+	})
+}
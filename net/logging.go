@@ -0,0 +1,144 @@
+package loopnet
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+)
+
+// components of the net package with their own log level
+const (
+	logNode   = "node"   // keys, signatures and sessions
+	logGossip = "gossip" // notifications, admissions, commands and parameters
+	logStore  = "store"  // notes stored, ignored and cleared
+	logClock  = "clock"
+	logLink   = "link"
+	logOSC    = "osc"
+	logHTTP   = "http" // API and live feed
+)
+
+// Logging creates the structured loggers of a node's components and holds
+// their levels. Levels can be changed at any time, e.g. to trace gossip on
+// one node of a running swarm.
+type Logging struct {
+	handler    slog.Handler // nil to log with slog.Default()
+	level      *slog.LevelVar
+	levels     map[string]*slog.LevelVar // levels of components that don't use level
+	loggingMux *sync.RWMutex
+}
+
+// NewLogging creates loggers writing to a handler at the info level. The
+// handler should accept every level, levels are applied per component.
+// A nil handler logs with the handler of slog.Default() at the time of
+// logging.
+func NewLogging(handler slog.Handler) *Logging {
+	return &Logging{
+		handler:    handler,
+		level:      &slog.LevelVar{},
+		levels:     make(map[string]*slog.LevelVar),
+		loggingMux: &sync.RWMutex{},
+	}
+}
+
+// SetLevel sets the level of a component, or of every component without
+// its own level if component is empty.
+func (l *Logging) SetLevel(component string, level slog.Level) {
+	if component == "" {
+		l.level.Set(level)
+		return
+	}
+
+	l.loggingMux.Lock()
+	defer l.loggingMux.Unlock()
+
+	if _, found := l.levels[component]; !found {
+		l.levels[component] = &slog.LevelVar{}
+	}
+	l.levels[component].Set(level)
+}
+
+// Configure sets levels from a comma separated list of levels for every
+// component and component=level pairs, e.g. "warn,gossip=debug".
+func (l *Logging) Configure(spec string) error {
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		component, name := "", entry
+		if i := strings.Index(entry, "="); i >= 0 {
+			component, name = entry[:i], entry[i+1:]
+		}
+
+		level := slog.Level(0)
+		if err := level.UnmarshalText([]byte(name)); err != nil {
+			return fmt.Errorf("invalid log level %q: %v", entry, err)
+		}
+		l.SetLevel(component, level)
+	}
+	return nil
+}
+
+// Logger returns the logger of a component.
+func (l *Logging) Logger(component string) *slog.Logger {
+	return slog.New(&componentHandler{logging: l, component: component}).With("component", component)
+}
+
+// callers must not hold loggingMux
+func (l *Logging) levelOf(component string) slog.Level {
+	l.loggingMux.RLock()
+	level, found := l.levels[component]
+	l.loggingMux.RUnlock()
+
+	if found {
+		return level.Level()
+	}
+	return l.level.Level()
+}
+
+// componentHandler filters records by the level of a component
+type componentHandler struct {
+	logging   *Logging
+	component string
+	with      []func(slog.Handler) slog.Handler // attributes and groups added, in order
+}
+
+func (h *componentHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return level >= h.logging.levelOf(h.component) && h.handler().Enabled(ctx, level)
+}
+
+func (h *componentHandler) Handle(ctx context.Context, record slog.Record) error {
+	return h.handler().Handle(ctx, record)
+}
+
+func (h *componentHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return h.withHandler(func(handler slog.Handler) slog.Handler { return handler.WithAttrs(attrs) })
+}
+
+func (h *componentHandler) WithGroup(name string) slog.Handler {
+	return h.withHandler(func(handler slog.Handler) slog.Handler { return handler.WithGroup(name) })
+}
+
+func (h *componentHandler) withHandler(with func(slog.Handler) slog.Handler) slog.Handler {
+	return &componentHandler{
+		logging:   h.logging,
+		component: h.component,
+		with:      append(append([]func(slog.Handler) slog.Handler{}, h.with...), with),
+	}
+}
+
+// the underlying handler with the attributes and groups of this logger,
+// resolved at logging time so a nil handler follows slog.SetDefault
+func (h *componentHandler) handler() slog.Handler {
+	handler := h.logging.handler
+	if handler == nil {
+		handler = slog.Default().Handler()
+	}
+	for _, with := range h.with {
+		handler = with(handler)
+	}
+	return handler
+}
@@ -0,0 +1,106 @@
+package loopnet
+
+import (
+	"bytes"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestLogging(t *testing.T) {
+	newLogging := func() (*Logging, *bytes.Buffer) {
+		buf := &bytes.Buffer{}
+		handler := slog.NewTextHandler(buf, &slog.HandlerOptions{Level: slog.LevelDebug})
+		return NewLogging(handler), buf
+	}
+
+	t.Run("logs at info by default", func(t *testing.T) {
+		logging, buf := newLogging()
+		logger := logging.Logger(logGossip)
+
+		logger.Debug("hidden")
+		logger.Info("shown")
+
+		if strings.Contains(buf.String(), "hidden") {
+			t.Error("logged a debug message at the info level")
+		}
+		if !strings.Contains(buf.String(), "shown") {
+			t.Error("did not log an info message")
+		}
+	})
+
+	t.Run("filters each component by its own level", func(t *testing.T) {
+		logging, buf := newLogging()
+		gossip := logging.Logger(logGossip)
+		store := logging.Logger(logStore)
+
+		logging.SetLevel(logGossip, slog.LevelDebug)
+		gossip.Debug("gossip detail")
+		store.Debug("store detail")
+
+		if !strings.Contains(buf.String(), "gossip detail") {
+			t.Error("did not log a debug message of a component at the debug level")
+		}
+		if strings.Contains(buf.String(), "store detail") {
+			t.Error("logged a debug message of a component at the default level")
+		}
+	})
+
+	t.Run("changes levels of existing loggers", func(t *testing.T) {
+		logging, buf := newLogging()
+		logger := logging.Logger(logNode).With("node", "a")
+
+		logging.SetLevel("", slog.LevelError)
+		logger.Warn("dropped")
+		logging.SetLevel("", slog.LevelWarn)
+		logger.Warn("kept")
+
+		if strings.Contains(buf.String(), "dropped") || !strings.Contains(buf.String(), "kept") {
+			t.Errorf("level change not applied to logger: %q", buf.String())
+		}
+	})
+
+	t.Run("adds the component, attributes and groups", func(t *testing.T) {
+		logging, buf := newLogging()
+		logger := logging.Logger(logStore).With("session", "s").WithGroup("note")
+
+		logger.Info("stored", "revision", 3)
+
+		line := buf.String()
+		for _, expected := range []string{"component=store", "session=s", "note.revision=3"} {
+			if !strings.Contains(line, expected) {
+				t.Errorf("expected %q in %q", expected, line)
+			}
+		}
+	})
+
+	t.Run("Configure", func(t *testing.T) {
+		t.Run("sets the default and component levels", func(t *testing.T) {
+			logging, _ := newLogging()
+
+			err := logging.Configure("warn, gossip=debug,store=ERROR")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			levels := map[string]slog.Level{
+				logNode:   slog.LevelWarn,
+				logGossip: slog.LevelDebug,
+				logStore:  slog.LevelError,
+			}
+			for component, expected := range levels {
+				if level := logging.levelOf(component); level != expected {
+					t.Errorf("expected %s level %v, got %v", component, expected, level)
+				}
+			}
+		})
+
+		t.Run("rejects unknown levels", func(t *testing.T) {
+			logging, _ := newLogging()
+
+			if err := logging.Configure("gossip=loud"); err == nil {
+				t.Error("accepted an unknown level")
+			}
+		})
+	})
+}
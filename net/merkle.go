@@ -0,0 +1,147 @@
+package loopnet
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"sort"
+
+	p2p "github.com/acruikshank/loopnet/pb"
+)
+
+// children of every inner node of a Merkle tree
+const merkleArity = 16
+
+// levels below the root; leaves are at this level. 16^3 = 4096 leaves keep
+// a few notes in each leaf of a swarm of thousands.
+const merkleDepth = 3
+
+// merkleTree hashes the revisions of a store's notes so that two nodes can
+// find the notes they disagree on by comparing hashes top down, descending
+// only into the subtrees that differ. Notes are bucketed into leaves by
+// the hash of their node id, so both nodes put a note in the same leaf.
+type merkleTree struct {
+	levels [][][]byte                   // hashes by level and index, nil for subtrees without notes
+	leaves []map[string]*p2p.NoteDigest // digests of each leaf by node id
+}
+
+// newMerkleTree hashes a snapshot of notes.
+func newMerkleTree(notes []p2p.NoteData) *merkleTree {
+	t := &merkleTree{
+		levels: make([][][]byte, merkleDepth+1),
+		leaves: make([]map[string]*p2p.NoteDigest, merkleWidth(merkleDepth)),
+	}
+	for _, note := range notes {
+		leaf := merkleLeafOf(note.NodeId)
+		if t.leaves[leaf] == nil {
+			t.leaves[leaf] = make(map[string]*p2p.NoteDigest)
+		}
+		t.leaves[leaf][note.NodeId] = &p2p.NoteDigest{NodeId: note.NodeId, Revision: note.Revision}
+	}
+
+	t.levels[merkleDepth] = make([][]byte, len(t.leaves))
+	for i := range t.leaves {
+		t.levels[merkleDepth][i] = t.hashLeaf(uint32(i))
+	}
+	for level := merkleDepth - 1; level >= 0; level-- {
+		t.levels[level] = make([][]byte, merkleWidth(level))
+		for i := range t.levels[level] {
+			t.levels[level][i] = hashChildren(t.levels[level+1][i*merkleArity : (i+1)*merkleArity])
+		}
+	}
+	return t
+}
+
+// hash returns the hash of a subtree and whether the subtree exists.
+func (t *merkleTree) hash(level uint32, index uint32) ([]byte, bool) {
+	if level > merkleDepth || index >= uint32(len(t.levels[level])) {
+		return nil, false
+	}
+	return t.levels[level][index], true
+}
+
+// root returns the node hashing the whole tree.
+func (t *merkleTree) root() *p2p.MerkleNode {
+	return &p2p.MerkleNode{Level: 0, Index: 0, Hash: t.levels[0][0]}
+}
+
+// children returns the children of an inner node.
+func (t *merkleTree) children(level uint32, index uint32) []*p2p.MerkleNode {
+	children := make([]*p2p.MerkleNode, 0, merkleArity)
+	for i := index * merkleArity; i < (index+1)*merkleArity; i++ {
+		children = append(children, &p2p.MerkleNode{Level: level + 1, Index: i, Hash: t.levels[level+1][i]})
+	}
+	return children
+}
+
+// leaf returns the digests of a leaf, sorted by node id.
+func (t *merkleTree) leaf(index uint32) *p2p.MerkleLeaf {
+	leaf := &p2p.MerkleLeaf{Index: index, Digests: make([]*p2p.NoteDigest, 0, len(t.leaves[index]))}
+	for _, digest := range t.leaves[index] {
+		leaf.Digests = append(leaf.Digests, digest)
+	}
+	sort.Slice(leaf.Digests, func(i, j int) bool {
+		return leaf.Digests[i].NodeId < leaf.Digests[j].NodeId
+	})
+	return leaf
+}
+
+// digests returns the digests of a leaf by node id.
+func (t *merkleTree) digests(index uint32) map[string]*p2p.NoteDigest {
+	if index >= uint32(len(t.leaves)) {
+		return nil
+	}
+	return t.leaves[index]
+}
+
+// hash the sorted digests of a leaf
+func (t *merkleTree) hashLeaf(index uint32) []byte {
+	if len(t.leaves[index]) == 0 {
+		return nil
+	}
+
+	h := sha256.New()
+	revision := make([]byte, 4)
+	for _, digest := range t.leaf(index).Digests {
+		h.Write([]byte(digest.NodeId))
+		h.Write([]byte{0})
+		binary.BigEndian.PutUint32(revision, digest.Revision)
+		h.Write(revision)
+	}
+	return h.Sum(nil)
+}
+
+// hash the hashes of the children of an inner node, nil if none holds notes
+func hashChildren(children [][]byte) []byte {
+	empty := true
+	for _, child := range children {
+		empty = empty && child == nil
+	}
+	if empty {
+		return nil
+	}
+
+	h := sha256.New()
+	none := make([]byte, sha256.Size)
+	for _, child := range children {
+		if child == nil {
+			child = none
+		}
+		h.Write(child)
+	}
+	return h.Sum(nil)
+}
+
+// the leaf holding a node's note, from the first bits of the hash of its id
+func merkleLeafOf(nodeId string) uint32 {
+	sum := sha256.Sum256([]byte(nodeId))
+	return binary.BigEndian.Uint32(sum[:4]) % uint32(merkleWidth(merkleDepth))
+}
+
+// the number of nodes at a level
+func merkleWidth(level int) int {
+	width := 1
+	for i := 0; i < level; i++ {
+		width *= merkleArity
+	}
+	return width
+}
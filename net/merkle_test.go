@@ -0,0 +1,101 @@
+package loopnet
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	p2p "github.com/acruikshank/loopnet/pb"
+)
+
+func TestMerkleTree(t *testing.T) {
+	t.Run("hashes equal notes equally in any order", func(t *testing.T) {
+		notes := createNotes(100)
+		a := newMerkleTree(notes)
+		for i, j := 0, len(notes)-1; i < j; i, j = i+1, j-1 {
+			notes[i], notes[j] = notes[j], notes[i]
+		}
+		b := newMerkleTree(notes)
+
+		if !bytes.Equal(a.root().Hash, b.root().Hash) {
+			t.Error("expected equal roots regardless of note order")
+		}
+	})
+
+	t.Run("differs only along the path of a changed note", func(t *testing.T) {
+		notes := createNotes(100)
+		a := newMerkleTree(notes)
+		notes[42].Revision++
+		b := newMerkleTree(notes)
+
+		if bytes.Equal(a.root().Hash, b.root().Hash) {
+			t.Error("expected the roots to differ")
+		}
+		leaf := merkleLeafOf(notes[42].NodeId)
+		for level := uint32(merkleDepth); ; level-- {
+			index := leaf
+			for i := level; i < merkleDepth; i++ {
+				index /= merkleArity
+			}
+			for i := 0; i < merkleWidth(int(level)); i++ {
+				hashA, _ := a.hash(level, uint32(i))
+				hashB, _ := b.hash(level, uint32(i))
+				if equal := bytes.Equal(hashA, hashB); equal == (uint32(i) == index) {
+					t.Errorf("unexpected hash at level %d index %d, equal: %v", level, i, equal)
+				}
+			}
+			if level == 0 {
+				break
+			}
+		}
+	})
+
+	t.Run("leaves empty subtrees unhashed", func(t *testing.T) {
+		tree := newMerkleTree(nil)
+		if tree.root().Hash != nil {
+			t.Error("expected an empty tree to have no root hash")
+		}
+
+		tree = newMerkleTree(createNotes(1))
+		children := tree.children(0, 0)
+		if len(children) != merkleArity {
+			t.Fatalf("expected %d children, got %d", merkleArity, len(children))
+		}
+		hashed := 0
+		for _, child := range children {
+			if child.Hash != nil {
+				hashed++
+			}
+		}
+		if hashed != 1 {
+			t.Errorf("expected one hashed child, got %d", hashed)
+		}
+	})
+
+	t.Run("lists the digests of a leaf sorted by node id", func(t *testing.T) {
+		notes := []p2p.NoteData{*createNote("b", 2, 60, false), *createNote("a", 1, 60, false)}
+		// find an id sorting before b in the same leaf
+		for i := 0; merkleLeafOf(notes[1].NodeId) != merkleLeafOf(notes[0].NodeId); i++ {
+			notes[1].NodeId = fmt.Sprintf("a%d", i)
+		}
+		tree := newMerkleTree(notes)
+
+		leaf := tree.leaf(merkleLeafOf("b"))
+		if len(leaf.Digests) != 2 || leaf.Digests[0].NodeId != notes[1].NodeId || leaf.Digests[1].Revision != 2 {
+			t.Errorf("unexpected leaf %v", leaf)
+		}
+	})
+
+	t.Run("rejects subtrees outside the tree", func(t *testing.T) {
+		tree := newMerkleTree(createNotes(10))
+		if _, found := tree.hash(merkleDepth+1, 0); found {
+			t.Error("expected no level below the leaves")
+		}
+		if _, found := tree.hash(1, merkleArity); found {
+			t.Error("expected no node past the width of a level")
+		}
+		if tree.digests(uint32(merkleWidth(merkleDepth))) != nil {
+			t.Error("expected no leaf past the last")
+		}
+	})
+}
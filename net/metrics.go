@@ -0,0 +1,253 @@
+package loopnet
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// upper bounds in seconds of the stream open latency buckets
+var streamOpenBuckets = []float64{0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5}
+
+// upper bounds in seconds of the note propagation latency buckets
+var propagationBuckets = []float64{0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+
+// upper bounds of the note hop count buckets
+var hopBuckets = []float64{1, 2, 3, 4, 5, 6, 8, 10, 15, 20}
+
+// Counter is a count that only goes up, safe for concurrent use.
+type Counter struct {
+	value uint64
+}
+
+// Inc adds one to the count.
+func (c *Counter) Inc() {
+	atomic.AddUint64(&c.value, 1)
+}
+
+// Add adds n to the count.
+func (c *Counter) Add(n uint64) {
+	atomic.AddUint64(&c.value, n)
+}
+
+// Value returns the count.
+func (c *Counter) Value() uint64 {
+	return atomic.LoadUint64(&c.value)
+}
+
+// Histogram counts durations or other values in buckets, safe for
+// concurrent use.
+type Histogram struct {
+	bounds       []float64 // bucket upper bounds, in seconds for durations
+	counts       []uint64  // observations in each bucket, not cumulative
+	count        uint64
+	sum          float64
+	histogramMux *sync.Mutex
+}
+
+// NewHistogram creates a histogram with buckets of increasing upper bounds,
+// in seconds for durations.
+func NewHistogram(bounds []float64) *Histogram {
+	return &Histogram{
+		bounds:       bounds,
+		counts:       make([]uint64, len(bounds)),
+		histogramMux: &sync.Mutex{},
+	}
+}
+
+// Observe adds a duration to the histogram.
+func (h *Histogram) Observe(d time.Duration) {
+	h.ObserveValue(d.Seconds())
+}
+
+// ObserveValue adds a value to the histogram.
+func (h *Histogram) ObserveValue(value float64) {
+	h.histogramMux.Lock()
+	defer h.histogramMux.Unlock()
+
+	for i, bound := range h.bounds {
+		if value <= bound {
+			h.counts[i]++
+			break
+		}
+	}
+	h.count++
+	h.sum += value
+}
+
+// Count returns the number of values observed.
+func (h *Histogram) Count() uint64 {
+	h.histogramMux.Lock()
+	defer h.histogramMux.Unlock()
+
+	return h.count
+}
+
+// GossipMetrics counts the notifications of a session.
+type GossipMetrics struct {
+	NotificationsSent     Counter
+	NotificationsReceived Counter
+	NotificationsFailed   Counter // notifications that could not be sent
+	NotesAuthenticated    Counter // received notes with a valid signature for the session
+	NotesRejected         Counter // received notes with an invalid signature or session
+	NotesSkipped          Counter // received notes not newer than the stored revision, not verified
+	Reconciliations       Counter // reconciliations started with a peer
+	ReconciliationsFailed Counter // reconciliations that did not finish
+	NotesReconciled       Counter // notes received by reconciliation
+	DivergenceAlerts      Counter // alerts raised for peers disagreeing with this node
+	StreamOpen            *Histogram
+	PropagationLatency    *Histogram // seconds from the creation of a note revision to its arrival
+	PropagationHops       *Histogram // nodes a note revision passed through to arrive
+}
+
+func newGossipMetrics() *GossipMetrics {
+	return &GossipMetrics{
+		StreamOpen:         NewHistogram(streamOpenBuckets),
+		PropagationLatency: NewHistogram(propagationBuckets),
+		PropagationHops:    NewHistogram(hopBuckets),
+	}
+}
+
+// StoreMetrics counts the changes to a note store.
+type StoreMetrics struct {
+	NotesAccepted    Counter // new notes and newer revisions stored
+	NotesStale       Counter // revisions ignored because a newer one is stored
+	DeadNotesCleared Counter
+}
+
+// MetricsHandler serves the metrics of every session of a node in the
+// Prometheus text format.
+func MetricsHandler(node *Node) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		WriteMetrics(w, node)
+	})
+}
+
+// WriteMetrics writes the metrics of every session of a node in the
+// Prometheus text format, labelled by session.
+func WriteMetrics(w io.Writer, node *Node) {
+	sessions := make([]*NotificationProtocol, 0)
+	for _, session := range node.Sessions() {
+		if np, found := node.Session(session); found {
+			sessions = append(sessions, np)
+		}
+	}
+
+	counters := []struct {
+		name  string
+		help  string
+		value func(np *NotificationProtocol) uint64
+	}{
+		{"loopnet_notifications_sent_total", "Notifications sent to peers.",
+			func(np *NotificationProtocol) uint64 { return np.Metrics.NotificationsSent.Value() }},
+		{"loopnet_notifications_received_total", "Notifications received from peers.",
+			func(np *NotificationProtocol) uint64 { return np.Metrics.NotificationsReceived.Value() }},
+		{"loopnet_notifications_failed_total", "Notifications that could not be sent.",
+			func(np *NotificationProtocol) uint64 { return np.Metrics.NotificationsFailed.Value() }},
+		{"loopnet_notes_authenticated_total", "Received notes with a valid signature for the session.",
+			func(np *NotificationProtocol) uint64 { return np.Metrics.NotesAuthenticated.Value() }},
+		{"loopnet_notes_rejected_total", "Received notes with an invalid signature or session.",
+			func(np *NotificationProtocol) uint64 { return np.Metrics.NotesRejected.Value() }},
+		{"loopnet_notes_skipped_total", "Received notes not verified for not being newer than the stored revision.",
+			func(np *NotificationProtocol) uint64 { return np.Metrics.NotesSkipped.Value() }},
+		{"loopnet_reconciliations_total", "Reconciliations started with a peer.",
+			func(np *NotificationProtocol) uint64 { return np.Metrics.Reconciliations.Value() }},
+		{"loopnet_reconciliations_failed_total", "Reconciliations that did not finish.",
+			func(np *NotificationProtocol) uint64 { return np.Metrics.ReconciliationsFailed.Value() }},
+		{"loopnet_notes_reconciled_total", "Notes received by reconciliation.",
+			func(np *NotificationProtocol) uint64 { return np.Metrics.NotesReconciled.Value() }},
+		{"loopnet_divergence_alerts_total", "Alerts raised for peers disagreeing with this node's notes.",
+			func(np *NotificationProtocol) uint64 { return np.Metrics.DivergenceAlerts.Value() }},
+		{"loopnet_notes_accepted_total", "Notes stored as new notes or newer revisions.",
+			func(np *NotificationProtocol) uint64 { return np.NoteStore.Metrics().NotesAccepted.Value() }},
+		{"loopnet_notes_stale_total", "Notes ignored because a newer revision is stored.",
+			func(np *NotificationProtocol) uint64 { return np.NoteStore.Metrics().NotesStale.Value() }},
+		{"loopnet_dead_notes_cleared_total", "Notes removed for falling behind the reference revision.",
+			func(np *NotificationProtocol) uint64 { return np.NoteStore.Metrics().DeadNotesCleared.Value() }},
+	}
+	for _, counter := range counters {
+		writeMetricHeader(w, counter.name, counter.help, "counter")
+		for _, np := range sessions {
+			fmt.Fprintf(w, "%s{session=%s} %d\n", counter.name, metricLabel(np.session), counter.value(np))
+		}
+	}
+
+	writeMetricHeader(w, "loopnet_active_notes", "Notes currently stored.", "gauge")
+	for _, np := range sessions {
+		fmt.Fprintf(w, "loopnet_active_notes{session=%s} %d\n", metricLabel(np.session), np.NoteStore.ActiveNotes())
+	}
+
+	writeMetricHeader(w, "loopnet_reference_revision", "Reference revision notes are aged against.", "gauge")
+	for _, np := range sessions {
+		fmt.Fprintf(w, "loopnet_reference_revision{session=%s} %d\n", metricLabel(np.session), np.NoteStore.ReferenceRevision())
+	}
+
+	writeMetricHeader(w, "loopnet_hot_notes", "Recently changed notes sent ahead of the random sample.", "gauge")
+	for _, np := range sessions {
+		fmt.Fprintf(w, "loopnet_hot_notes{session=%s} %d\n", metricLabel(np.session), np.rumors.count())
+	}
+
+	writeMetricHeader(w, "loopnet_state_agreement_percent", "Peers whose notes agree with this node's, by state hash.", "gauge")
+	for _, np := range sessions {
+		fmt.Fprintf(w, "loopnet_state_agreement_percent{session=%s} %g\n", metricLabel(np.session), np.Agreement().Percent())
+	}
+
+	// signatures are verified by the node for every session
+	writeMetricHeader(w, "loopnet_signature_cache_hits_total", "Notes authenticated by an earlier verification.", "counter")
+	fmt.Fprintf(w, "loopnet_signature_cache_hits_total %d\n", node.signatures.hits.Value())
+	writeMetricHeader(w, "loopnet_signature_cache_misses_total", "Notes whose signature had to be verified.", "counter")
+	fmt.Fprintf(w, "loopnet_signature_cache_misses_total %d\n", node.signatures.misses.Value())
+
+	writeMetricHeader(w, "loopnet_verification_queue", "Received notes waiting to be verified.", "gauge")
+	fmt.Fprintf(w, "loopnet_verification_queue %d\n", node.verifier.queued())
+
+	histograms := []struct {
+		name      string
+		help      string
+		histogram func(np *NotificationProtocol) *Histogram
+	}{
+		{"loopnet_stream_open_seconds", "Time to open a notification stream to a peer.",
+			func(np *NotificationProtocol) *Histogram { return np.Metrics.StreamOpen }},
+		{"loopnet_note_propagation_seconds", "Time from the creation of a note revision to its arrival.",
+			func(np *NotificationProtocol) *Histogram { return np.Metrics.PropagationLatency }},
+		{"loopnet_note_propagation_hops", "Nodes a note revision passed through to arrive.",
+			func(np *NotificationProtocol) *Histogram { return np.Metrics.PropagationHops }},
+	}
+	for _, histogram := range histograms {
+		writeMetricHeader(w, histogram.name, histogram.help, "histogram")
+		for _, np := range sessions {
+			histogram.histogram(np).write(w, histogram.name, "session="+metricLabel(np.session))
+		}
+	}
+}
+
+// write the cumulative buckets, sum and count of a histogram
+func (h *Histogram) write(w io.Writer, name string, labels string) {
+	h.histogramMux.Lock()
+	defer h.histogramMux.Unlock()
+
+	cumulative := uint64(0)
+	for i, bound := range h.bounds {
+		cumulative += h.counts[i]
+		fmt.Fprintf(w, "%s_bucket{%s,le=\"%g\"} %d\n", name, labels, bound, cumulative)
+	}
+	fmt.Fprintf(w, "%s_bucket{%s,le=\"+Inf\"} %d\n", name, labels, h.count)
+	fmt.Fprintf(w, "%s_sum{%s} %g\n", name, labels, h.sum)
+	fmt.Fprintf(w, "%s_count{%s} %d\n", name, labels, h.count)
+}
+
+func writeMetricHeader(w io.Writer, name string, help string, metricType string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, metricType)
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// a quoted label value
+func metricLabel(value string) string {
+	return `"` + labelEscaper.Replace(value) + `"`
+}
@@ -0,0 +1,85 @@
+package loopnet
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMetrics(t *testing.T) {
+	t.Run("Histogram", func(t *testing.T) {
+		h := NewHistogram([]float64{0.01, 0.1})
+		h.Observe(5 * time.Millisecond)
+		h.Observe(50 * time.Millisecond)
+		h.Observe(time.Second)
+
+		out := &strings.Builder{}
+		h.write(out, "latency", `session="jam"`)
+		expected := `latency_bucket{session="jam",le="0.01"} 1
+latency_bucket{session="jam",le="0.1"} 2
+latency_bucket{session="jam",le="+Inf"} 3
+latency_sum{session="jam"} 1.055
+latency_count{session="jam"} 3
+`
+		if out.String() != expected {
+			t.Errorf("expected\n%s\ngot\n%s", expected, out)
+		}
+	})
+
+	t.Run("NoteStore counts accepted, stale and cleared notes", func(t *testing.T) {
+		noteStore := NewNoteStore(createNote("self", 0, 60, false))
+		noteStore.OnNote(*createNote("n1", 2, 62, false))
+		noteStore.OnNote(*createNote("n1", 1, 61, false))
+		for i := uint32(1); i < 30; i++ {
+			noteStore.OnNote(*createNote("n2", i, 64, false))
+		}
+		noteStore.ClearDeadNotes()
+
+		if noteStore.Metrics().NotesAccepted.Value() != 30 || noteStore.Metrics().NotesStale.Value() != 1 {
+			t.Errorf("unexpected counts %+v", noteStore.Metrics())
+		}
+		if noteStore.Metrics().DeadNotesCleared.Value() != 2 {
+			t.Errorf("expected self and n1 to be cleared, got %d", noteStore.Metrics().DeadNotesCleared.Value())
+		}
+	})
+
+	t.Run("MetricsHandler", func(t *testing.T) {
+		node := createTestNode(t)
+		other := createTestNode(t)
+		jam := node.JoinSession("jam", 60, false)
+		other.JoinSession("jam", 62, false).ConnectToHost(node)
+		waitFor(t, func() bool { return jam.NoteStore.ActiveNotes() == 2 })
+		jam.Notify()
+
+		server := httptest.NewServer(MetricsHandler(node))
+		defer server.Close()
+		res, err := server.Client().Get(server.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		body, _ := ioutil.ReadAll(res.Body)
+		metrics := string(body)
+
+		for _, line := range []string{
+			"# TYPE loopnet_notifications_sent_total counter\n",
+			`loopnet_notifications_sent_total{session="jam"} 1` + "\n",
+			`loopnet_notifications_received_total{session="jam"} 1` + "\n",
+			`loopnet_notes_authenticated_total{session="jam"} 1` + "\n",
+			`loopnet_active_notes{session="jam"} 2` + "\n",
+			`loopnet_stream_open_seconds_count{session="jam"} 1` + "\n",
+		} {
+			if !strings.Contains(metrics, line) {
+				t.Errorf("missing %q from\n%s", line, metrics)
+			}
+		}
+	})
+
+	t.Run("escapes label values", func(t *testing.T) {
+		if metricLabel(`a"b\c`) != `"a\"b\\c"` {
+			t.Errorf("unexpected label %s", metricLabel(`a"b\c`))
+		}
+	})
+}
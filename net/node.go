@@ -2,15 +2,27 @@ package loopnet
 
 import (
 	"bufio"
-	"log"
+	"bytes"
+	"context"
+	"log/slog"
+	"runtime"
+	"sort"
+	"sync"
+	"time"
 
 	"github.com/gogo/protobuf/proto"
 
 	p2p "github.com/acruikshank/loopnet/pb"
 	crypto "github.com/libp2p/go-libp2p-crypto"
 	host "github.com/libp2p/go-libp2p-host"
+	ipnet "github.com/libp2p/go-libp2p-interface-pnet"
 	inet "github.com/libp2p/go-libp2p-net"
 	peer "github.com/libp2p/go-libp2p-peer"
+	ps "github.com/libp2p/go-libp2p-peerstore"
+	pnet "github.com/libp2p/go-libp2p-pnet"
+	swarm "github.com/libp2p/go-libp2p-swarm"
+	bhost "github.com/libp2p/go-libp2p/p2p/host/basic"
+	ma "github.com/multiformats/go-multiaddr"
 	protobufCodec "github.com/multiformats/go-multicodec/protobuf"
 )
 
@@ -19,21 +31,136 @@ const clientVersion = "go-p2p-node/0.0.1"
 
 // Node type - a p2p host implementing one or more p2p protocols
 type Node struct {
-	host.Host // lib-p2p host
-	*NotificationProtocol
+	host.Host                                    // lib-p2p host
+	sessions    map[string]*NotificationProtocol // joined sessions by session id
+	sessionsMux *sync.RWMutex
+	Clock       *ClockProtocol                 // clock synchronized with the swarm
+	Logging     *Logging                       // loggers and log levels of the node's components
+	NewStore    func(self *p2p.NoteData) Store // creates the note store of each joined session, NewNoteStore if nil
+	signatures  *signatureCache
+	verifier    *verificationPool // verifies received notes for every session
 }
 
-// Create a new node with its implemented protocols
-func NewNode(host host.Host) *Node {
-	node := &Node{Host: host}
-	node.NotificationProtocol = NewNotificationProtocol(node)
-	return node
+// Create a new node with a fresh identity listening on listen.
+// psk: pre-shared key file contents (see GeneratePSK). When set, the node joins
+// a libp2p private network and can only connect to peers holding the same key.
+func NewNode(listen ma.Multiaddr, psk []byte) (*Node, error) {
+	priv, pub, err := crypto.GenerateKeyPair(crypto.Secp256k1, 256)
+	if err != nil {
+		return nil, err
+	}
+	pid, err := peer.IDFromPublicKey(pub)
+	if err != nil {
+		return nil, err
+	}
+	peerStore := ps.NewPeerstore()
+	peerStore.AddPrivKey(pid, priv)
+	peerStore.AddPubKey(pid, pub)
+
+	var protector ipnet.Protector
+	if psk != nil {
+		protector, err = pnet.NewProtector(bytes.NewReader(psk))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	n, err := swarm.NewNetworkWithProtector(context.Background(), []ma.Multiaddr{listen}, pid, peerStore, protector, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	node := &Node{
+		Host:        bhost.New(n),
+		sessions:    make(map[string]*NotificationProtocol),
+		sessionsMux: &sync.RWMutex{},
+		Logging:     NewLogging(nil),
+		signatures:  newSignatureCache(signatureCacheSize),
+		verifier:    newVerificationPool(runtime.NumCPU()),
+	}
+	node.Clock = NewClockProtocol(node)
+	return node, nil
+}
+
+// JoinSession starts participating in a session with the given initial note.
+// Each session gets its own NoteStore and notification protocol id. Joining a
+// session the node is already part of returns the existing protocol.
+func (n *Node) JoinSession(session string, note int, mute bool) *NotificationProtocol {
+	return n.joinSession(session, "", nil, note, mute)
+}
+
+// join a session, private if owner is set
+// proof: this node's admission to a private session owned by another node
+func (n *Node) joinSession(session string, owner string, proof *p2p.Admission, note int, mute bool) *NotificationProtocol {
+	n.sessionsMux.Lock()
+	defer n.sessionsMux.Unlock()
+
+	if np, found := n.sessions[session]; found {
+		return np
+	}
+
+	np := NewNotificationProtocol(n, session, owner, n.NewNoteData(session, 0, note, mute))
+	if proof != nil {
+		np.NoteStore.Admit(proof.NodeId, time.Unix(proof.Invitation.Expires, 0), proof)
+	}
+	n.sessions[session] = np
+	return np
+}
+
+// LeaveSession stops handling notifications for a session and drops its notes.
+func (n *Node) LeaveSession(session string) {
+	n.sessionsMux.Lock()
+	defer n.sessionsMux.Unlock()
+
+	np, found := n.sessions[session]
+	if !found {
+		return
+	}
+	np.close()
+	delete(n.sessions, session)
+}
+
+// Close leaves every session, stops the workers verifying notes and
+// closes the host.
+func (n *Node) Close() error {
+	for _, session := range n.Sessions() {
+		n.LeaveSession(session)
+	}
+	n.verifier.close()
+	return n.Host.Close()
+}
+
+// Session returns the notification protocol for a joined session.
+func (n *Node) Session(session string) (*NotificationProtocol, bool) {
+	n.sessionsMux.RLock()
+	defer n.sessionsMux.RUnlock()
+
+	np, found := n.sessions[session]
+	return np, found
+}
+
+// Sessions returns the sorted ids of all joined sessions.
+func (n *Node) Sessions() []string {
+	n.sessionsMux.RLock()
+	defer n.sessionsMux.RUnlock()
+
+	sessions := make([]string, 0, len(n.sessions))
+	for session := range n.sessions {
+		sessions = append(sessions, session)
+	}
+	sort.Strings(sessions)
+	return sessions
 }
 
 // Authenticate incoming p2p message
 // message: a protobufs go data object
 // data: common p2p message data
 func (n *Node) authenticateNote(data *p2p.NoteData) bool {
+	// notes are gossiped many times, verify each revision once
+	if n.signatures.verified(data) {
+		return true
+	}
+
 	// store a temp ref to signature and remove it from message data
 	// sign is a string to allow easy reset to zero-value (empty string)
 	sign := data.Sign
@@ -42,7 +169,7 @@ func (n *Node) authenticateNote(data *p2p.NoteData) bool {
 	// marshall data without the signature to protobufs3 binary format
 	bin, err := proto.Marshal(data)
 	if err != nil {
-		log.Println(err, "failed to marshal pb message")
+		n.logger(logNode).Warn("failed to marshal note", "err", err)
 		return false
 	}
 
@@ -52,13 +179,17 @@ func (n *Node) authenticateNote(data *p2p.NoteData) bool {
 	// restore peer id binary format from base58 encoded node id data
 	peerId, err := peer.IDB58Decode(data.NodeId)
 	if err != nil {
-		log.Println(err, "Failed to decode node id from base58")
+		n.logger(logNode).Warn("failed to decode node id from base58", "err", err, "author", data.NodeId)
 		return false
 	}
 
 	// verify the data was authored by the signing peer identified by the public key
 	// and signature included in the message
-	return n.verifyData(bin, sign, peerId, data.NodePubKey)
+	if !n.verifyData(bin, sign, peerId, data.NodePubKey) {
+		return false
+	}
+	n.signatures.add(data)
+	return true
 }
 
 // sign an outgoing p2p message payload
@@ -86,40 +217,54 @@ func (n *Node) verifyData(data []byte, signature []byte, peerId peer.ID, pubKey
 	// extract node id from the provided public key
 	key, err := crypto.UnmarshalPublicKey(pubKey)
 	if err != nil {
-		log.Println(err, "Failed to extract key from message key data")
+		n.logger(logNode).Warn("failed to extract key from message key data", "err", err)
 		return false
 	}
 
 	// extract node id from the provided public key
 	idFromKey, err := peer.IDFromPublicKey(key)
 	if err != nil {
-		log.Println(err, "Failed to extract peer id from public key")
-		return false
-	}
-
-	if err != nil {
-		log.Println(err, "Failed to extract peer id from public key")
+		n.logger(logNode).Warn("failed to extract peer id from public key", "err", err)
 		return false
 	}
 
 	// verify that message author node id matches the provided node public key
 	if idFromKey != peerId {
-		log.Println(err, "Node id and provided public key mismatch")
+		n.logger(logNode).Warn("node id and provided public key mismatch", "author", peer.IDB58Encode(peerId))
 		return false
 	}
 
 	res, err := key.Verify(data, signature)
 	if err != nil {
-		log.Println(err, "Error authenticating data")
+		n.logger(logNode).Warn("error authenticating data", "err", err, "author", peer.IDB58Encode(peerId))
 		return false
 	}
 
 	return res
 }
 
+// logger of one of the node's components, with the node's id
+func (n *Node) logger(component string) *slog.Logger {
+	return n.Logging.Logger(component).With("node", peer.IDB58Encode(n.ID()))
+}
+
+// the note store for a session joined with the local node's note self
+func (n *Node) newStore(self *p2p.NoteData) Store {
+	if n.NewStore != nil {
+		return n.NewStore(self)
+	}
+	return NewNoteStore(self)
+}
+
 // helper method - generate message data shared between all node's p2p protocols
-// messageId: unique for requests, copied from request for responses
-func (n *Node) NewNoteData(revision int, note int, mute bool) *p2p.NoteData {
+// session: id of the session the note is played in
+func (n *Node) NewNoteData(session string, revision int, note int, mute bool) *p2p.NoteData {
+	return n.NewScheduledNoteData(session, revision, note, mute, time.Time{})
+}
+
+// NewScheduledNoteData generates a note that takes effect at a swarm time.
+// A zero effective time means the note takes effect as soon as it is received.
+func (n *Node) NewScheduledNoteData(session string, revision int, note int, mute bool, effective time.Time) *p2p.NoteData {
 	// Add protobufs bin data for message author public key
 	// this is useful for authenticating  messages forwarded by a node authored by another node
 	nodePubKey, err := n.Peerstore().PubKey(n.ID()).Bytes()
@@ -134,9 +279,13 @@ func (n *Node) NewNoteData(revision int, note int, mute bool) *p2p.NoteData {
 		Note:          uint32(note),
 		Mute:          mute,
 		NodeId:        peer.IDB58Encode(n.ID()),
-		Address:	   n.Addrs()[0].String(),
+		Address:       n.Addrs()[0].String(),
 		NodePubKey:    nodePubKey,
-		Sign:          make([]byte, 0)}
+		Sign:          make([]byte, 0),
+		Session:       session}
+	if !effective.IsZero() {
+		noteData.Effective = effective.UnixNano()
+	}
 
 	signature, err := n.signProtoNote(noteData)
 	if err != nil {
@@ -156,7 +305,7 @@ func (n *Node) sendProtoMessage(data proto.Message, s inet.Stream) bool {
 	enc := protobufCodec.Multicodec(nil).Encoder(writer)
 	err := enc.Encode(data)
 	if err != nil {
-		log.Println(err)
+		n.logger(logNode).Warn("failed to send message", "err", err, "peer", peer.IDB58Encode(s.Conn().RemotePeer()))
 		return false
 	}
 	writer.Flush()
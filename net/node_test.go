@@ -0,0 +1,242 @@
+package loopnet
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"testing"
+	"time"
+
+	p2p "github.com/acruikshank/loopnet/pb"
+	peer "github.com/libp2p/go-libp2p-peer"
+	ps "github.com/libp2p/go-libp2p-peerstore"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+func TestSessions(t *testing.T) {
+	t.Run("JoinSession", func(t *testing.T) {
+		node := createTestNode(t)
+
+		t.Run("returns the existing protocol when already joined", func(t *testing.T) {
+			first := node.JoinSession("jam", 60, false)
+			second := node.JoinSession("jam", 62, false)
+
+			if first != second {
+				t.Error("joined the same session twice")
+			}
+		})
+
+		t.Run("keeps a separate store per session", func(t *testing.T) {
+			node.JoinSession("other", 64, false)
+
+			jam, _ := node.Session("jam")
+			other, _ := node.Session("other")
+			if jam.NoteStore == other.NoteStore {
+				t.Error("sessions share a NoteStore")
+			}
+
+			expectation := []string{"jam", "other"}
+			if fmt.Sprint(node.Sessions()) != fmt.Sprint(expectation) {
+				t.Errorf("Expected sessions %v, got %v", expectation, node.Sessions())
+			}
+		})
+
+		t.Run("LeaveSession forgets the session", func(t *testing.T) {
+			node.LeaveSession("other")
+
+			if _, ok := node.Session("other"); ok {
+				t.Error("session still joined after leaving")
+			}
+		})
+	})
+
+	t.Run("Close leaves every session and stops verifying notes", func(t *testing.T) {
+		node := createTestNode(t)
+		node.JoinSession("jam", 60, false)
+
+		if err := node.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if len(node.Sessions()) != 0 || !node.verifier.closed {
+			t.Error("expected the node to be closed")
+		}
+	})
+
+	t.Run("notifications", func(t *testing.T) {
+		node1 := createTestNode(t)
+		node2 := createTestNode(t)
+		jam1 := node1.JoinSession("jam", 60, false)
+		other1 := node1.JoinSession("other", 61, false)
+		jam2 := node2.JoinSession("jam", 62, false)
+
+		t.Run("notes are shared within a session", func(t *testing.T) {
+			jam1.ConnectToHost(node2)
+
+			waitFor(t, func() bool { return jam2.NoteStore.ActiveNotes() == 2 })
+		})
+
+		t.Run("notes are not shared with other sessions", func(t *testing.T) {
+			other1.ConnectToHost(node2)
+			time.Sleep(50 * time.Millisecond)
+
+			if jam2.NoteStore.ActiveNotes() != 2 {
+				t.Error("note from another session was stored")
+			}
+		})
+
+		t.Run("notes for a session not joined are rejected", func(t *testing.T) {
+			// sign a note for "other" and send it over the "jam" stream
+			node3 := createTestNode(t)
+			jam3 := node3.JoinSession("jam", 63, false)
+			other3 := node3.JoinSession("other", 64, false)
+			node3.Peerstore().AddAddrs(node2.ID(), node2.Addrs(), ps.PermanentAddrTTL)
+
+			note, _ := other3.NoteStore.LastRevision(peer.IDB58Encode(node3.ID()))
+			s, err := jam3.OpenStream(node2.ID())
+			if err != nil {
+				t.Fatal(err)
+			}
+			node3.sendProtoMessage(&p2p.Message{Notes: []*p2p.NoteData{&note}}, s)
+			time.Sleep(50 * time.Millisecond)
+
+			if _, ok := jam2.NoteStore.LastRevision(peer.IDB58Encode(node3.ID())); ok {
+				t.Error("stored a note addressed to another session")
+			}
+		})
+
+		t.Run("notes scheduled too far ahead are rejected", func(t *testing.T) {
+			node3 := createTestNode(t)
+			note := node3.NewScheduledNoteData("jam", 1, 65, false, time.Now().Add(maxScheduleAhead+time.Hour))
+			rejected := jam2.Metrics.NotesRejected.Value()
+
+			jam2.onEnvelope(&p2p.NoteEnvelope{Note: note}, "test")
+			if _, ok := jam2.NoteStore.LastRevision(note.NodeId); ok || jam2.Metrics.NotesRejected.Value() != rejected+1 {
+				t.Error("stored a note that would not take effect for an hour")
+			}
+		})
+	})
+}
+
+func TestSharedParameters(t *testing.T) {
+	node1 := createTestNode(t)
+	node2 := createTestNode(t)
+	jam1 := node1.JoinSession("jam", 60, false)
+	jam2 := node2.JoinSession("jam", 62, false)
+	jam1.ConnectToHost(node2)
+	waitFor(t, func() bool { return jam2.NoteStore.ActiveNotes() == 2 })
+
+	t.Run("concurrent proposals converge on every node", func(t *testing.T) {
+		if err := jam1.ProposeTempo(100); err != nil {
+			t.Fatal(err)
+		}
+		if err := jam2.ProposeTempo(90); err != nil {
+			t.Fatal(err)
+		}
+		jam2.ProposeScale("minor")
+
+		waitFor(t, func() bool {
+			jam1.Notify()
+			jam2.Notify()
+			return jam1.Parameters.Current() == jam2.Parameters.Current() &&
+				jam1.Parameters.Current().Scale == "minor"
+		})
+	})
+
+	t.Run("rejects invalid proposals", func(t *testing.T) {
+		if jam1.ProposeScale("nonexistent") == nil || jam1.ProposeRoot(12) == nil || jam1.ProposeSwing(2) == nil {
+			t.Error("accepted an invalid proposal")
+		}
+		for _, tempo := range []float32{0, 1e20, float32(math.Inf(1)), float32(math.NaN())} {
+			if jam1.ProposeTempo(tempo) == nil {
+				t.Errorf("accepted tempo %v", tempo)
+			}
+		}
+	})
+}
+
+func TestPrivateNetwork(t *testing.T) {
+	psk1, _ := GeneratePSK()
+	psk2, _ := GeneratePSK()
+
+	t.Run("GeneratePSK writes the swarm.key format", func(t *testing.T) {
+		if !bytes.HasPrefix(psk1, []byte(pskHeader)) {
+			t.Errorf("missing psk header in %q", psk1)
+		}
+		if bytes.Equal(psk1, psk2) {
+			t.Error("generated the same key twice")
+		}
+	})
+
+	t.Run("nodes sharing a key exchange notifications", func(t *testing.T) {
+		node1 := createPrivateTestNode(t, psk1)
+		node2 := createPrivateTestNode(t, psk1)
+		jam1 := node1.JoinSession("jam", 60, false)
+		jam2 := node2.JoinSession("jam", 62, false)
+
+		jam1.ConnectToHost(node2)
+
+		waitFor(t, func() bool { return jam2.NoteStore.ActiveNotes() == 2 })
+		if jam1.NoteStore.ActiveNotes() != 1 {
+			t.Error("unexpected notes in sender store")
+		}
+	})
+
+	t.Run("nodes with mismatched keys cannot exchange notifications", func(t *testing.T) {
+		node1 := createPrivateTestNode(t, psk1)
+		node2 := createPrivateTestNode(t, psk2)
+		jam1 := node1.JoinSession("jam", 60, false)
+		jam2 := node2.JoinSession("jam", 62, false)
+
+		jam1.ConnectToHost(node2)
+		jam2.ConnectToHost(node1)
+		time.Sleep(50 * time.Millisecond)
+
+		if jam1.NoteStore.ActiveNotes() != 1 || jam2.NoteStore.ActiveNotes() != 1 {
+			t.Error("notes were exchanged across private networks")
+		}
+	})
+
+	t.Run("open nodes cannot reach private nodes", func(t *testing.T) {
+		node1 := createTestNode(t)
+		node2 := createPrivateTestNode(t, psk1)
+		jam1 := node1.JoinSession("jam", 60, false)
+		jam2 := node2.JoinSession("jam", 62, false)
+
+		jam1.ConnectToHost(node2)
+		time.Sleep(50 * time.Millisecond)
+
+		if jam2.NoteStore.ActiveNotes() != 1 {
+			t.Error("private node accepted a note from outside its network")
+		}
+	})
+}
+
+// create a node listening on an ephemeral local port
+func createTestNode(t testing.TB) *Node {
+	return createPrivateTestNode(t, nil)
+}
+
+// create a node on an ephemeral local port in the private network for psk
+func createPrivateTestNode(t testing.TB, psk []byte) *Node {
+	listen, err := ma.NewMultiaddr("/ip4/127.0.0.1/tcp/0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	node, err := NewNode(listen, psk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { node.Close() })
+	return node
+}
+
+// poll until the condition holds, failing the test after a second
+func waitFor(t *testing.T, condition func() bool) {
+	deadline := time.Now().Add(time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for condition")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
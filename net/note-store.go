@@ -3,37 +3,93 @@ package loopnet
 import (
 	"crypto/rand"
 	p2p "github.com/acruikshank/loopnet/pb"
+	"log/slog"
 	"math/big"
 	"sort"
 	"sync"
+	"time"
 )
 
 const deadNoteRevisions = 20
 
+// most revisions of a note kept pending until a later revision takes effect
+const maxPendingStates = 8
+
 type Note struct {
 	revision uint32
 	*p2p.NoteData
+	earlier []*p2p.NoteData // earlier revisions in effect until NoteData takes effect, oldest first
+	hops    uint32          // nodes the revision passed through to reach us, 0 for our own note
+	origin  int64           // swarm time (unix ns) the revision was created, 0 if unknown
 }
 
+// NoteStore keeps the notes of a session behind a single lock. See
+// ShardedNoteStore for nodes receiving from many peers at once.
 type NoteStore struct {
-	selfId            string
+	*sessionAccess
 	referenceRevision uint32
 	notes             map[string]Note
+	ids               []string       // ids of stored notes in no particular order, for sampling
+	positions         map[string]int // index of each stored note's id in ids
+	index             *pitchIndex    // pitches of the stored notes
+	clock             Clock          // decides when scheduled notes take effect
+	metrics           *StoreMetrics
+	logger            *slog.Logger
+	random            Random // picks the notes to gossip
+	randomMux         *sync.Mutex
 	noteMux           *sync.RWMutex
 }
 
+// Random picks random numbers. A *math/rand.Rand with a fixed seed makes
+// gossip reproducible.
+type Random interface {
+	// Intn returns a number in [0, n).
+	Intn(n int) int
+}
+
+// cryptoRandom picks numbers with crypto/rand
+type cryptoRandom struct{}
+
+func (cryptoRandom) Intn(n int) int {
+	return randomInt(n)
+}
+
+// Controls is the session state set by conductor commands.
+type Controls struct {
+	Muted bool    // all notes muted
+	Tempo float32 // beats per minute, 0 if never set
+	Scale string  // scale name, empty if never set
+}
+
+// admission of a node to a private session
+type admission struct {
+	expires time.Time      // zero if the admission never expires
+	proof   *p2p.Admission // invitation presented by the node, nil for the owner
+}
+
 // NewNoteStore creates a new store with the initial revision of the local node's note.
 func NewNoteStore(self *p2p.NoteData) *NoteStore {
 	n := &NoteStore{
-		selfId:            self.NodeId,
+		sessionAccess:     newSessionAccess(self.NodeId),
 		referenceRevision: 0,
 		notes:             make(map[string]Note),
+		positions:         make(map[string]int),
+		index:             newPitchIndex(),
+		clock:             localClock{},
+		metrics:           &StoreMetrics{},
+		logger:            NewLogging(nil).Logger(logStore),
+		random:            cryptoRandom{},
+		randomMux:         &sync.Mutex{},
 		noteMux:           &sync.RWMutex{},
 	}
 	n.notes[self.NodeId] = Note{
 		revision: 0,
 		NoteData: self,
+		origin:   n.clock.Now().UnixNano(),
 	}
+	n.addIdLocked(self.NodeId)
+	n.index.add(n.notes[self.NodeId], n.clock.Now())
+	n.publishLocked()
 	return n
 }
 
@@ -43,11 +99,38 @@ func (ns *NoteStore) OnNote(note p2p.NoteData) bool {
 	ns.noteMux.Lock()
 	defer ns.noteMux.Unlock()
 
+	origin := int64(0)
+	if note.NodeId == ns.selfId {
+		origin = ns.clock.Now().UnixNano()
+	}
+	_, added := ns.onNoteLocked(note, 0, origin)
+	return added
+}
+
+// OnEnvelope takes a note relayed by gossip and stores it like OnNote,
+// remembering its hop count and origin to relay it further. It returns
+// whether the note was stored and whether its node is new to the store.
+func (ns *NoteStore) OnEnvelope(envelope p2p.NoteEnvelope) (stored bool, added bool) {
+	ns.noteMux.Lock()
+	defer ns.noteMux.Unlock()
+
+	return ns.onNoteLocked(*envelope.Note, envelope.Hops, envelope.Origin)
+}
+
+// callers must hold noteMux
+func (ns *NoteStore) onNoteLocked(note p2p.NoteData, hops uint32, origin int64) (stored bool, added bool) {
+	// ignore nodes that were never invited to a private session or were kicked
+	if !ns.accepts(note.NodeId) {
+		return false, false
+	}
+
 	existingNote, found := ns.notes[note.NodeId]
 	if found {
 		// ignore stale information
 		if existingNote.Revision >= note.Revision {
-			return false
+			ns.metrics.NotesStale.Inc()
+			ns.logger.Debug("ignored stale note", "author", note.NodeId, "revision", note.Revision, "stored", existingNote.Revision)
+			return false, false
 		}
 
 		// start a new referenceRevision round if this node is up-to-date
@@ -56,13 +139,77 @@ func (ns *NoteStore) OnNote(note p2p.NoteData) bool {
 		}
 	}
 
-	// add or update node
-	ns.notes[note.NodeId] = Note{
+	// add or update node, keeping revisions still needed until this one takes effect
+	updated := Note{
 		revision: ns.referenceRevision,
 		NoteData: &note,
+		hops:     hops,
+		origin:   origin,
+	}
+	now := ns.clock.Now()
+	if !takesEffect(&note, now) {
+		updated.earlier = pendingStates(existingNote, now)
 	}
+	if found {
+		ns.index.remove(existingNote)
+	}
+	ns.notes[note.NodeId] = updated
+	if !found {
+		ns.addIdLocked(note.NodeId)
+	}
+	ns.index.settle(now)
+	ns.index.add(updated, now)
+	ns.publishLocked()
+	ns.metrics.NotesAccepted.Inc()
+	ns.logger.Debug("stored note", "author", note.NodeId, "revision", note.Revision, "note", note.Note, "mute", note.Mute, "hops", hops)
+
+	return true, !found
+}
+
+// Stale returns whether a note is not newer than the stored revision of
+// its node, so that OnNote would ignore it.
+func (ns *NoteStore) Stale(note *p2p.NoteData) bool {
+	ns.noteMux.RLock()
+	defer ns.noteMux.RUnlock()
 
-	return !found
+	existingNote, found := ns.notes[note.NodeId]
+	return found && existingNote.Revision >= note.Revision
+}
+
+// Metrics returns the counts of changes to the store.
+func (ns *NoteStore) Metrics() *StoreMetrics {
+	return ns.metrics
+}
+
+// callers must hold noteMux. Replaces the snapshot read by ActiveNoteNumbersAt.
+func (ns *NoteStore) publishLocked() {
+	ns.index.publish(ns.Controls().Muted)
+}
+
+// SetRandom sets the source of randomness picking notes to gossip.
+func (ns *NoteStore) SetRandom(random Random) {
+	ns.randomMux.Lock()
+	defer ns.randomMux.Unlock()
+
+	ns.random = random
+}
+
+// SetLogger sets the logger of the store.
+func (ns *NoteStore) SetLogger(logger *slog.Logger) {
+	ns.noteMux.Lock()
+	defer ns.noteMux.Unlock()
+
+	ns.logger = logger
+}
+
+// SetClock sets the clock deciding when scheduled notes take effect,
+// e.g. the node's swarm clock so that changes take effect on every node
+// at the same moment.
+func (ns *NoteStore) SetClock(clock Clock) {
+	ns.noteMux.Lock()
+	defer ns.noteMux.Unlock()
+
+	ns.clock = clock
 }
 
 // returns a slice of notes chosen randomly from active notes.
@@ -73,27 +220,101 @@ func (ns *NoteStore) RandomNotes(count int, excludeSelf bool) []*p2p.NoteData {
 	ns.noteMux.RLock()
 	defer ns.noteMux.RUnlock()
 
-	keys := make([]string, 0)
-	for nodeId := range ns.notes {
-		if !excludeSelf || nodeId != ns.selfId {
-			keys = append(keys, nodeId)
-		}
+	out := make([]*p2p.NoteData, 0)
+	for _, note := range ns.randomNotesLocked(count, excludeSelf) {
+		out = append(out, note.NoteData)
 	}
 
-	if count > len(keys) {
-		count = len(keys)
+	return out
+}
+
+// RandomEnvelopes returns notes chosen randomly like RandomNotes, wrapped
+// for relaying with their hop count incremented and their origin.
+func (ns *NoteStore) RandomEnvelopes(count int, excludeSelf bool) []*p2p.NoteEnvelope {
+	ns.noteMux.RLock()
+	defer ns.noteMux.RUnlock()
+
+	out := make([]*p2p.NoteEnvelope, 0)
+	for _, note := range ns.randomNotesLocked(count, excludeSelf) {
+		out = append(out, note.envelope())
 	}
 
-	out := make([]*p2p.NoteData, 0)
+	return out
+}
+
+// Envelope returns the stored note of a node wrapped for relaying like
+// RandomEnvelopes.
+func (ns *NoteStore) Envelope(nodeId string) (*p2p.NoteEnvelope, bool) {
+	ns.noteMux.RLock()
+	defer ns.noteMux.RUnlock()
+
+	note, found := ns.notes[nodeId]
+	if !found {
+		return nil, false
+	}
+	return note.envelope(), true
+}
+
+// callers must hold noteMux. Picks count notes without repeats by
+// shuffling only the first count positions of ids, tracking the positions
+// swapped in a map rather than copying ids.
+func (ns *NoteStore) randomNotesLocked(count int, excludeSelf bool) []Note {
+	n := len(ns.ids)
+	swapped := make(map[int]int, count+2)
+
+	// move self out of reach at the end
+	if self, found := ns.positions[ns.selfId]; excludeSelf && found {
+		n--
+		swapped[self], swapped[n] = n, self
+	}
+
+	if count > n {
+		count = n
+	}
+
+	position := func(i int) int {
+		if j, found := swapped[i]; found {
+			return j
+		}
+		return i
+	}
+
+	ns.randomMux.Lock()
+	defer ns.randomMux.Unlock()
+
+	out := make([]Note, 0, count)
 	for i := 0; i < count; i++ {
-		index := randomInt(len(keys))
-		out = append(out, ns.notes[keys[index]].NoteData)
-		keys = append(keys[:index], keys[index+1:]...)
+		j := i + ns.random.Intn(n-i)
+		picked := position(j)
+		swapped[j] = position(i)
+		out = append(out, ns.notes[ns.ids[picked]])
 	}
 
 	return out
 }
 
+// callers must hold noteMux
+func (ns *NoteStore) addIdLocked(nodeId string) {
+	ns.positions[nodeId] = len(ns.ids)
+	ns.ids = append(ns.ids, nodeId)
+}
+
+// callers must hold noteMux. Moves the last id into the removed note's place.
+func (ns *NoteStore) removeNoteLocked(nodeId string) {
+	position, found := ns.positions[nodeId]
+	if !found {
+		return
+	}
+
+	last := ns.ids[len(ns.ids)-1]
+	ns.ids[position] = last
+	ns.positions[last] = position
+	ns.ids = ns.ids[:len(ns.ids)-1]
+	delete(ns.positions, nodeId)
+	ns.index.remove(ns.notes[nodeId])
+	delete(ns.notes, nodeId)
+}
+
 // ClearDeadNotes removes any note that appears to be dead.
 // Dead notes are identified as any note that has failed to
 // update within the time it has taken us to see some number
@@ -111,28 +332,43 @@ func (ns *NoteStore) ClearDeadNotes() {
 
 	if len(deadNotes) > 0 {
 		for nodeId := range deadNotes {
-			delete(ns.notes, nodeId)
+			ns.removeNoteLocked(nodeId)
 		}
+		ns.metrics.DeadNotesCleared.Add(uint64(len(deadNotes)))
+		ns.logger.Debug("cleared dead notes", "count", len(deadNotes), "referenceRevision", ns.referenceRevision)
 	}
+	ns.index.settle(ns.clock.Now())
+	ns.publishLocked()
 }
 
 // ActiveNoteNumbers returns a sorted list of all the midi
 // note number of all currently stored notes that are not
-// muted.
+// muted. It is empty while a conductor has muted everyone.
+// The returned slice must not be modified.
 func (ns *NoteStore) ActiveNoteNumbers() []int {
 	ns.noteMux.RLock()
-	defer ns.noteMux.RUnlock()
+	clock := ns.clock
+	ns.noteMux.RUnlock()
 
-	noteNumbers := make([]int, 0)
-	for _, note := range ns.notes {
-		if !note.Mute {
-			noteNumbers = append(noteNumbers, int(note.Note))
-		}
-	}
+	return ns.ActiveNoteNumbersAt(clock.Now())
+}
 
-	sort.Ints(noteNumbers)
+// ActiveNoteNumbersAt returns the sorted midi note numbers of the notes
+// that are in effect and not muted at a time no earlier than the last
+// change to the store: revisions replaced by a change that took effect are
+// forgotten. Nodes whose first note has not taken effect yet are left out.
+// It reads a snapshot of the store without locking; the returned slice may
+// be shared and must not be modified.
+func (ns *NoteStore) ActiveNoteNumbersAt(t time.Time) []int {
+	return ns.index.pitchesAt(t)
+}
+
+// ReferenceRevision returns the revision round notes are aged against.
+func (ns *NoteStore) ReferenceRevision() uint32 {
+	ns.noteMux.RLock()
+	defer ns.noteMux.RUnlock()
 
-	return noteNumbers
+	return ns.referenceRevision
 }
 
 // ActiveNotes returns the number of currently stored notes.
@@ -143,6 +379,44 @@ func (ns *NoteStore) ActiveNotes() int {
 	return len(ns.notes)
 }
 
+// Notes returns the latest revision of every stored note, sorted by node id.
+func (ns *NoteStore) Notes() []p2p.NoteData {
+	ns.noteMux.RLock()
+	defer ns.noteMux.RUnlock()
+
+	notes := make([]p2p.NoteData, 0, len(ns.notes))
+	for _, note := range ns.notes {
+		notes = append(notes, *note.NoteData)
+	}
+	sort.Slice(notes, func(i, j int) bool {
+		return notes[i].NodeId < notes[j].NodeId
+	})
+	return notes
+}
+
+// NoteStatus is a stored note and how far it has fallen behind.
+type NoteStatus struct {
+	p2p.NoteData
+	Lag uint32 // reference revisions since the note was last updated
+}
+
+// Statuses returns every stored note with its lag behind the reference
+// revision, sorted by node id. Notes are cleared once their lag exceeds
+// deadNoteRevisions.
+func (ns *NoteStore) Statuses() []NoteStatus {
+	ns.noteMux.RLock()
+	defer ns.noteMux.RUnlock()
+
+	statuses := make([]NoteStatus, 0, len(ns.notes))
+	for _, note := range ns.notes {
+		statuses = append(statuses, NoteStatus{NoteData: *note.NoteData, Lag: ns.referenceRevision - note.revision})
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].NodeId < statuses[j].NodeId
+	})
+	return statuses
+}
+
 // LastRevision takes a node id and returns whether the note
 // is currently being stored and its note message if so.
 func (ns *NoteStore) LastRevision(nodeId string) (p2p.NoteData, bool) {
@@ -156,6 +430,74 @@ func (ns *NoteStore) LastRevision(nodeId string) (p2p.NoteData, bool) {
 	return *note.NoteData, ok
 }
 
+// OnCommand takes a conductor command and stores it if it is newer than the
+// stored command of the same kind. Commands are ordered by revision, then by
+// conductor id. Kicked nodes are removed from the store. The command must
+// already be authenticated.
+func (ns *NoteStore) OnCommand(command p2p.Command) bool {
+	if !ns.onCommand(command) {
+		return false
+	}
+
+	ns.noteMux.Lock()
+	defer ns.noteMux.Unlock()
+
+	// never remove our own note, other nodes will ignore it
+	if command.Type == p2p.Command_KICK && command.Target != ns.selfId {
+		ns.removeNoteLocked(command.Target)
+	}
+	ns.publishLocked()
+	return true
+}
+
+// the note wrapped for relaying with its hop count incremented
+func (note Note) envelope() *p2p.NoteEnvelope {
+	envelope := &p2p.NoteEnvelope{Note: note.NoteData}
+	// notes received without an envelope have an unknown path
+	if note.origin != 0 {
+		envelope.Hops = note.hops + 1
+		envelope.Origin = note.origin
+	}
+	return envelope
+}
+
+// the latest revision in effect at a time, nil if none is
+func (note Note) stateAt(t time.Time) *p2p.NoteData {
+	if takesEffect(note.NoteData, t) {
+		return note.NoteData
+	}
+	for i := len(note.earlier) - 1; i >= 0; i-- {
+		if takesEffect(note.earlier[i], t) {
+			return note.earlier[i]
+		}
+	}
+	return nil
+}
+
+// revisions of a replaced note that may still be in effect for a while,
+// dropping those superseded by a later revision already in effect at now.
+// At most maxPendingStates are kept: the one in effect and the latest others.
+func pendingStates(note Note, now time.Time) []*p2p.NoteData {
+	if note.NoteData == nil {
+		return nil
+	}
+	states := append(append([]*p2p.NoteData{}, note.earlier...), note.NoteData)
+	for i := len(states) - 1; i > 0; i-- {
+		if takesEffect(states[i], now) {
+			states = states[i:]
+			break
+		}
+	}
+	if len(states) > maxPendingStates {
+		states = append(states[:1], states[len(states)-maxPendingStates+1:]...)
+	}
+	return states
+}
+
+func takesEffect(note *p2p.NoteData, t time.Time) bool {
+	return note.Effective == 0 || note.Effective <= t.UnixNano()
+}
+
 func randomInt(max int) int {
 	n, err := rand.Int(rand.Reader, big.NewInt(int64(max)))
 	if err != nil {
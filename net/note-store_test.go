@@ -3,8 +3,12 @@ package loopnet
 import (
 	"fmt"
 	p2p "github.com/acruikshank/loopnet/pb"
+	"math"
+	"math/rand"
 	"reflect"
+	"sort"
 	"testing"
+	"time"
 )
 
 func TestNoteStore(t *testing.T) {
@@ -104,6 +108,57 @@ func TestNoteStore(t *testing.T) {
 				}
 			}
 		})
+
+		t.Run("never returns a note twice", func(t *testing.T) {
+			randNotes := noteStore.RandomNotes(20, false)
+
+			seenIds := make(map[string]bool)
+			for _, note := range randNotes {
+				if seenIds[note.NodeId] {
+					t.Errorf("returned %s twice", note.NodeId)
+				}
+				seenIds[note.NodeId] = true
+			}
+			if len(seenIds) != 11 {
+				t.Errorf("expected all 11 notes, got %d", len(seenIds))
+			}
+		})
+
+		t.Run("picks the same notes from the same seed", func(t *testing.T) {
+			pick := func() []string {
+				noteStore.SetRandom(rand.New(rand.NewSource(42)))
+				ids := make([]string, 0)
+				for i := 0; i < 5; i++ {
+					for _, note := range noteStore.RandomNotes(3, true) {
+						ids = append(ids, note.NodeId)
+					}
+				}
+				return ids
+			}
+
+			if first, second := pick(), pick(); !reflect.DeepEqual(first, second) {
+				t.Errorf("expected %v, got %v", first, second)
+			}
+		})
+
+		t.Run("samples the notes left after removals", func(t *testing.T) {
+			noteStore := NewNoteStore(selfNote)
+			for _, note := range createNotes(5) {
+				noteStore.OnNote(note)
+			}
+			noteStore.OnCommand(p2p.Command{Type: p2p.Command_KICK, Target: "node1", Revision: 1})
+			noteStore.OnCommand(p2p.Command{Type: p2p.Command_KICK, Target: "node4", Revision: 2})
+
+			randNotes := noteStore.RandomNotes(10, true)
+			ids := make([]string, 0)
+			for _, note := range randNotes {
+				ids = append(ids, note.NodeId)
+			}
+			sort.Strings(ids)
+			if expected := []string{"node0", "node2", "node3"}; !reflect.DeepEqual(ids, expected) {
+				t.Errorf("expected %v, got %v", expected, ids)
+			}
+		})
 	})
 
 	t.Run("ActiveNoteNumbers", func(t *testing.T) {
@@ -125,6 +180,76 @@ func TestNoteStore(t *testing.T) {
 		})
 	})
 
+	t.Run("scheduled notes", func(t *testing.T) {
+		noteStore := NewNoteStore(createNote("self", 0, 63, true))
+		now := time.Now()
+		scheduled := func(revision uint32, note uint32, effective time.Time) p2p.NoteData {
+			data := *createNote("n1", revision, note, false)
+			data.Effective = effective.UnixNano()
+			return data
+		}
+
+		t.Run("take effect at their effective time", func(t *testing.T) {
+			noteStore.OnNote(*createNote("n1", 1, 60, false))
+			noteStore.OnNote(scheduled(2, 62, now.Add(time.Hour)))
+
+			if notes := noteStore.ActiveNoteNumbersAt(now); !reflect.DeepEqual(notes, []int{60}) {
+				t.Errorf("expected the previous note before the change, got %v", notes)
+			}
+			if notes := noteStore.ActiveNoteNumbersAt(now.Add(time.Hour)); !reflect.DeepEqual(notes, []int{62}) {
+				t.Errorf("expected the scheduled note after the change, got %v", notes)
+			}
+			if notes := noteStore.ActiveNoteNumbers(); !reflect.DeepEqual(notes, []int{60}) {
+				t.Errorf("expected the previous note now, got %v", notes)
+			}
+		})
+
+		t.Run("keep every pending change", func(t *testing.T) {
+			noteStore.OnNote(scheduled(3, 64, now.Add(2*time.Hour)))
+
+			if notes := noteStore.ActiveNoteNumbersAt(now.Add(90 * time.Minute)); !reflect.DeepEqual(notes, []int{62}) {
+				t.Errorf("expected the first scheduled note between changes, got %v", notes)
+			}
+			if notes := noteStore.ActiveNoteNumbersAt(now.Add(2 * time.Hour)); !reflect.DeepEqual(notes, []int{64}) {
+				t.Errorf("expected the last scheduled note, got %v", notes)
+			}
+		})
+
+		t.Run("drop changes that are no longer in effect", func(t *testing.T) {
+			noteStore.OnNote(scheduled(4, 65, now.Add(-time.Minute)))
+			noteStore.OnNote(*createNote("n1", 5, 67, false))
+
+			note := noteStore.notes["n1"]
+			if len(note.earlier) != 0 {
+				t.Errorf("kept %d revisions that are no longer in effect", len(note.earlier))
+			}
+		})
+
+		t.Run("leave out nodes until their first note takes effect", func(t *testing.T) {
+			noteStore.OnNote(scheduled(1, 70, now.Add(time.Hour)))
+			other := scheduled(1, 70, now.Add(time.Hour))
+			other.NodeId = "n2"
+			noteStore.OnNote(other)
+
+			if notes := noteStore.ActiveNoteNumbersAt(now); !reflect.DeepEqual(notes, []int{67}) {
+				t.Errorf("expected only notes in effect, got %v", notes)
+			}
+		})
+
+		t.Run("keep a bounded number of pending changes", func(t *testing.T) {
+			for revision := uint32(6); revision < 30; revision++ {
+				noteStore.OnNote(scheduled(revision, 60+revision, now.Add(time.Hour+time.Duration(revision)*time.Minute)))
+			}
+
+			if earlier := len(noteStore.notes["n1"].earlier); earlier > maxPendingStates {
+				t.Errorf("kept %d pending revisions", earlier)
+			}
+			if notes := noteStore.ActiveNoteNumbersAt(now); !reflect.DeepEqual(notes, []int{67}) {
+				t.Errorf("expected the note in effect to be kept, got %v", notes)
+			}
+		})
+	})
+
 	t.Run("ClearDeadNotes", func(t *testing.T) {
 		noteStore := NewNoteStore(selfNote)
 
@@ -156,6 +281,126 @@ func TestNoteStore(t *testing.T) {
 	})
 }
 
+func TestNoteStoreAdmission(t *testing.T) {
+	selfNote := createNote("self", 0, 63, false)
+
+	t.Run("open stores accept notes from any node", func(t *testing.T) {
+		noteStore := NewNoteStore(selfNote)
+		noteStore.OnNote(*createNote("n1", 1, 32, false))
+
+		if noteStore.RequiresAdmission() || noteStore.ActiveNotes() != 2 {
+			t.Error("open store rejected a note")
+		}
+	})
+
+	t.Run("private stores only accept notes from admitted nodes", func(t *testing.T) {
+		noteStore := NewNoteStore(selfNote)
+		noteStore.RequireAdmission("owner")
+
+		noteStore.OnNote(*createNote("owner", 1, 30, false))
+		noteStore.OnNote(*createNote("n1", 1, 32, false))
+		noteStore.Admit("n2", time.Now().Add(time.Hour), &p2p.Admission{NodeId: "n2"})
+		noteStore.OnNote(*createNote("n2", 1, 33, false))
+
+		noteNumbers := noteStore.ActiveNoteNumbers()
+		expectation := []int{30, 33, 63}
+		if !reflect.DeepEqual(noteNumbers, expectation) {
+			t.Errorf("Expected %v notes, got %v", expectation, noteNumbers)
+		}
+
+		if proof, ok := noteStore.Admission("n2"); !ok || proof.NodeId != "n2" {
+			t.Error("did not keep proof of admission")
+		}
+		if _, ok := noteStore.Admission("owner"); ok {
+			t.Error("owner should not need a proof of admission")
+		}
+	})
+
+	t.Run("expired admissions are rejected", func(t *testing.T) {
+		noteStore := NewNoteStore(selfNote)
+		noteStore.RequireAdmission("owner")
+
+		noteStore.Admit("n1", time.Now().Add(-time.Minute), &p2p.Admission{NodeId: "n1"})
+		noteStore.OnNote(*createNote("n1", 1, 32, false))
+
+		if noteStore.Admitted("n1") || noteStore.ActiveNotes() != 1 {
+			t.Error("accepted a note from an expired admission")
+		}
+	})
+}
+
+func TestNoteStoreCommands(t *testing.T) {
+	selfNote := createNote("self", 0, 63, false)
+
+	t.Run("newer commands supersede older commands of the same kind", func(t *testing.T) {
+		noteStore := NewNoteStore(selfNote)
+
+		noteStore.OnCommand(p2p.Command{Type: p2p.Command_SET_TEMPO, Tempo: 90, Revision: 2})
+		noteStore.OnCommand(p2p.Command{Type: p2p.Command_SET_TEMPO, Tempo: 80, Revision: 1})
+		noteStore.OnCommand(p2p.Command{Type: p2p.Command_SET_SCALE, Scale: "minor", Revision: 3})
+
+		controls := noteStore.Controls()
+		if controls.Tempo != 90 || controls.Scale != "minor" {
+			t.Errorf("unexpected controls %v", controls)
+		}
+		if len(noteStore.Commands()) != 2 || noteStore.NextCommandRevision() != 4 {
+			t.Error("did not keep the latest command of each kind")
+		}
+	})
+
+	t.Run("rejects command revisions that would pin a command", func(t *testing.T) {
+		noteStore := NewNoteStore(selfNote)
+
+		if noteStore.OnCommand(p2p.Command{Type: p2p.Command_MUTE_ALL, Revision: math.MaxUint32}) {
+			t.Error("accepted the last revision")
+		}
+		if noteStore.OnCommand(p2p.Command{Type: p2p.Command_MUTE_ALL, Revision: maxRevisionStep + 1}) {
+			t.Error("accepted a revision far past those seen")
+		}
+		if noteStore.Controls().Muted || noteStore.NextCommandRevision() != 1 {
+			t.Error("expected the session to stay unmuted")
+		}
+	})
+
+	t.Run("mute all silences every note until unmuted", func(t *testing.T) {
+		noteStore := NewNoteStore(selfNote)
+		noteStore.OnNote(*createNote("n1", 1, 32, false))
+
+		noteStore.OnCommand(p2p.Command{Type: p2p.Command_MUTE_ALL, Revision: 1})
+		if len(noteStore.ActiveNoteNumbers()) != 0 {
+			t.Error("notes still active after mute all")
+		}
+
+		noteStore.OnCommand(p2p.Command{Type: p2p.Command_UNMUTE_ALL, Revision: 2})
+		expectation := []int{32, 63}
+		if !reflect.DeepEqual(noteStore.ActiveNoteNumbers(), expectation) {
+			t.Errorf("Expected %v notes after unmute, got %v", expectation, noteStore.ActiveNoteNumbers())
+		}
+	})
+
+	t.Run("kick removes a node and ignores its notes", func(t *testing.T) {
+		noteStore := NewNoteStore(selfNote)
+		noteStore.OnNote(*createNote("n1", 1, 32, false))
+
+		noteStore.OnCommand(p2p.Command{Type: p2p.Command_KICK, Target: "n1", Revision: 1})
+		noteStore.OnNote(*createNote("n1", 2, 32, false))
+
+		if noteStore.ActiveNotes() != 1 {
+			t.Error("kicked note is still stored")
+		}
+	})
+
+	t.Run("kick never removes self", func(t *testing.T) {
+		noteStore := NewNoteStore(selfNote)
+
+		noteStore.OnCommand(p2p.Command{Type: p2p.Command_KICK, Target: "self", Revision: 1})
+
+		if _, ok := noteStore.LastRevision("self"); !ok {
+			t.Error("removed own note")
+		}
+	})
+}
+
 func createNote(node string, revision uint32, note uint32, muted bool) *p2p.NoteData {
 	return &p2p.NoteData{
 		Address:  "/ip4/127.0.0.1/tcp/1000",
@@ -173,3 +418,20 @@ func createNotes(count int) []p2p.NoteData {
 	}
 	return notes
 }
+
+func BenchmarkRandomNotes(b *testing.B) {
+	noteStore := NewNoteStore(createNote("self", 0, 60, false))
+	for _, note := range createNotes(10000) {
+		noteStore.OnNote(note)
+	}
+	noteStore.SetRandom(rand.New(rand.NewSource(1)))
+
+	for _, count := range []int{2, maxNotesPerNotification} {
+		b.Run(fmt.Sprintf("%d of 10000", count), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				noteStore.RandomNotes(count, true)
+			}
+		})
+	}
+}
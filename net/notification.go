@@ -3,79 +3,240 @@ package loopnet
 import (
 	"bufio"
 	"context"
+	"fmt"
+	"log/slog"
+	"strings"
 	"sync"
-	// "fmt"
-	"log"
+	"time"
 
 	p2p "github.com/acruikshank/loopnet/pb"
 	inet "github.com/libp2p/go-libp2p-net"
 	peer "github.com/libp2p/go-libp2p-peer"
 	ps "github.com/libp2p/go-libp2p-peerstore"
+	protocol "github.com/libp2p/go-libp2p-protocol"
 	ma "github.com/multiformats/go-multiaddr"
 	protobufCodec "github.com/multiformats/go-multicodec/protobuf"
 )
 
 // pattern: /protocol-name/request-or-response-message/version
-const notificationRequest = "/loopnet/notify/0.0.1"
+// the session id is appended to get the protocol id for a session
+const notificationRequest = "/loopnet/notify/0.0.2"
+
+// notifications of nodes that read notes without their gossip path, sent
+// as NoteData rather than envelopes until those nodes are retired
+const legacyNotificationRequest = "/loopnet/notify/0.0.1"
 const maxNotesPerNotification = 10
 
 // NotificationProtocol type
 type NotificationProtocol struct {
-	node       *Node      // local host
-	NoteStore  *NoteStore // stores all notes
+	node       *Node           // local host
+	session    string          // session this protocol gossips notes for
+	protocol   protocol.ID     // stream protocol id for the session
+	legacy     protocol.ID     // stream protocol id for the session on nodes reading notes only
+	owner      string          // id of the node issuing invitations, empty for open sessions
+	NoteStore  Store           // stores all notes
+	Parameters *ParameterStore // parameters shared by the session
+	Metrics    *GossipMetrics  // notification counts
+	rumors     *rumors         // recently changed notes sent before the random sample
+	gossip     *adaptiveGossip // interval and fanout of gossip rounds
+	divergence *divergence     // state hashes of peers compared with this node's
+	logger     *slog.Logger
 	streams    map[string]inet.Stream
 	streamsMux *sync.Mutex
+
+	trace    *PropagationTrace // records note arrivals, nil if not tracing
+	traceMux *sync.Mutex
+
+	credential    *p2p.Credential // conductor credential presented with commands
+	credentialMux *sync.Mutex
+
+	localMux *sync.Mutex // serializes changes to the local note, each taking the next revision
 }
 
-func NewNotificationProtocol(node *Node) *NotificationProtocol {
-	n := &NotificationProtocol{node: node}
-	node.SetStreamHandler(notificationRequest, n.onNotification)
+// NewNotificationProtocol creates the notification protocol for a session.
+// owner: id of the node admitting others to a private session, or empty
+// self: the local node's initial note in that session
+func NewNotificationProtocol(node *Node, session string, owner string, self *p2p.NoteData) *NotificationProtocol {
+	n := &NotificationProtocol{
+		node:       node,
+		session:    session,
+		protocol:   sessionProtocol(session),
+		legacy:     protocol.ID(legacyNotificationRequest + "/" + session),
+		owner:      owner,
+		NoteStore:  node.newStore(self),
+		Parameters: NewParameterStore(),
+		Metrics:    newGossipMetrics(),
+		rumors:     newRumors(DefaultRumorSends),
+		gossip:     newAdaptiveGossip(DefaultGossipBounds),
+		divergence: newDivergence(DefaultDivergenceWindow),
+		logger:     node.logger(logGossip).With("session", session),
+	}
+	n.NoteStore.SetLogger(node.logger(logStore).With("session", session))
+	if owner != "" {
+		n.NoteStore.RequireAdmission(owner)
+	}
+	if node.Clock != nil {
+		n.NoteStore.SetClock(node.Clock)
+	}
+	n.rumors.heard(self.NodeId, self.Revision)
 	n.streams = make(map[string]inet.Stream)
 	n.streamsMux = &sync.Mutex{}
+	n.credentialMux = &sync.Mutex{}
+	n.traceMux = &sync.Mutex{}
+	n.localMux = &sync.Mutex{}
+	node.SetStreamHandler(n.protocol, n.onNotification)
+	node.SetStreamHandler(n.legacy, n.onNotification)
+	node.SetStreamHandler(reconcileProtocol(session), n.onReconcile)
 	return n
 }
 
+// sessionProtocol returns the notification protocol id for a session
+func sessionProtocol(session string) protocol.ID {
+	return protocol.ID(notificationRequest + "/" + session)
+}
+
+// Session returns the id of the session this protocol gossips for.
+func (np *NotificationProtocol) Session() string {
+	return np.session
+}
+
+// stop receiving notifications for the session
+func (np *NotificationProtocol) close() {
+	np.node.RemoveStreamHandler(np.protocol)
+	np.node.RemoveStreamHandler(np.legacy)
+	np.node.RemoveStreamHandler(reconcileProtocol(np.session))
+}
+
 // remote peer requests handler
 func (np *NotificationProtocol) onNotification(s inet.Stream) {
-	//log.Printf("%s: Received notification from %s.", np.node.ID(), s.Conn().RemotePeer())
+	from := peer.IDB58Encode(s.Conn().RemotePeer())
+	logger := np.logger.With("peer", from)
+
 	// get request data
 	notification := &p2p.Message{}
 	decoder := protobufCodec.Multicodec(nil).Decoder(bufio.NewReader(s))
 	err := decoder.Decode(notification)
 	if err != nil {
-		log.Println(err)
+		logger.Warn("failed to decode notification", "err", err)
 		return
 	}
+	np.Metrics.NotificationsReceived.Inc()
+	logger.Debug("received notification", "notes", len(notification.Notes)+len(notification.Envelopes),
+		"admissions", len(notification.Admissions), "commands", len(notification.Commands),
+		"parameters", len(notification.Parameters))
+
+	np.onMessage(notification, from)
+}
+
+// apply a notification relayed by a peer
+func (np *NotificationProtocol) onMessage(notification *p2p.Message, from string) {
+	if np.owner != "" {
+		np.onAdmissions(notification.Admissions)
+		np.onCommands(notification.Commands)
+	}
+	np.onParameters(notification.Parameters)
 
+	// notes from nodes that don't relay envelopes have an unknown path
+	envelopes := notification.Envelopes
 	for _, note := range notification.Notes {
-		valid := np.node.authenticateNote(note)
+		envelopes = append(envelopes, &p2p.NoteEnvelope{Note: note})
+	}
+
+	// verify notes in parallel with other streams, keeping the order of each author's notes
+	done := &sync.WaitGroup{}
+	for _, envelope := range envelopes {
+		if envelope.Note != nil {
+			np.node.verifier.verify(np, envelope, from, done)
+		}
+	}
+	done.Wait()
+
+	// compare states once the notes the peer sent are stored
+	np.onStateHash(notification.StateHash, from)
+}
+
+// authenticate a note relayed by a peer and store it
+func (np *NotificationProtocol) onEnvelope(envelope *p2p.NoteEnvelope, from string) {
+	logger := np.logger.With("peer", from)
+	note := envelope.Note
+
+	// the store would ignore the note anyway, don't spend time verifying it
+	if np.NoteStore.Stale(note) {
+		np.Metrics.NotesSkipped.Inc()
+		return
+	}
+
+	valid := np.node.authenticateNote(note)
+
+	if !valid {
+		logger.Warn("failed to authenticate note", "author", note.NodeId)
+		np.Metrics.NotesRejected.Inc()
+		return
+	}
+
+	// the session is signed, so a note can't be replayed into another session
+	if note.Session != np.session {
+		logger.Warn("rejecting note for session not joined", "author", note.NodeId, "noteSession", note.Session)
+		np.Metrics.NotesRejected.Inc()
+		return
+	}
+
+	// a note that never takes effect would keep every revision after it pending
+	if note.Effective > np.now().Add(maxScheduleAhead).UnixNano() {
+		logger.Warn("rejecting note scheduled too far ahead", "author", note.NodeId, "effective", time.Unix(0, note.Effective))
+		np.Metrics.NotesRejected.Inc()
+		return
+	}
+	np.Metrics.NotesAuthenticated.Inc()
+
+	stored, added := np.NoteStore.OnEnvelope(*envelope)
+	if stored {
+		np.rumors.heard(note.NodeId, note.Revision)
+		np.onPropagation(envelope, from)
+	}
+	if !added {
+		return
+	}
+
+	nodeId, err := peer.IDB58Decode(note.NodeId)
+	if err != nil {
+		logger.Warn("error converting id", "err", err, "author", note.NodeId)
+		return
+	}
+
+	address, err := ma.NewMultiaddr(note.Address)
+	if err != nil {
+		logger.Warn("error creating address", "err", err, "author", note.NodeId, "address", note.Address)
+		return
+	}
+
+	logger.Debug("discovered node", "author", note.NodeId, "address", note.Address)
+	np.node.Peerstore().AddAddrs(nodeId, []ma.Multiaddr{address}, ps.PermanentAddrTTL)
+}
 
-		if !valid {
-			log.Println("Failed to authenticate message")
+// admit the nodes presenting valid invitations to a private session
+func (np *NotificationProtocol) onAdmissions(admissions []*p2p.Admission) {
+	for _, admission := range admissions {
+		invitation := admission.Invitation
+		if invitation == nil || invitation.IssuerId != np.owner || invitation.Session != np.session {
+			np.logger.Warn("rejecting admission not issued by the session owner", "admitted", admission.NodeId)
 			continue
 		}
 
-		if np.NoteStore.OnNote(*note) {
-			nodeId, err := peer.IDB58Decode(note.NodeId)
-			if err != nil {
-				log.Println("Error converting id", err)
-				continue
-			}
-
-			address, err := ma.NewMultiaddr(note.Address)
-			if err != nil {
-				log.Println("Error creating address", err)
-				continue
-			}
-
-			//log.Printf("%s: adding to notes: %s.", np.node.ID(), nodeId)
-			np.node.Peerstore().AddAddrs(nodeId, []ma.Multiaddr{address}, ps.PermanentAddrTTL)
+		err := np.node.validateInvitation(invitation, admission.NodeId)
+		if err != nil {
+			np.logger.Warn("rejecting admission", "err", err, "admitted", admission.NodeId)
+			continue
 		}
+
+		np.NoteStore.Admit(admission.NodeId, time.Unix(invitation.Expires, 0), admission)
 	}
 }
 
+// Notify sends a notification to as many random peers as the current
+// gossip fanout.
 func (np *NotificationProtocol) Notify() bool {
-	destinations := np.NoteStore.RandomNotes(2, true)
+	destinations := np.NoteStore.RandomNotes(np.GossipStatus().Fanout, true)
 	if len(destinations) < 1 {
 		// no nodes to notify
 		return true
@@ -84,35 +245,143 @@ func (np *NotificationProtocol) Notify() bool {
 	for _, destination := range destinations {
 		nodeId, err := peer.IDB58Decode(destination.NodeId)
 		if err != nil {
-			log.Println("Error converting id", err)
+			np.logger.Warn("error converting id", "err", err, "destination", destination.NodeId)
 			return false
 		}
 
 		ok := np.sendNotification(nodeId)
 		if !ok {
-			log.Println("Failed to send")
+			np.logger.Warn("failed to send notification", "peer", destination.NodeId)
 		}
 	}
 	return true
 }
 
+// ConnectToHost introduces this node to another node in the same session.
 func (np *NotificationProtocol) ConnectToHost(node *Node) {
 	np.node.Peerstore().AddAddrs(node.ID(), node.Addrs(), ps.PermanentAddrTTL)
 	np.sendNotification(node.ID())
 }
 
-func (np *NotificationProtocol) sendNotification(nodeId peer.ID) bool {
-	//log.Printf("%s: Sending notification to %s.", np.node.ID(), nodeId)
-	notes := np.NoteStore.RandomNotes(maxNotesPerNotification, false)
-	req := &p2p.Message{Notes: notes}
+// ConnectToAddress introduces this node to the node at a multiaddr
+// ending in /ipfs/<node id>.
+func (np *NotificationProtocol) ConnectToAddress(address string) error {
+	i := strings.LastIndex(address, "/ipfs/")
+	if i < 0 {
+		return fmt.Errorf("address %s has no node id", address)
+	}
+
+	nodeId, err := peer.IDB58Decode(address[i+len("/ipfs/"):])
+	if err != nil {
+		return err
+	}
 
+	addr, err := ma.NewMultiaddr(address[:i])
+	if err != nil {
+		return err
+	}
+
+	np.node.Peerstore().AddAddrs(nodeId, []ma.Multiaddr{addr}, ps.PermanentAddrTTL)
+	if !np.sendNotification(nodeId) {
+		return fmt.Errorf("failed to notify %s", address)
+	}
+	return nil
+}
+
+func (np *NotificationProtocol) sendNotification(nodeId peer.ID) bool {
 	s, err := np.OpenStream(nodeId)
 	if err != nil {
-		log.Println("Error opening stream:", err)
+		np.logger.Warn("error opening stream", "err", err, "peer", peer.IDB58Encode(nodeId))
+		np.Metrics.NotificationsFailed.Inc()
+		return false
+	}
+
+	envelopes := np.notificationEnvelopes()
+	notes := make([]*p2p.NoteData, 0, len(envelopes))
+	for _, envelope := range envelopes {
+		notes = append(notes, envelope.Note)
+	}
+
+	req := &p2p.Message{Envelopes: envelopes}
+	if s.Protocol() == np.legacy {
+		req = &p2p.Message{Notes: notes}
+	}
+	if np.owner != "" {
+		req.Admissions = np.admissions(notes)
+		req.Commands = np.NoteStore.Commands()
+	}
+	req.Parameters = np.Parameters.Proposals()
+	req.StateHash = np.stateHash()
+
+	if !np.node.sendProtoMessage(req, s) {
+		np.Metrics.NotificationsFailed.Inc()
 		return false
 	}
+	np.rumors.sent(envelopes)
+	np.Metrics.NotificationsSent.Inc()
+	np.logger.Debug("sent notification", "peer", peer.IDB58Encode(nodeId), "notes", len(envelopes))
+	return true
+}
+
+// the notes of a notification: every hot note that fits, then a random
+// sample of the store filling the remaining slots
+func (np *NotificationProtocol) notificationEnvelopes() []*p2p.NoteEnvelope {
+	envelopes := make([]*p2p.NoteEnvelope, 0, maxNotesPerNotification)
+	included := make(map[string]bool)
+	for _, nodeId := range np.rumors.pick(maxNotesPerNotification) {
+		envelope, found := np.NoteStore.Envelope(nodeId)
+		if !found {
+			np.rumors.forget(nodeId)
+			continue
+		}
+		envelopes = append(envelopes, envelope)
+		included[nodeId] = true
+	}
+	if len(envelopes) == maxNotesPerNotification {
+		return envelopes
+	}
+
+	// the sample holds at most len(included) hot notes, leaving enough others
+	for _, envelope := range np.NoteStore.RandomEnvelopes(maxNotesPerNotification, false) {
+		if len(envelopes) == maxNotesPerNotification {
+			break
+		}
+		if !included[envelope.Note.NodeId] {
+			envelopes = append(envelopes, envelope)
+		}
+	}
+	return envelopes
+}
+
+// SetRumorSends sets the number of notifications a changed note is sent
+// in before it is only sent when picked at random. 0 sends random samples
+// only.
+func (np *NotificationProtocol) SetRumorSends(sends int) {
+	np.rumors.setSends(sends)
+}
+
+// GossipCounts returns the number of notifications sent and received.
+func (np *NotificationProtocol) GossipCounts() (sent uint64, received uint64) {
+	return np.Metrics.NotificationsSent.Value(), np.Metrics.NotificationsReceived.Value()
+}
 
-	return np.node.sendProtoMessage(req, s)
+// collect the admissions of this node and the authors of notes so the
+// receiver can admit them
+func (np *NotificationProtocol) admissions(notes []*p2p.NoteData) []*p2p.Admission {
+	selfId := peer.IDB58Encode(np.node.ID())
+	admissions := make([]*p2p.Admission, 0)
+	if admission, ok := np.NoteStore.Admission(selfId); ok {
+		admissions = append(admissions, admission)
+	}
+	for _, note := range notes {
+		if note.NodeId == selfId {
+			continue
+		}
+		if admission, ok := np.NoteStore.Admission(note.NodeId); ok {
+			admissions = append(admissions, admission)
+		}
+	}
+	return admissions
 }
 
 func (np *NotificationProtocol) OpenStream(nodeId peer.ID) (inet.Stream, error) {
@@ -124,10 +393,13 @@ func (np *NotificationProtocol) OpenStream(nodeId peer.ID) (inet.Stream, error)
 	//   return s, nil
 	// }
 	//
-	stream, err := np.node.NewStream(context.Background(), nodeId, notificationRequest)
+	start := time.Now()
+	// peers that don't speak the current protocol yet get the legacy one
+	stream, err := np.node.NewStream(context.Background(), nodeId, np.protocol, np.legacy)
 	if err != nil {
 		return nil, err
 	}
+	np.Metrics.StreamOpen.Observe(time.Since(start))
 	// np.streams[nodeId.String()] = stream
 
 	return stream, nil
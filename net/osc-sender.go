@@ -0,0 +1,165 @@
+package loopnet
+
+import (
+	"log/slog"
+	"net"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// velocity of the notes played by the arpeggiator
+const oscVelocity = 100
+
+// time between checks for changes to the swarm state in Watch
+const oscStateInterval = 50 * time.Millisecond
+
+// OSCAddresses are the address patterns OSC messages are sent to.
+type OSCAddresses struct {
+	NoteOn  string // note number and velocity of each step played
+	NoteOff string // note number of the previous step
+	Peers   string // number of other nodes in the session
+	Notes   string // active note numbers of the session, lowest first
+}
+
+// DefaultOSCAddresses are the addresses used unless configured otherwise.
+var DefaultOSCAddresses = OSCAddresses{
+	NoteOn:  "/loopnet/noteon",
+	NoteOff: "/loopnet/noteoff",
+	Peers:   "/loopnet/peers",
+	Notes:   "/loopnet/notes",
+}
+
+// OSCSender sends arpeggiator events and the swarm state to an OSC
+// receiver such as SuperCollider, Max or VCV Rack over UDP.
+type OSCSender struct {
+	conn      *net.UDPConn
+	addresses OSCAddresses
+	playing   int   // note of the step sounding, -1 if none
+	peers     int   // last peer count sent
+	notes     []int // last note numbers sent, nil until sent
+	logger    *slog.Logger
+	oscMux    *sync.Mutex
+}
+
+// NewOSCSender creates a sender to an OSC receiver.
+// target: host:port the receiver listens on
+// addresses: address patterns to send each message to
+func NewOSCSender(target string, addresses OSCAddresses) (*OSCSender, error) {
+	addr, err := net.ResolveUDPAddr("udp", target)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.DialUDP("udp", nil, addr)
+	if err != nil {
+		return nil, err
+	}
+
+	return &OSCSender{
+		conn:      conn,
+		addresses: addresses,
+		playing:   -1,
+		peers:     -1,
+		logger:    NewLogging(nil).Logger(logOSC),
+		oscMux:    &sync.Mutex{},
+	}, nil
+}
+
+// SetLogging logs with the levels and handler of a node's logging rather
+// than the defaults.
+func (o *OSCSender) SetLogging(logging *Logging) {
+	o.oscMux.Lock()
+	defer o.oscMux.Unlock()
+
+	o.logger = logging.Logger(logOSC)
+}
+
+// OnStep sends a note off for the previous step and a note on for the step,
+// unless it is a rest. Pass it to NewArpeggiator to send every step played.
+func (o *OSCSender) OnStep(step Step) {
+	o.oscMux.Lock()
+	defer o.oscMux.Unlock()
+
+	o.noteOff()
+	if step.Rest {
+		return
+	}
+	o.send(o.addresses.NoteOn, int32(step.Note), int32(oscVelocity))
+	o.playing = step.Note
+}
+
+// NotesOff sends a note off for the step sounding, e.g. when the
+// arpeggiator stops.
+func (o *OSCSender) NotesOff() {
+	o.oscMux.Lock()
+	defer o.oscMux.Unlock()
+
+	o.noteOff()
+}
+
+// OnState sends the number of peers and the active notes of a session
+// if they changed since they were last sent.
+func (o *OSCSender) OnState(peers int, notes []int) {
+	o.oscMux.Lock()
+	defer o.oscMux.Unlock()
+
+	if peers != o.peers {
+		o.send(o.addresses.Peers, int32(peers))
+		o.peers = peers
+	}
+
+	if o.notes == nil || !reflect.DeepEqual(notes, o.notes) {
+		args := make([]interface{}, 0, len(notes))
+		for _, note := range notes {
+			args = append(args, int32(note))
+		}
+		o.send(o.addresses.Notes, args...)
+		o.notes = append(make([]int, 0, len(notes)), notes...)
+	}
+}
+
+// Watch sends the state of a note store whenever it changes, until stop is
+// closed. The store holds the local node's note too, so the peers sent are
+// the other nodes of the session.
+func (o *OSCSender) Watch(store Store, stop <-chan struct{}) {
+	for {
+		peers := store.ActiveNotes() - 1
+		if peers < 0 {
+			peers = 0
+		}
+		o.OnState(peers, store.ActiveNoteNumbers())
+
+		select {
+		case <-stop:
+			return
+		case <-time.After(oscStateInterval):
+		}
+	}
+}
+
+// Close stops the sounding note and closes the connection.
+func (o *OSCSender) Close() error {
+	o.NotesOff()
+	return o.conn.Close()
+}
+
+// callers must hold oscMux
+func (o *OSCSender) noteOff() {
+	if o.playing >= 0 {
+		o.send(o.addresses.NoteOff, int32(o.playing))
+		o.playing = -1
+	}
+}
+
+// callers must hold oscMux
+func (o *OSCSender) send(address string, args ...interface{}) {
+	data, err := EncodeOSC(OSCMessage{Address: address, Arguments: args})
+	if err != nil {
+		o.logger.Error("failed to encode OSC message", "err", err, "address", address)
+		return
+	}
+
+	if _, err := o.conn.Write(data); err != nil {
+		o.logger.Warn("failed to send OSC message", "err", err, "address", address)
+	}
+}
@@ -0,0 +1,112 @@
+package loopnet
+
+import (
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestOSCSender(t *testing.T) {
+	listener, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	addresses := OSCAddresses{NoteOn: "/synth/on", NoteOff: "/synth/off", Peers: "/swarm/peers", Notes: "/swarm/notes"}
+	sender, err := NewOSCSender(listener.LocalAddr().String(), addresses)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sender.Close()
+
+	receive := func() OSCMessage {
+		buf := make([]byte, 1024)
+		listener.SetReadDeadline(time.Now().Add(time.Second))
+		n, err := listener.Read(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		m, err := DecodeOSC(buf[:n])
+		if err != nil {
+			t.Fatal(err)
+		}
+		return m
+	}
+
+	t.Run("OnStep", func(t *testing.T) {
+		t.Run("sends note on for the first step", func(t *testing.T) {
+			sender.OnStep(Step{Index: 0, Note: 60})
+
+			m := receive()
+			expected := OSCMessage{Address: "/synth/on", Arguments: []interface{}{int32(60), int32(oscVelocity)}}
+			if !reflect.DeepEqual(m, expected) {
+				t.Errorf("expected %v, received %v", expected, m)
+			}
+		})
+
+		t.Run("sends note off for the previous step", func(t *testing.T) {
+			sender.OnStep(Step{Index: 1, Note: 64})
+
+			off, on := receive(), receive()
+			if off.Address != "/synth/off" || off.Arguments[0] != int32(60) {
+				t.Errorf("unexpected note off %v", off)
+			}
+			if on.Address != "/synth/on" || on.Arguments[0] != int32(64) {
+				t.Errorf("unexpected note on %v", on)
+			}
+		})
+
+		t.Run("sends only a note off for a rest", func(t *testing.T) {
+			sender.OnStep(Step{Rest: true})
+
+			off := receive()
+			if off.Address != "/synth/off" || off.Arguments[0] != int32(64) {
+				t.Errorf("unexpected note off %v", off)
+			}
+		})
+	})
+
+	t.Run("OnState", func(t *testing.T) {
+		t.Run("sends peers and notes", func(t *testing.T) {
+			sender.OnState(3, []int{60, 62, 67})
+
+			peers, notes := receive(), receive()
+			if peers.Address != "/swarm/peers" || peers.Arguments[0] != int32(3) {
+				t.Errorf("unexpected peers %v", peers)
+			}
+			expected := []interface{}{int32(60), int32(62), int32(67)}
+			if notes.Address != "/swarm/notes" || !reflect.DeepEqual(notes.Arguments, expected) {
+				t.Errorf("unexpected notes %v", notes)
+			}
+		})
+
+		t.Run("only sends changes", func(t *testing.T) {
+			sender.OnState(3, []int{60, 62, 67})
+			sender.OnState(3, []int{60, 62})
+
+			notes := receive()
+			if notes.Address != "/swarm/notes" || len(notes.Arguments) != 2 {
+				t.Errorf("expected only the changed notes, received %v", notes)
+			}
+		})
+	})
+
+	t.Run("Watch", func(t *testing.T) {
+		t.Run("counts the other nodes as peers", func(t *testing.T) {
+			store := NewNoteStore(createNote("self", 0, 60, false))
+			stop := make(chan struct{})
+			defer close(stop)
+			go sender.Watch(store, stop)
+
+			peers, notes := receive(), receive()
+			if peers.Address != "/swarm/peers" || peers.Arguments[0] != int32(0) {
+				t.Errorf("expected a node alone to have no peers, received %v", peers)
+			}
+			if notes.Address != "/swarm/notes" || !reflect.DeepEqual(notes.Arguments, []interface{}{int32(60)}) {
+				t.Errorf("unexpected notes %v", notes)
+			}
+		})
+	})
+}
@@ -0,0 +1,162 @@
+package loopnet
+
+import (
+	"fmt"
+	"log/slog"
+	"math"
+	"net"
+	"strings"
+)
+
+// addresses of the messages the OSC server handles
+const (
+	oscPitchAddress = "/loopnet/pitch" // i: midi note number
+	oscMuteAddress  = "/loopnet/mute"  // i: 1 to mute, 0 to unmute
+	oscTempoAddress = "/loopnet/tempo" // f: proposed session tempo in bpm
+)
+
+// OSCServer lets OSC controllers change the local node's note and propose
+// a tempo. Changes are signed with a new revision and gossiped right away.
+type OSCServer struct {
+	conn    *net.UDPConn
+	np      *NotificationProtocol
+	allowed []*net.IPNet // source networks messages are accepted from
+	logger  *slog.Logger
+}
+
+// NewOSCServer starts handling OSC messages for a session.
+// listen: host:port to listen on
+// allow: IP addresses or CIDR networks messages are accepted from;
+// only loopback addresses are accepted if empty
+func NewOSCServer(np *NotificationProtocol, listen string, allow []string) (*OSCServer, error) {
+	if len(allow) == 0 {
+		allow = []string{"127.0.0.0/8", "::1/128"}
+	}
+
+	allowed := make([]*net.IPNet, 0, len(allow))
+	for _, entry := range allow {
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid OSC source address %q", entry)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			allowed = append(allowed, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, err
+		}
+		allowed = append(allowed, network)
+	}
+
+	addr, err := net.ResolveUDPAddr("udp", listen)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.ListenUDP("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	o := &OSCServer{conn: conn, np: np, allowed: allowed, logger: np.node.logger(logOSC)}
+	go o.serve()
+	return o, nil
+}
+
+// Addr returns the address the server listens on.
+func (o *OSCServer) Addr() net.Addr {
+	return o.conn.LocalAddr()
+}
+
+// Close stops handling messages.
+func (o *OSCServer) Close() error {
+	return o.conn.Close()
+}
+
+func (o *OSCServer) serve() {
+	buf := make([]byte, 1024)
+	for {
+		n, from, err := o.conn.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+
+		if !o.allows(from.IP) {
+			o.logger.Warn("rejecting OSC message", "from", from)
+			continue
+		}
+
+		m, err := DecodeOSC(buf[:n])
+		if err != nil {
+			o.logger.Warn("failed to decode OSC message", "err", err, "from", from)
+			continue
+		}
+
+		if err := o.onMessage(m); err != nil {
+			o.logger.Warn("failed to handle OSC message", "err", err, "address", m.Address)
+		}
+	}
+}
+
+func (o *OSCServer) allows(ip net.IP) bool {
+	for _, network := range o.allowed {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// apply a message to the local node
+func (o *OSCServer) onMessage(m OSCMessage) error {
+	if len(m.Arguments) != 1 {
+		return fmt.Errorf("expected one argument to %s, got %d", m.Address, len(m.Arguments))
+	}
+	value, ok := oscNumber(m.Arguments[0])
+	if !ok {
+		return fmt.Errorf("expected a number for %s, got %T", m.Address, m.Arguments[0])
+	}
+	if math.IsNaN(value) || math.IsInf(value, 0) {
+		return fmt.Errorf("expected a finite number for %s, got %v", m.Address, value)
+	}
+
+	note, mute := o.np.LocalNote()
+	switch m.Address {
+	case oscPitchAddress:
+		if value < 0 || value > 127 {
+			return fmt.Errorf("invalid pitch %v", value)
+		}
+		note = int(value)
+	case oscMuteAddress:
+		mute = value != 0
+	case oscTempoAddress:
+		if err := o.np.ProposeTempo(float32(value)); err != nil {
+			return err
+		}
+		o.np.Notify()
+		return nil
+	default:
+		return fmt.Errorf("unknown OSC address %s", m.Address)
+	}
+
+	o.np.SetNote(note, mute)
+	o.np.Notify()
+	return nil
+}
+
+// controllers send numbers as ints or floats
+func oscNumber(arg interface{}) (float64, bool) {
+	switch value := arg.(type) {
+	case int32:
+		return float64(value), true
+	case float32:
+		return float64(value), true
+	}
+	return 0, false
+}
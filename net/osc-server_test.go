@@ -0,0 +1,98 @@
+package loopnet
+
+import (
+	"math"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestOSCServer(t *testing.T) {
+	node := createTestNode(t)
+	peer := createTestNode(t)
+	jam := node.JoinSession("jam", 60, false)
+	peerJam := peer.JoinSession("jam", 62, false)
+	jam.ConnectToHost(peer)
+	peerJam.ConnectToHost(node)
+	waitFor(t, func() bool { return jam.NoteStore.ActiveNotes() == 2 })
+
+	send := func(server *OSCServer, m OSCMessage) {
+		conn, err := net.DialUDP("udp", nil, server.Addr().(*net.UDPAddr))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+
+		data, _ := EncodeOSC(m)
+		conn.Write(data)
+	}
+
+	server, err := NewOSCServer(jam, "127.0.0.1:0", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	t.Run("changes the pitch of the local note", func(t *testing.T) {
+		send(server, OSCMessage{Address: "/loopnet/pitch", Arguments: []interface{}{int32(67)}})
+
+		waitFor(t, func() bool {
+			note, _ := jam.LocalNote()
+			return note == 67
+		})
+		self, _ := jam.NoteStore.LastRevision(jam.NoteStore.SelfId())
+		if self.Revision != 1 || !node.authenticateNote(&self) {
+			t.Errorf("expected a signed note with a new revision, got %v", self)
+		}
+	})
+
+	t.Run("gossips changes to the session", func(t *testing.T) {
+		waitFor(t, func() bool {
+			note, found := peerJam.NoteStore.LastRevision(jam.NoteStore.SelfId())
+			return found && note.Note == 67
+		})
+	})
+
+	t.Run("mutes the local note", func(t *testing.T) {
+		send(server, OSCMessage{Address: "/loopnet/mute", Arguments: []interface{}{int32(1)}})
+
+		waitFor(t, func() bool {
+			note, mute := jam.LocalNote()
+			return mute && note == 67
+		})
+	})
+
+	t.Run("proposes a tempo", func(t *testing.T) {
+		send(server, OSCMessage{Address: "/loopnet/tempo", Arguments: []interface{}{float32(96)}})
+
+		waitFor(t, func() bool { return jam.Parameters.Current().Tempo == 96 })
+	})
+
+	t.Run("rejects numbers that are not finite", func(t *testing.T) {
+		for _, address := range []string{"/loopnet/pitch", "/loopnet/mute", "/loopnet/tempo"} {
+			for _, value := range []float32{float32(math.NaN()), float32(math.Inf(1))} {
+				if err := server.onMessage(OSCMessage{Address: address, Arguments: []interface{}{value}}); err == nil {
+					t.Errorf("accepted %v for %s", value, address)
+				}
+			}
+		}
+		if note, mute := jam.LocalNote(); note != 67 || !mute {
+			t.Errorf("expected the local note to be unchanged, got %d and mute %v", note, mute)
+		}
+	})
+
+	t.Run("ignores messages from sources not allowed", func(t *testing.T) {
+		restricted, err := NewOSCServer(jam, "127.0.0.1:0", []string{"10.0.0.1", "192.168.0.0/16"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer restricted.Close()
+
+		send(restricted, OSCMessage{Address: "/loopnet/pitch", Arguments: []interface{}{int32(72)}})
+		time.Sleep(50 * time.Millisecond)
+
+		if note, _ := jam.LocalNote(); note == 72 {
+			t.Error("accepted a message from a source not allowed")
+		}
+	})
+}
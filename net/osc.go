@@ -0,0 +1,114 @@
+package loopnet
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+)
+
+// Open Sound Control messages: an address pattern, a type tag string and the
+// arguments, each padded to a multiple of 4 bytes. Arguments are int32,
+// float32 or string.
+
+// OSCMessage is a single OSC message.
+type OSCMessage struct {
+	Address   string
+	Arguments []interface{} // int32, float32 or string
+}
+
+// EncodeOSC encodes a message for sending in a UDP packet.
+func EncodeOSC(m OSCMessage) ([]byte, error) {
+	if !strings.HasPrefix(m.Address, "/") {
+		return nil, fmt.Errorf("invalid OSC address %q", m.Address)
+	}
+
+	tags := ","
+	args := &bytes.Buffer{}
+	for _, arg := range m.Arguments {
+		switch value := arg.(type) {
+		case int32:
+			tags += "i"
+			binary.Write(args, binary.BigEndian, value)
+		case float32:
+			tags += "f"
+			binary.Write(args, binary.BigEndian, math.Float32bits(value))
+		case string:
+			tags += "s"
+			writeOSCString(args, value)
+		default:
+			return nil, fmt.Errorf("unsupported OSC argument %T", arg)
+		}
+	}
+
+	buf := &bytes.Buffer{}
+	writeOSCString(buf, m.Address)
+	writeOSCString(buf, tags)
+	buf.Write(args.Bytes())
+	return buf.Bytes(), nil
+}
+
+// DecodeOSC decodes a message received in a UDP packet.
+func DecodeOSC(data []byte) (OSCMessage, error) {
+	m := OSCMessage{}
+	address, data, err := readOSCString(data)
+	if err != nil {
+		return m, err
+	}
+	if !strings.HasPrefix(address, "/") {
+		return m, errors.New("not an OSC message")
+	}
+	m.Address = address
+
+	tags, data, err := readOSCString(data)
+	if err != nil || !strings.HasPrefix(tags, ",") {
+		return m, errors.New("missing OSC type tags")
+	}
+
+	m.Arguments = make([]interface{}, 0, len(tags)-1)
+	for _, tag := range tags[1:] {
+		switch tag {
+		case 'i', 'f':
+			if len(data) < 4 {
+				return m, errors.New("truncated OSC argument")
+			}
+			bits := binary.BigEndian.Uint32(data)
+			if tag == 'i' {
+				m.Arguments = append(m.Arguments, int32(bits))
+			} else {
+				m.Arguments = append(m.Arguments, math.Float32frombits(bits))
+			}
+			data = data[4:]
+		case 's':
+			var value string
+			value, data, err = readOSCString(data)
+			if err != nil {
+				return m, err
+			}
+			m.Arguments = append(m.Arguments, value)
+		default:
+			return m, fmt.Errorf("unsupported OSC type tag %q", tag)
+		}
+	}
+	return m, nil
+}
+
+// strings are null terminated and padded with nulls to 4 bytes
+func writeOSCString(buf *bytes.Buffer, value string) {
+	buf.WriteString(value)
+	buf.Write(make([]byte, 4-len(value)%4))
+}
+
+func readOSCString(data []byte) (string, []byte, error) {
+	end := bytes.IndexByte(data, 0)
+	if end < 0 {
+		return "", nil, errors.New("unterminated OSC string")
+	}
+	size := end + 4 - end%4
+	if size > len(data) {
+		return "", nil, errors.New("truncated OSC string")
+	}
+	return string(data[:end]), data[size:], nil
+}
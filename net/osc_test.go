@@ -0,0 +1,57 @@
+package loopnet
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestOSC(t *testing.T) {
+	t.Run("EncodeOSC", func(t *testing.T) {
+		t.Run("pads strings and encodes arguments big endian", func(t *testing.T) {
+			data, err := EncodeOSC(OSCMessage{Address: "/note", Arguments: []interface{}{int32(60), float32(0.5)}})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			expected := []byte{
+				'/', 'n', 'o', 't', 'e', 0, 0, 0,
+				',', 'i', 'f', 0,
+				0, 0, 0, 60,
+				0x3f, 0, 0, 0,
+			}
+			if !bytes.Equal(data, expected) {
+				t.Errorf("unexpected encoding %v", data)
+			}
+		})
+
+		t.Run("rejects invalid addresses", func(t *testing.T) {
+			if _, err := EncodeOSC(OSCMessage{Address: "note"}); err == nil {
+				t.Error("encoded an address without a leading slash")
+			}
+		})
+	})
+
+	t.Run("DecodeOSC", func(t *testing.T) {
+		t.Run("decodes encoded messages", func(t *testing.T) {
+			m := OSCMessage{Address: "/loopnet/scale", Arguments: []interface{}{"pentatonic", int32(-3), float32(120)}}
+			data, _ := EncodeOSC(m)
+
+			decoded, err := DecodeOSC(data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(decoded, m) {
+				t.Errorf("expected %v, decoded %v", m, decoded)
+			}
+		})
+
+		t.Run("rejects truncated messages", func(t *testing.T) {
+			data, _ := EncodeOSC(OSCMessage{Address: "/note", Arguments: []interface{}{int32(60)}})
+
+			if _, err := DecodeOSC(data[:len(data)-2]); err == nil {
+				t.Error("decoded a truncated message")
+			}
+		})
+	})
+}
@@ -0,0 +1,146 @@
+package loopnet
+
+import (
+	p2p "github.com/acruikshank/loopnet/pb"
+	"math"
+	"sort"
+	"sync"
+)
+
+// how far past the highest revision seen a proposal or command may jump.
+// Honest nodes use the next revision, so only a node that missed as many
+// needs more; a larger jump would let a node pin its value for good.
+const maxRevisionStep = 1 << 16
+
+// Parameters are the musical parameters shared by every node in a session.
+type Parameters struct {
+	Tempo float32 // beats per minute
+	Scale string  // scale name
+	Root  int     // semitones above C the scale starts on
+	Swing float32 // 0 (straight) to 1 (odd steps delayed by half a step)
+}
+
+// parameters used until a node proposes a value
+var defaultParameters = Parameters{
+	Tempo: defaultTempo,
+	Scale: "chromatic",
+	Root:  0,
+	Swing: 0,
+}
+
+// ParameterStore is a replicated register of the session's shared parameters.
+// Nodes propose values for a parameter and every node keeps the proposal with
+// the highest revision, breaking ties by the lowest node id, so all nodes that
+// have seen the same proposals agree on the same values.
+type ParameterStore struct {
+	proposals   map[p2p.Parameter_Name]*p2p.Parameter // winning proposal per parameter
+	revision    uint32                                // highest revision seen
+	proposalMux *sync.RWMutex
+}
+
+// NewParameterStore creates a store holding the default parameters.
+func NewParameterStore() *ParameterStore {
+	return &ParameterStore{
+		proposals:   make(map[p2p.Parameter_Name]*p2p.Parameter),
+		proposalMux: &sync.RWMutex{},
+	}
+}
+
+// OnParameter takes a proposal and stores it if it holds a valid value, a
+// revision within reach of those seen, and wins over the current proposal
+// for the same parameter. The proposal must
+// already be authenticated.
+func (params *ParameterStore) OnParameter(parameter p2p.Parameter) bool {
+	if validateParameter(&parameter) != nil {
+		return false
+	}
+
+	params.proposalMux.Lock()
+	defer params.proposalMux.Unlock()
+
+	if !revisionInBounds(parameter.Revision, params.revision) {
+		return false
+	}
+	if parameter.Revision > params.revision {
+		params.revision = parameter.Revision
+	}
+
+	existing, found := params.proposals[parameter.Name]
+	if found && !proposalWins(&parameter, existing) {
+		return false
+	}
+	params.proposals[parameter.Name] = &parameter
+	return true
+}
+
+// NextRevision returns the revision for a new proposal so that it wins over
+// every proposal seen so far.
+func (params *ParameterStore) NextRevision() uint32 {
+	params.proposalMux.RLock()
+	defer params.proposalMux.RUnlock()
+
+	return params.revision + 1
+}
+
+// Current returns the agreed parameters, using defaults for parameters
+// nobody has proposed.
+func (params *ParameterStore) Current() Parameters {
+	params.proposalMux.RLock()
+	defer params.proposalMux.RUnlock()
+
+	parameters := defaultParameters
+	for name, proposal := range params.proposals {
+		switch name {
+		case p2p.Parameter_TEMPO:
+			parameters.Tempo = proposal.Value
+		case p2p.Parameter_SCALE:
+			parameters.Scale = proposal.Scale
+		case p2p.Parameter_ROOT:
+			parameters.Root = int(proposal.Value)
+		case p2p.Parameter_SWING:
+			parameters.Swing = proposal.Value
+		}
+	}
+	return parameters
+}
+
+// Proposal returns the winning proposal for a parameter, if any.
+func (params *ParameterStore) Proposal(name p2p.Parameter_Name) (p2p.Parameter, bool) {
+	params.proposalMux.RLock()
+	defer params.proposalMux.RUnlock()
+
+	proposal, found := params.proposals[name]
+	if !found {
+		return p2p.Parameter{}, false
+	}
+	return *proposal, true
+}
+
+// Proposals returns the winning proposal of every proposed parameter.
+func (params *ParameterStore) Proposals() []*p2p.Parameter {
+	params.proposalMux.RLock()
+	defer params.proposalMux.RUnlock()
+
+	proposals := make([]*p2p.Parameter, 0, len(params.proposals))
+	for _, proposal := range params.proposals {
+		proposals = append(proposals, proposal)
+	}
+	sort.Slice(proposals, func(i, j int) bool {
+		return proposals[i].Name < proposals[j].Name
+	})
+	return proposals
+}
+
+// whether a revision stays within maxRevisionStep of the highest revision
+// seen, leaving room for the revisions after it
+func revisionInBounds(revision, highest uint32) bool {
+	return revision < math.MaxUint32 && uint64(revision) <= uint64(highest)+maxRevisionStep
+}
+
+// highest revision wins, then lowest node id
+func proposalWins(a, b *p2p.Parameter) bool {
+	if a.Revision != b.Revision {
+		return a.Revision > b.Revision
+	}
+	return a.NodeId < b.NodeId
+}
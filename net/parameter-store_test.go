@@ -0,0 +1,110 @@
+package loopnet
+
+import (
+	p2p "github.com/acruikshank/loopnet/pb"
+	"math"
+	"testing"
+)
+
+func TestParameterStore(t *testing.T) {
+	t.Run("starts with default parameters", func(t *testing.T) {
+		parameters := NewParameterStore()
+
+		if parameters.Current() != defaultParameters {
+			t.Errorf("unexpected initial parameters %v", parameters.Current())
+		}
+	})
+
+	t.Run("highest revision wins", func(t *testing.T) {
+		parameters := NewParameterStore()
+
+		parameters.OnParameter(*createParameter("n1", 2, 100))
+		parameters.OnParameter(*createParameter("n2", 1, 90))
+
+		if parameters.Current().Tempo != 100 || parameters.NextRevision() != 3 {
+			t.Errorf("unexpected tempo %v", parameters.Current().Tempo)
+		}
+	})
+
+	t.Run("lowest node id breaks ties", func(t *testing.T) {
+		parameters := NewParameterStore()
+
+		parameters.OnParameter(*createParameter("n2", 1, 90))
+		parameters.OnParameter(*createParameter("n1", 1, 100))
+		parameters.OnParameter(*createParameter("n3", 1, 80))
+
+		proposal, _ := parameters.Proposal(p2p.Parameter_TEMPO)
+		if proposal.NodeId != "n1" {
+			t.Errorf("expected n1 to win, got %s", proposal.NodeId)
+		}
+	})
+
+	t.Run("rejects values nodes can't play", func(t *testing.T) {
+		parameters := NewParameterStore()
+		invalid := []p2p.Parameter{
+			{Name: p2p.Parameter_TEMPO, Value: float32(math.Inf(1)), Revision: 1},
+			{Name: p2p.Parameter_TEMPO, Value: float32(math.NaN()), Revision: 2},
+			{Name: p2p.Parameter_TEMPO, Value: 1e20, Revision: 3},
+			{Name: p2p.Parameter_TEMPO, Value: 0, Revision: 4},
+			{Name: p2p.Parameter_ROOT, Value: -1, Revision: 5},
+			{Name: p2p.Parameter_ROOT, Value: 2.5, Revision: 6},
+			{Name: p2p.Parameter_SWING, Value: 2, Revision: 7},
+			{Name: p2p.Parameter_SCALE, Scale: "nonexistent", Revision: 8},
+		}
+		for _, parameter := range invalid {
+			if parameters.OnParameter(parameter) {
+				t.Errorf("accepted %v", parameter)
+			}
+		}
+		if parameters.Current() != defaultParameters || parameters.NextRevision() != 1 {
+			t.Errorf("unexpected parameters %v", parameters.Current())
+		}
+	})
+
+	t.Run("rejects revisions that would pin a value", func(t *testing.T) {
+		parameters := NewParameterStore()
+		parameters.OnParameter(*createParameter("n1", 5, 100))
+
+		if parameters.OnParameter(*createParameter("n2", math.MaxUint32, 90)) {
+			t.Error("accepted the last revision")
+		}
+		if parameters.OnParameter(*createParameter("n2", 6+maxRevisionStep, 90)) {
+			t.Error("accepted a revision far past those seen")
+		}
+		if !parameters.OnParameter(*createParameter("n2", 5+maxRevisionStep, 90)) || parameters.NextRevision() != 6+maxRevisionStep {
+			t.Error("expected a revision within reach to win")
+		}
+	})
+
+	t.Run("converges regardless of delivery order", func(t *testing.T) {
+		proposals := []*p2p.Parameter{
+			createParameter("n3", 4, 80),
+			createParameter("n1", 2, 100),
+			createParameter("n2", 4, 90),
+			{Name: p2p.Parameter_SCALE, Scale: "minor", NodeId: "n4", Revision: 1},
+		}
+
+		forward := NewParameterStore()
+		backward := NewParameterStore()
+		for i := range proposals {
+			forward.OnParameter(*proposals[i])
+			backward.OnParameter(*proposals[len(proposals)-1-i])
+		}
+
+		if forward.Current() != backward.Current() {
+			t.Errorf("diverged: %v vs %v", forward.Current(), backward.Current())
+		}
+		if forward.Current().Tempo != 90 || forward.Current().Scale != "minor" {
+			t.Errorf("unexpected parameters %v", forward.Current())
+		}
+	})
+}
+
+func createParameter(node string, revision uint32, tempo float32) *p2p.Parameter {
+	return &p2p.Parameter{
+		Name:     p2p.Parameter_TEMPO,
+		Value:    tempo,
+		NodeId:   node,
+		Revision: revision,
+	}
+}
@@ -0,0 +1,144 @@
+package loopnet
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/gogo/protobuf/proto"
+
+	p2p "github.com/acruikshank/loopnet/pb"
+	peer "github.com/libp2p/go-libp2p-peer"
+)
+
+// slowest and fastest tempo in beats per minute a node accepts
+const minTempo = 20
+const maxTempo = 999
+
+// ProposeTempo proposes a session tempo in beats per minute.
+func (np *NotificationProtocol) ProposeTempo(bpm float32) error {
+	return np.propose(&p2p.Parameter{Name: p2p.Parameter_TEMPO, Value: bpm})
+}
+
+// ProposeScale proposes the scale notes are quantized to.
+func (np *NotificationProtocol) ProposeScale(scale string) error {
+	return np.propose(&p2p.Parameter{Name: p2p.Parameter_SCALE, Scale: scale})
+}
+
+// ProposeRoot proposes the root of the scale in semitones above C.
+func (np *NotificationProtocol) ProposeRoot(root int) error {
+	if root < 0 || root > 11 {
+		return fmt.Errorf("invalid root %d", root)
+	}
+	return np.propose(&p2p.Parameter{Name: p2p.Parameter_ROOT, Value: float32(root)})
+}
+
+// ProposeSwing proposes how far odd steps are delayed, from 0 to 1.
+func (np *NotificationProtocol) ProposeSwing(swing float32) error {
+	return np.propose(&p2p.Parameter{Name: p2p.Parameter_SWING, Value: swing})
+}
+
+// validTempo reports whether a tempo is finite and within the bounds nodes
+// can play at.
+func validTempo(bpm float32) bool {
+	// NaN fails both comparisons
+	return bpm >= minTempo && bpm <= maxTempo
+}
+
+// validateParameter checks that a proposal holds a value every node can
+// play, whoever signed it.
+func validateParameter(parameter *p2p.Parameter) error {
+	value := parameter.Value
+	switch parameter.Name {
+	case p2p.Parameter_TEMPO:
+		if !validTempo(value) {
+			return fmt.Errorf("invalid tempo %v", value)
+		}
+	case p2p.Parameter_SCALE:
+		if _, found := scales[parameter.Scale]; !found {
+			return fmt.Errorf("unknown scale %s", parameter.Scale)
+		}
+	case p2p.Parameter_ROOT:
+		if !(value >= 0 && value <= 11) || float64(value) != math.Trunc(float64(value)) {
+			return fmt.Errorf("invalid root %v", value)
+		}
+	case p2p.Parameter_SWING:
+		if !(value >= 0 && value <= 1) {
+			return fmt.Errorf("invalid swing %v", value)
+		}
+	default:
+		return fmt.Errorf("unknown parameter %v", parameter.Name)
+	}
+	return nil
+}
+
+// sign a proposal as this node, store it locally and let gossip spread it
+func (np *NotificationProtocol) propose(parameter *p2p.Parameter) error {
+	if err := validateParameter(parameter); err != nil {
+		return err
+	}
+
+	nodePubKey, err := np.node.Peerstore().PubKey(np.node.ID()).Bytes()
+	if err != nil {
+		return err
+	}
+
+	parameter.Revision = np.Parameters.NextRevision()
+	parameter.Session = np.session
+	parameter.NodeId = peer.IDB58Encode(np.node.ID())
+	parameter.NodePubKey = nodePubKey
+	parameter.Sign = make([]byte, 0)
+
+	data, err := proto.Marshal(parameter)
+	if err != nil {
+		return err
+	}
+	parameter.Sign, err = np.node.signData(data)
+	if err != nil {
+		return err
+	}
+
+	np.Parameters.OnParameter(*parameter)
+	return nil
+}
+
+// store authentic proposals from nodes in the session
+func (np *NotificationProtocol) onParameters(parameters []*p2p.Parameter) {
+	for _, parameter := range parameters {
+		if parameter.Session != np.session || !np.node.authenticateParameter(parameter) {
+			np.logger.Warn("failed to authenticate parameter", "author", parameter.NodeId)
+			continue
+		}
+
+		if !np.NoteStore.Admitted(parameter.NodeId) {
+			np.logger.Warn("rejecting parameter from node not admitted", "author", parameter.NodeId)
+			continue
+		}
+
+		if err := validateParameter(parameter); err != nil {
+			np.logger.Warn("rejecting invalid parameter", "err", err, "author", parameter.NodeId)
+			continue
+		}
+
+		np.Parameters.OnParameter(*parameter)
+	}
+}
+
+// Authenticate a proposal by the signature of the proposing node
+func (n *Node) authenticateParameter(parameter *p2p.Parameter) bool {
+	// marshal a copy without the signature, proposals are shared with gossip
+	unsigned := *parameter
+	unsigned.Sign = make([]byte, 0)
+	bin, err := proto.Marshal(&unsigned)
+	if err != nil {
+		n.logger(logNode).Error("failed to marshal parameter", "err", err)
+		return false
+	}
+
+	nodeId, err := peer.IDB58Decode(parameter.NodeId)
+	if err != nil {
+		n.logger(logNode).Warn("failed to decode node id from base58", "err", err, "id", parameter.NodeId)
+		return false
+	}
+
+	return n.verifyData(bin, parameter.Sign, nodeId, parameter.NodePubKey)
+}
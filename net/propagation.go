@@ -0,0 +1,89 @@
+package loopnet
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+
+	p2p "github.com/acruikshank/loopnet/pb"
+)
+
+// PropagationEvent is the arrival of a new note revision at a node.
+type PropagationEvent struct {
+	Time     time.Time `json:"time"`    // swarm time of arrival
+	Session  string    `json:"session"` // session of the note
+	Node     string    `json:"node"`    // id of the receiving node
+	From     string    `json:"from"`    // id of the peer that relayed the note
+	Author   string    `json:"author"`  // id of the node that created the note
+	Revision uint32    `json:"revision"`
+	Hops     uint32    `json:"hops"`    // nodes the revision passed through, 1 when received from its author
+	Origin   time.Time `json:"origin"`  // swarm time the author created the revision
+	Latency  float64   `json:"latency"` // seconds from origin to arrival
+}
+
+// PropagationTrace writes propagation events as JSON lines. One trace
+// can be shared by the sessions of many nodes.
+type PropagationTrace struct {
+	encoder  *json.Encoder
+	traceMux *sync.Mutex
+}
+
+// NewPropagationTrace creates a trace writing to w.
+func NewPropagationTrace(w io.Writer) *PropagationTrace {
+	return &PropagationTrace{encoder: json.NewEncoder(w), traceMux: &sync.Mutex{}}
+}
+
+// Record writes an event as a line of JSON.
+func (t *PropagationTrace) Record(event PropagationEvent) error {
+	t.traceMux.Lock()
+	defer t.traceMux.Unlock()
+
+	return t.encoder.Encode(event)
+}
+
+// SetTrace records the arrival of every new note revision of the session
+// in a trace, or stops recording if trace is nil.
+func (np *NotificationProtocol) SetTrace(trace *PropagationTrace) {
+	np.traceMux.Lock()
+	defer np.traceMux.Unlock()
+
+	np.trace = trace
+}
+
+// record the hop count and latency of a new note revision relayed by a peer.
+// Envelopes with an unknown path are not recorded.
+func (np *NotificationProtocol) onPropagation(envelope *p2p.NoteEnvelope, from string) {
+	if envelope.Origin == 0 || envelope.Hops == 0 {
+		return
+	}
+
+	// latency can be slightly negative while clocks are synchronizing
+	now := np.now()
+	origin := time.Unix(0, envelope.Origin)
+	latency := now.Sub(origin)
+	np.Metrics.PropagationLatency.Observe(latency)
+	np.Metrics.PropagationHops.ObserveValue(float64(envelope.Hops))
+
+	np.traceMux.Lock()
+	trace := np.trace
+	np.traceMux.Unlock()
+	if trace == nil {
+		return
+	}
+
+	err := trace.Record(PropagationEvent{
+		Time:     now,
+		Session:  np.session,
+		Node:     np.NoteStore.SelfId(),
+		From:     from,
+		Author:   envelope.Note.NodeId,
+		Revision: envelope.Note.Revision,
+		Hops:     envelope.Hops,
+		Origin:   origin,
+		Latency:  latency.Seconds(),
+	})
+	if err != nil {
+		np.logger.Warn("failed to record propagation", "err", err)
+	}
+}
@@ -0,0 +1,164 @@
+package loopnet
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"strings"
+	"sync"
+	"testing"
+
+	p2p "github.com/acruikshank/loopnet/pb"
+	inet "github.com/libp2p/go-libp2p-net"
+	ps "github.com/libp2p/go-libp2p-peerstore"
+	protocol "github.com/libp2p/go-libp2p-protocol"
+	protobufCodec "github.com/multiformats/go-multicodec/protobuf"
+)
+
+// a buffer safe to read while notifications are traced
+type traceBuffer struct {
+	buf bytes.Buffer
+	mux sync.Mutex
+}
+
+func (b *traceBuffer) Write(p []byte) (int, error) {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *traceBuffer) events(t *testing.T) []PropagationEvent {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	events := make([]PropagationEvent, 0)
+	for _, line := range strings.Split(strings.TrimSpace(b.buf.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		event := PropagationEvent{}
+		if err := json.Unmarshal([]byte(line), &event); err != nil {
+			t.Fatal(err)
+		}
+		events = append(events, event)
+	}
+	return events
+}
+
+func TestPropagation(t *testing.T) {
+	t.Run("RandomEnvelopes", func(t *testing.T) {
+		t.Run("relays notes one hop further with their origin", func(t *testing.T) {
+			noteStore := NewNoteStore(createNote("self", 0, 60, false))
+			noteStore.OnEnvelope(p2p.NoteEnvelope{Note: createNote("n1", 1, 62, false), Hops: 2, Origin: 1000})
+
+			for _, envelope := range noteStore.RandomEnvelopes(10, false) {
+				switch envelope.Note.NodeId {
+				case "self":
+					if envelope.Hops != 1 || envelope.Origin == 0 {
+						t.Errorf("expected own note at 1 hop with an origin, got %d hops from %d", envelope.Hops, envelope.Origin)
+					}
+				case "n1":
+					if envelope.Hops != 3 || envelope.Origin != 1000 {
+						t.Errorf("expected relayed note at 3 hops from 1000, got %d hops from %d", envelope.Hops, envelope.Origin)
+					}
+				}
+			}
+		})
+
+		t.Run("leaves the path of notes received without an envelope unknown", func(t *testing.T) {
+			noteStore := NewNoteStore(createNote("self", 0, 60, false))
+			noteStore.OnNote(*createNote("n1", 1, 62, false))
+
+			envelopes := noteStore.RandomEnvelopes(10, true)
+			if len(envelopes) != 1 || envelopes[0].Hops != 0 || envelopes[0].Origin != 0 {
+				t.Errorf("unexpected envelopes %v", envelopes)
+			}
+		})
+	})
+
+	t.Run("OnEnvelope only reports newer revisions as stored", func(t *testing.T) {
+		noteStore := NewNoteStore(createNote("self", 0, 60, false))
+
+		stored, added := noteStore.OnEnvelope(p2p.NoteEnvelope{Note: createNote("n1", 2, 62, false), Hops: 1, Origin: 1})
+		if !stored || !added {
+			t.Error("expected a new node's note to be stored and added")
+		}
+		stored, added = noteStore.OnEnvelope(p2p.NoteEnvelope{Note: createNote("n1", 2, 62, false), Hops: 3, Origin: 1})
+		if stored || added {
+			t.Error("expected a copy of a stored revision to be ignored")
+		}
+	})
+
+	t.Run("traces hops and latency of notes relayed through the swarm", func(t *testing.T) {
+		node1 := createTestNode(t)
+		node2 := createTestNode(t)
+		node3 := createTestNode(t)
+		jam1 := node1.JoinSession("jam", 60, false)
+		jam2 := node2.JoinSession("jam", 62, false)
+		jam3 := node3.JoinSession("jam", 64, false)
+
+		trace := &traceBuffer{}
+		jam3.SetTrace(NewPropagationTrace(trace))
+
+		// node1 only knows node2, node3 only hears from node2
+		jam2.ConnectToHost(node1)
+		waitFor(t, func() bool { return jam1.NoteStore.ActiveNotes() == 2 })
+
+		jam1.SetNote(61, false)
+		jam1.ConnectToHost(node2)
+		waitFor(t, func() bool {
+			note, _ := jam2.NoteStore.LastRevision(jam1.NoteStore.SelfId())
+			return note.Revision == 1
+		})
+		jam2.ConnectToHost(node3)
+
+		author := jam1.NoteStore.SelfId()
+		var relayed *PropagationEvent
+		waitFor(t, func() bool {
+			for _, event := range trace.events(t) {
+				if event.Author == author && event.Revision == 1 {
+					relayed = &event
+					return true
+				}
+			}
+			return false
+		})
+
+		if relayed.Hops != 2 {
+			t.Errorf("expected the note to arrive in 2 hops, got %d", relayed.Hops)
+		}
+		if relayed.From != jam2.NoteStore.SelfId() || relayed.Node != jam3.NoteStore.SelfId() || relayed.Session != "jam" {
+			t.Errorf("unexpected event %+v", relayed)
+		}
+		if relayed.Latency <= 0 || relayed.Latency > 5 {
+			t.Errorf("unexpected latency %f", relayed.Latency)
+		}
+		if jam3.Metrics.PropagationHops.Count() == 0 || jam3.Metrics.PropagationLatency.Count() == 0 {
+			t.Error("expected propagation metrics to be observed")
+		}
+	})
+
+	t.Run("sends bare notes to nodes that predate envelopes", func(t *testing.T) {
+		node, legacy := createTestNode(t), createTestNode(t)
+		jam := node.JoinSession("jam", 60, false)
+		node.Peerstore().AddAddrs(legacy.ID(), legacy.Addrs(), ps.PermanentAddrTTL)
+
+		// a node that only speaks the legacy protocol
+		received := make(chan *p2p.Message, 1)
+		legacy.SetStreamHandler(protocol.ID(legacyNotificationRequest+"/jam"), func(s inet.Stream) {
+			message := &p2p.Message{}
+			if err := protobufCodec.Multicodec(nil).Decoder(bufio.NewReader(s)).Decode(message); err != nil {
+				t.Error(err)
+			}
+			received <- message
+		})
+
+		if !jam.sendNotification(legacy.ID()) {
+			t.Fatal("failed to notify the legacy node")
+		}
+		message := <-received
+		if len(message.Notes) != 1 || len(message.Envelopes) != 0 || message.Notes[0].NodeId != jam.NoteStore.SelfId() {
+			t.Errorf("unexpected message %v", message)
+		}
+	})
+}
@@ -0,0 +1,25 @@
+package loopnet
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+)
+
+// header of the libp2p pre-shared key file format (v1, base16 encoded)
+const pskHeader = "/key/swarm/psk/1.0.0/\n/base16/\n"
+
+// length of a private network key in bytes
+const pskLength = 32
+
+// GeneratePSK creates a random private network key encoded in the
+// swarm.key format understood by libp2p, ready to be written to a file
+// and passed to NewNode.
+func GeneratePSK() ([]byte, error) {
+	key := make([]byte, pskLength)
+	_, err := rand.Read(key)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(fmt.Sprintf("%s%s\n", pskHeader, hex.EncodeToString(key))), nil
+}
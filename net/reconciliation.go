@@ -0,0 +1,259 @@
+package loopnet
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"time"
+
+	p2p "github.com/acruikshank/loopnet/pb"
+	inet "github.com/libp2p/go-libp2p-net"
+	peer "github.com/libp2p/go-libp2p-peer"
+	protocol "github.com/libp2p/go-libp2p-protocol"
+	protobufCodec "github.com/multiformats/go-multicodec/protobuf"
+)
+
+// pattern: /protocol-name/request-or-response-message/version
+const reconcileRequest = "/loopnet/reconcile/0.0.1"
+
+// steps either node sends before a reconciliation is abandoned. Descending
+// the tree takes merkleDepth+1 steps and transferring notes three more.
+const maxReconcileSteps = 2 * (merkleDepth + 4)
+
+// entries of each kind answered in a step, the number of leaves of the
+// tree, so a step naming every differing subtree of a level is never cut.
+// Wanted notes beyond it are left to the next reconciliation.
+const maxReconcileEntries = 4096
+
+// time a peer has to send each step before the reconciliation is abandoned
+var reconcileStepTimeout = 5 * time.Second
+
+// ReconcileResult counts the notes a reconciliation transferred.
+type ReconcileResult struct {
+	Sent     int // notes the peer lacked or held an older revision of
+	Received int // notes this node lacked or held an older revision of
+	Steps    int // messages exchanged
+}
+
+// reconcileProtocol returns the reconciliation protocol id for a session
+func reconcileProtocol(session string) protocol.ID {
+	return protocol.ID(reconcileRequest + "/" + session)
+}
+
+// Reconcile compares the notes of this node and a peer by exchanging the
+// hashes of a Merkle tree over both stores, descending only into the
+// subtrees that differ, and transfers the notes either node lacks or holds
+// an older revision of. Unlike gossip, it finds every difference however
+// many notes the stores hold.
+func (np *NotificationProtocol) Reconcile(nodeId peer.ID) (ReconcileResult, error) {
+	np.Metrics.Reconciliations.Inc()
+	ctx, cancel := context.WithTimeout(context.Background(), reconcileStepTimeout)
+	defer cancel()
+	s, err := np.node.NewStream(ctx, nodeId, reconcileProtocol(np.session))
+	if err != nil {
+		np.Metrics.ReconciliationsFailed.Inc()
+		return ReconcileResult{}, err
+	}
+	defer s.Close()
+
+	tree := newMerkleTree(np.NoteStore.Notes())
+	first := &p2p.Reconciliation{Nodes: []*p2p.MerkleNode{tree.root()}}
+	result, err := np.reconcile(s, tree, first)
+	if err != nil {
+		np.Metrics.ReconciliationsFailed.Inc()
+		return result, err
+	}
+	np.logger.Debug("reconciled", "peer", peer.IDB58Encode(nodeId), "sent", result.Sent, "received", result.Received, "steps", result.Steps)
+	return result, nil
+}
+
+// remote peer requests handler
+func (np *NotificationProtocol) onReconcile(s inet.Stream) {
+	defer s.Close()
+
+	tree := newMerkleTree(np.NoteStore.Notes())
+	_, err := np.reconcile(s, tree, nil)
+	if err != nil {
+		np.logger.Warn("failed to reconcile", "err", err, "peer", peer.IDB58Encode(s.Conn().RemotePeer()))
+	}
+}
+
+// exchange steps over a stream until either node has nothing to send,
+// starting with first if this node initiated the reconciliation
+func (np *NotificationProtocol) reconcile(s inet.Stream, tree *merkleTree, first *p2p.Reconciliation) (ReconcileResult, error) {
+	from := peer.IDB58Encode(s.Conn().RemotePeer())
+	decoder := protobufCodec.Multicodec(nil).Decoder(bufio.NewReader(s))
+	result := ReconcileResult{}
+
+	send := func(step *p2p.Reconciliation) error {
+		result.Steps++
+		result.Sent += len(step.Envelopes)
+		s.SetDeadline(time.Now().Add(reconcileStepTimeout))
+		if !np.node.sendProtoMessage(step, s) {
+			return fmt.Errorf("failed to send reconciliation step")
+		}
+		return nil
+	}
+
+	if first != nil {
+		if err := send(first); err != nil {
+			return result, err
+		}
+	}
+
+	for result.Steps < maxReconcileSteps {
+		step := &p2p.Reconciliation{}
+		s.SetDeadline(time.Now().Add(reconcileStepTimeout))
+		if err := decoder.Decode(step); err != nil {
+			return result, err
+		}
+		result.Steps++
+
+		if len(step.Envelopes) > 0 {
+			result.Received += len(step.Envelopes)
+			np.Metrics.NotesReconciled.Add(uint64(len(step.Envelopes)))
+			np.onMessage(&p2p.Message{Envelopes: step.Envelopes, Admissions: step.Admissions}, from)
+		}
+		if finalStep(step) {
+			return result, nil
+		}
+
+		answer := np.answerReconciliation(tree, step)
+		if err := send(answer); err != nil {
+			return result, err
+		}
+		if finalStep(answer) {
+			return result, nil
+		}
+	}
+	return result, fmt.Errorf("reconciliation did not finish in %d steps", maxReconcileSteps)
+}
+
+// a step asking nothing of the receiver ends the reconciliation
+func finalStep(step *p2p.Reconciliation) bool {
+	return len(step.Nodes) == 0 && len(step.Leaves) == 0 && len(step.Wanted) == 0
+}
+
+// answer the subtrees that differ with their children, or with their
+// digests at the leaves; answer leaves with the notes the peer lacks and
+// the ids of the notes this node lacks; answer wanted ids with their notes
+func (np *NotificationProtocol) answerReconciliation(tree *merkleTree, step *p2p.Reconciliation) *p2p.Reconciliation {
+	boundStep(step)
+	answer := &p2p.Reconciliation{}
+	sending := make(map[string]bool)
+	sendNote := func(nodeId string) {
+		if sending[nodeId] {
+			return
+		}
+		if envelope, found := np.NoteStore.Envelope(nodeId); found {
+			answer.Envelopes = append(answer.Envelopes, envelope)
+			sending[nodeId] = true
+		}
+	}
+
+	for _, node := range step.Nodes {
+		hash, found := tree.hash(node.Level, node.Index)
+		if !found || bytes.Equal(hash, node.Hash) {
+			continue
+		}
+		if node.Level < merkleDepth {
+			answer.Nodes = append(answer.Nodes, tree.children(node.Level, node.Index)...)
+		} else {
+			answer.Leaves = append(answer.Leaves, tree.leaf(node.Index))
+		}
+	}
+
+	for _, leaf := range step.Leaves {
+		mine := tree.digests(leaf.Index)
+		theirs := make(map[string]uint32)
+		for _, digest := range leaf.Digests {
+			theirs[digest.NodeId] = digest.Revision
+			if merkleLeafOf(digest.NodeId) != leaf.Index {
+				continue
+			}
+			if len(answer.Wanted) == maxReconcileEntries {
+				continue
+			}
+			if ours, found := mine[digest.NodeId]; !found || ours.Revision < digest.Revision {
+				answer.Wanted = append(answer.Wanted, digest.NodeId)
+			}
+		}
+		for nodeId, digest := range mine {
+			if revision, found := theirs[nodeId]; !found || revision < digest.Revision {
+				sendNote(nodeId)
+			}
+		}
+	}
+
+	for _, nodeId := range step.Wanted {
+		sendNote(nodeId)
+	}
+
+	if np.owner != "" && len(answer.Envelopes) > 0 {
+		notes := make([]*p2p.NoteData, 0, len(answer.Envelopes))
+		for _, envelope := range answer.Envelopes {
+			notes = append(notes, envelope.Note)
+		}
+		answer.Admissions = np.admissions(notes)
+	}
+	return answer
+}
+
+// drop repeated entries from a peer's step and cap each kind, so that no
+// step costs more to answer than one naming every leaf once
+func boundStep(step *p2p.Reconciliation) {
+	nodes := make(map[[2]uint32]bool)
+	unique := make([]*p2p.MerkleNode, 0, len(step.Nodes))
+	for _, node := range step.Nodes {
+		key := [2]uint32{node.Level, node.Index}
+		if !nodes[key] && len(unique) < maxReconcileEntries {
+			nodes[key] = true
+			unique = append(unique, node)
+		}
+	}
+	step.Nodes = unique
+
+	leaves := make(map[uint32]bool)
+	uniqueLeaves := make([]*p2p.MerkleLeaf, 0, len(step.Leaves))
+	for _, leaf := range step.Leaves {
+		if !leaves[leaf.Index] && len(uniqueLeaves) < maxReconcileEntries {
+			leaves[leaf.Index] = true
+			uniqueLeaves = append(uniqueLeaves, leaf)
+		}
+	}
+	step.Leaves = uniqueLeaves
+
+	wanted := make(map[string]bool)
+	uniqueWanted := make([]string, 0, len(step.Wanted))
+	for _, nodeId := range step.Wanted {
+		if !wanted[nodeId] && len(uniqueWanted) < maxReconcileEntries {
+			wanted[nodeId] = true
+			uniqueWanted = append(uniqueWanted, nodeId)
+		}
+	}
+	step.Wanted = uniqueWanted
+}
+
+// RunReconciliation reconciles with a random node of the session every
+// interval until stop is closed.
+func (np *NotificationProtocol) RunReconciliation(interval time.Duration, stop <-chan struct{}) {
+	for {
+		select {
+		case <-stop:
+			return
+		case <-time.After(interval):
+		}
+
+		for _, destination := range np.NoteStore.RandomNotes(1, true) {
+			nodeId, err := peer.IDB58Decode(destination.NodeId)
+			if err != nil {
+				np.logger.Warn("error converting id", "err", err, "destination", destination.NodeId)
+				continue
+			}
+			if _, err := np.Reconcile(nodeId); err != nil {
+				np.logger.Warn("failed to reconcile", "err", err, "peer", destination.NodeId)
+			}
+		}
+	}
+}
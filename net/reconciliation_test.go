@@ -0,0 +1,129 @@
+package loopnet
+
+import (
+	"testing"
+	"time"
+
+	p2p "github.com/acruikshank/loopnet/pb"
+	inet "github.com/libp2p/go-libp2p-net"
+	ps "github.com/libp2p/go-libp2p-peerstore"
+)
+
+func TestReconciliation(t *testing.T) {
+	// two nodes of a session that know each other's address
+	createPair := func(t *testing.T) (*NotificationProtocol, *NotificationProtocol) {
+		node, other := createTestNode(t), createTestNode(t)
+		node.Peerstore().AddAddrs(other.ID(), other.Addrs(), ps.PermanentAddrTTL)
+		other.Peerstore().AddAddrs(node.ID(), node.Addrs(), ps.PermanentAddrTTL)
+		return node.JoinSession("jam", 60, false), other.JoinSession("jam", 62, false)
+	}
+
+	t.Run("finishes quickly when stores agree", func(t *testing.T) {
+		jam, other := createPair(t)
+		self, _ := jam.NoteStore.Envelope(jam.NoteStore.SelfId())
+		otherSelf, _ := other.NoteStore.Envelope(other.NoteStore.SelfId())
+		jam.NoteStore.OnEnvelope(*otherSelf)
+		other.NoteStore.OnEnvelope(*self)
+
+		result, err := jam.Reconcile(other.node.ID())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.Steps != 2 || result.Sent != 0 || result.Received != 0 {
+			t.Errorf("unexpected result %+v", result)
+		}
+	})
+
+	t.Run("transfers exactly the notes that differ", func(t *testing.T) {
+		jam, other := createPair(t)
+		notes := signedTestNotes(t, "jam", 30)
+		for _, note := range notes[:20] {
+			jam.NoteStore.OnNote(*note)
+		}
+		for _, note := range notes[10:] {
+			other.NoteStore.OnNote(*note)
+		}
+
+		// the peer holds an older revision of this node's note
+		self, _ := jam.NoteStore.Envelope(jam.NoteStore.SelfId())
+		other.NoteStore.OnEnvelope(*self)
+		jam.SetNote(64, false)
+
+		result, err := jam.Reconcile(other.node.ID())
+		if err != nil {
+			t.Fatal(err)
+		}
+		// 10 authors and this node's newer note one way, 10 authors and the peer's note the other
+		if result.Sent != 11 || result.Received != 11 {
+			t.Errorf("unexpected result %+v", result)
+		}
+
+		waitFor(t, func() bool {
+			return jam.NoteStore.ActiveNotes() == 32 && other.NoteStore.ActiveNotes() == 32
+		})
+		if note, _ := other.NoteStore.LastRevision(jam.NoteStore.SelfId()); note.Note != 64 {
+			t.Errorf("expected the newer note, got %d", note.Note)
+		}
+		if jam.Metrics.Reconciliations.Value() != 1 || jam.Metrics.NotesReconciled.Value() != 11 {
+			t.Error("expected the reconciliation to be counted")
+		}
+
+		result, err = jam.Reconcile(other.node.ID())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.Sent != 0 || result.Received != 0 {
+			t.Errorf("expected reconciled stores to agree, got %+v", result)
+		}
+	})
+
+	t.Run("abandons a peer that stops answering", func(t *testing.T) {
+		jam, other := createPair(t)
+		timeout := reconcileStepTimeout
+		reconcileStepTimeout = 50 * time.Millisecond
+		defer func() { reconcileStepTimeout = timeout }()
+
+		stop := make(chan struct{})
+		defer close(stop)
+		other.node.SetStreamHandler(reconcileProtocol("jam"), func(s inet.Stream) {
+			<-stop
+		})
+
+		start := time.Now()
+		if _, err := jam.Reconcile(other.node.ID()); err == nil {
+			t.Error("expected a silent peer to fail the reconciliation")
+		}
+		if elapsed := time.Since(start); elapsed > time.Second {
+			t.Errorf("took %v to give up", elapsed)
+		}
+	})
+
+	t.Run("answers each subtree once however often it is named", func(t *testing.T) {
+		jam, _ := createPair(t)
+		tree := newMerkleTree(jam.NoteStore.Notes())
+		step := &p2p.Reconciliation{}
+		for i := 0; i < 10000; i++ {
+			step.Nodes = append(step.Nodes, &p2p.MerkleNode{Level: 0, Index: 0})
+			step.Wanted = append(step.Wanted, jam.NoteStore.SelfId())
+		}
+
+		answer := jam.answerReconciliation(tree, step)
+		if len(answer.Nodes) != merkleArity || len(answer.Envelopes) != 1 {
+			t.Errorf("expected one answer per entry, got %d nodes and %d notes", len(answer.Nodes), len(answer.Envelopes))
+		}
+	})
+
+	t.Run("fails for a peer outside the session", func(t *testing.T) {
+		node, other := createTestNode(t), createTestNode(t)
+		node.Peerstore().AddAddrs(other.ID(), other.Addrs(), ps.PermanentAddrTTL)
+		jam := node.JoinSession("jam", 60, false)
+		other.JoinSession("other", 60, false)
+
+		if _, err := jam.Reconcile(other.ID()); err == nil {
+			t.Error("expected reconciling across sessions to fail")
+		}
+		if jam.Metrics.ReconciliationsFailed.Value() != 1 {
+			t.Error("expected the failure to be counted")
+		}
+	})
+}
@@ -0,0 +1,123 @@
+package loopnet
+
+import (
+	"sort"
+	"sync"
+
+	p2p "github.com/acruikshank/loopnet/pb"
+)
+
+// DefaultRumorSends is the number of notifications a changed note is sent
+// in before it is left to the random sample.
+const DefaultRumorSends = 5
+
+// rumors tracks the notes that changed recently. A hot note is sent in
+// every notification until it has been sent a number of times, like a
+// rumor passed on until everyone has heard it, so that a change does not
+// compete with every stale note for the few slots of a notification.
+type rumors struct {
+	hot       map[string]*rumor // hot notes by node id
+	sends     int               // notifications a note is sent in before it cools
+	changes   uint64            // changes heard, ordering rumors
+	rumorsMux *sync.Mutex
+}
+
+// a changed note still being spread
+type rumor struct {
+	revision uint32 // revision that changed, earlier sends don't count
+	sent     int    // notifications the revision was sent in
+	change   uint64 // when the revision was heard, later is fresher
+}
+
+func newRumors(sends int) *rumors {
+	return &rumors{
+		hot:       make(map[string]*rumor),
+		sends:     sends,
+		rumorsMux: &sync.Mutex{},
+	}
+}
+
+// heard marks a new revision of a node's note hot, restarting its count.
+func (r *rumors) heard(nodeId string, revision uint32) {
+	r.rumorsMux.Lock()
+	defer r.rumorsMux.Unlock()
+
+	if r.sends < 1 {
+		return
+	}
+	if existing, found := r.hot[nodeId]; found && existing.revision >= revision {
+		return
+	}
+	r.changes++
+	r.hot[nodeId] = &rumor{revision: revision, change: r.changes}
+}
+
+// pick returns the ids of up to count hot notes, those sent least first
+// and then the freshest.
+func (r *rumors) pick(count int) []string {
+	r.rumorsMux.Lock()
+	defer r.rumorsMux.Unlock()
+
+	ids := make([]string, 0, len(r.hot))
+	for nodeId := range r.hot {
+		ids = append(ids, nodeId)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		a, b := r.hot[ids[i]], r.hot[ids[j]]
+		if a.sent != b.sent {
+			return a.sent < b.sent
+		}
+		return a.change > b.change
+	})
+
+	if len(ids) > count {
+		ids = ids[:count]
+	}
+	return ids
+}
+
+// sent counts a notification sent with envelopes, cooling the notes sent
+// often enough.
+func (r *rumors) sent(envelopes []*p2p.NoteEnvelope) {
+	r.rumorsMux.Lock()
+	defer r.rumorsMux.Unlock()
+
+	for _, envelope := range envelopes {
+		rumor, found := r.hot[envelope.Note.NodeId]
+		if !found || rumor.revision != envelope.Note.Revision {
+			continue
+		}
+		rumor.sent++
+		if rumor.sent >= r.sends {
+			delete(r.hot, envelope.Note.NodeId)
+		}
+	}
+}
+
+// forget stops spreading a note no longer stored.
+func (r *rumors) forget(nodeId string) {
+	r.rumorsMux.Lock()
+	defer r.rumorsMux.Unlock()
+
+	delete(r.hot, nodeId)
+}
+
+// setSends changes the notifications hot notes are sent in. Notes already
+// sent as often cool the next time they are sent; 0 disables rumors.
+func (r *rumors) setSends(sends int) {
+	r.rumorsMux.Lock()
+	defer r.rumorsMux.Unlock()
+
+	r.sends = sends
+	if sends < 1 {
+		r.hot = make(map[string]*rumor)
+	}
+}
+
+// the number of hot notes
+func (r *rumors) count() int {
+	r.rumorsMux.Lock()
+	defer r.rumorsMux.Unlock()
+
+	return len(r.hot)
+}
@@ -0,0 +1,103 @@
+package loopnet
+
+import (
+	"reflect"
+	"testing"
+
+	p2p "github.com/acruikshank/loopnet/pb"
+)
+
+func TestRumors(t *testing.T) {
+	envelopes := func(notes ...*p2p.NoteData) []*p2p.NoteEnvelope {
+		out := make([]*p2p.NoteEnvelope, 0)
+		for _, note := range notes {
+			out = append(out, &p2p.NoteEnvelope{Note: note})
+		}
+		return out
+	}
+
+	t.Run("picks the least sent, then the freshest notes", func(t *testing.T) {
+		r := newRumors(3)
+		r.heard("n1", 1)
+		r.heard("n2", 1)
+		r.heard("n3", 1)
+		r.sent(envelopes(createNote("n3", 1, 60, false)))
+
+		if expected := []string{"n2", "n1"}; !reflect.DeepEqual(r.pick(2), expected) {
+			t.Errorf("expected %v, got %v", expected, r.pick(2))
+		}
+	})
+
+	t.Run("cools notes once sent enough times", func(t *testing.T) {
+		r := newRumors(2)
+		r.heard("n1", 1)
+		r.heard("n2", 1)
+		for i := 0; i < 2; i++ {
+			r.sent(envelopes(createNote("n1", 1, 60, false)))
+		}
+
+		if expected := []string{"n2"}; !reflect.DeepEqual(r.pick(10), expected) {
+			t.Errorf("expected %v, got %v", expected, r.pick(10))
+		}
+	})
+
+	t.Run("restarts the count of a note changing again", func(t *testing.T) {
+		r := newRumors(2)
+		r.heard("n1", 1)
+		r.sent(envelopes(createNote("n1", 1, 60, false)))
+		r.heard("n1", 2)
+		r.sent(envelopes(createNote("n1", 1, 60, false)))
+		r.sent(envelopes(createNote("n1", 2, 60, false)))
+
+		if r.count() != 1 {
+			t.Error("expected the new revision to stay hot")
+		}
+		r.heard("n1", 1)
+		r.sent(envelopes(createNote("n1", 2, 60, false)))
+		if r.count() != 0 {
+			t.Error("expected an older revision to be ignored")
+		}
+	})
+
+	t.Run("sends changed notes ahead of the random sample", func(t *testing.T) {
+		node := createTestNode(t)
+		jam := node.JoinSession("jam", 60, false)
+		for _, note := range createNotes(100) {
+			jam.NoteStore.OnNote(note)
+		}
+		jam.SetNote(64, false)
+
+		for i := 0; i < DefaultRumorSends; i++ {
+			sample := jam.notificationEnvelopes()
+			if len(sample) != maxNotesPerNotification {
+				t.Fatalf("expected %d notes, got %d", maxNotesPerNotification, len(sample))
+			}
+			if sample[0].Note.NodeId != jam.NoteStore.SelfId() || sample[0].Note.Note != 64 {
+				t.Fatalf("expected the changed note first, got %v", sample[0].Note)
+			}
+			seen := make(map[string]bool)
+			for _, envelope := range sample {
+				if seen[envelope.Note.NodeId] {
+					t.Errorf("sent %s twice", envelope.Note.NodeId)
+				}
+				seen[envelope.Note.NodeId] = true
+			}
+			jam.rumors.sent(sample)
+		}
+
+		if jam.rumors.count() != 0 {
+			t.Errorf("expected the changed note to cool, %d hot", jam.rumors.count())
+		}
+	})
+
+	t.Run("sends random samples only when disabled", func(t *testing.T) {
+		node := createTestNode(t)
+		jam := node.JoinSession("jam", 60, false)
+		jam.SetRumorSends(0)
+		jam.SetNote(64, false)
+
+		if jam.rumors.count() != 0 {
+			t.Error("expected no hot notes")
+		}
+	})
+}
@@ -0,0 +1,80 @@
+package loopnet
+
+import (
+	"fmt"
+	"time"
+)
+
+// notes scheduled further ahead of the swarm clock are rejected, several
+// bars even at the slowest tempo
+const maxScheduleAhead = time.Minute
+
+// SetNote changes the local node's note immediately.
+func (np *NotificationProtocol) SetNote(note int, mute bool) {
+	np.ScheduleNote(note, mute, time.Time{})
+}
+
+// LocalNote returns the local node's latest note, which may be scheduled
+// to take effect later.
+func (np *NotificationProtocol) LocalNote() (note int, mute bool) {
+	self, _ := np.NoteStore.LastRevision(np.NoteStore.SelfId())
+	return int(self.Note), self.Mute
+}
+
+// ScheduleNote changes the local node's note at a swarm time. Every node
+// keeps playing the previous note until then, so the change takes effect
+// at the same moment everywhere regardless of when gossip delivers it.
+func (np *NotificationProtocol) ScheduleNote(note int, mute bool, effective time.Time) {
+	np.localMux.Lock()
+	defer np.localMux.Unlock()
+
+	revision := 0
+	if self, found := np.NoteStore.LastRevision(np.NoteStore.SelfId()); found {
+		revision = int(self.Revision) + 1
+	}
+	np.NoteStore.OnNote(*np.node.NewScheduledNoteData(np.session, revision, note, mute, effective))
+	np.rumors.heard(np.NoteStore.SelfId(), uint32(revision))
+}
+
+// SetNoteOnBeat changes the local node's note at the next multiple of
+// quantum beats of the session tempo, e.g. 1 for the next beat or 4 for
+// the next bar in 4/4. It returns the swarm time of the change.
+func (np *NotificationProtocol) SetNoteOnBeat(note int, mute bool, quantum int) (time.Time, error) {
+	if quantum <= 0 {
+		return time.Time{}, fmt.Errorf("invalid quantum %d", quantum)
+	}
+
+	// the next multiple can be a whole quantum away, which peers must accept
+	tempo := sessionParameters(np.NoteStore, np.Parameters).Tempo
+	if float64(time.Minute)/float64(tempo)*float64(quantum) > float64(maxScheduleAhead) {
+		return time.Time{}, fmt.Errorf("quantum %d is too long to schedule", quantum)
+	}
+
+	effective := NextBeat(np.now(), tempo, quantum)
+	np.ScheduleNote(note, mute, effective)
+	return effective, nil
+}
+
+// NextBeat returns the first multiple of quantum beats at a tempo after t.
+// Beats are counted from the unix epoch, like arpeggiator steps, so nodes
+// with synchronized clocks agree on where beats fall.
+func NextBeat(t time.Time, tempo float32, quantum int) time.Time {
+	if tempo <= 0 {
+		tempo = defaultTempo
+	}
+	duration := int64(minDuration(time.Duration(float64(time.Minute) / float64(tempo) * float64(quantum))))
+	return time.Unix(0, (t.UnixNano()/duration+1)*duration)
+}
+
+// current swarm time, or local time for nodes without a clock
+func (np *NotificationProtocol) now() time.Time {
+	return np.clock().Now()
+}
+
+// the node's swarm clock, or the local clock for nodes without one
+func (np *NotificationProtocol) clock() Clock {
+	if np.node.Clock == nil {
+		return localClock{}
+	}
+	return np.node.Clock
+}
@@ -0,0 +1,90 @@
+package loopnet
+
+import (
+	"math"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSchedule(t *testing.T) {
+	t.Run("NextBeat", func(t *testing.T) {
+		t.Run("finds the next beat of the tempo", func(t *testing.T) {
+			// 500ms beats at 120 bpm
+			beat := NextBeat(time.Unix(10, int64(200*time.Millisecond)), 120, 1)
+			if !beat.Equal(time.Unix(10, int64(500*time.Millisecond))) {
+				t.Errorf("unexpected beat %v", beat)
+			}
+		})
+
+		t.Run("finds the next bar with a quantum", func(t *testing.T) {
+			bar := NextBeat(time.Unix(10, 0), 120, 4)
+			if !bar.Equal(time.Unix(12, 0)) {
+				t.Errorf("unexpected bar %v", bar)
+			}
+		})
+
+		t.Run("survives tempos too fast to play", func(t *testing.T) {
+			for _, tempo := range []float32{1e20, float32(math.Inf(1)), float32(math.NaN())} {
+				NextBeat(time.Unix(10, 0), tempo, 1)
+			}
+		})
+	})
+
+	t.Run("concurrent changes each take a revision", func(t *testing.T) {
+		jam := createTestNode(t).JoinSession("jam", 60, false)
+
+		changes := &sync.WaitGroup{}
+		for i := 0; i < 20; i++ {
+			changes.Add(1)
+			go func(note int) {
+				defer changes.Done()
+				jam.SetNote(note, false)
+			}(40 + i)
+		}
+		changes.Wait()
+
+		if self, _ := jam.NoteStore.LastRevision(jam.NoteStore.SelfId()); self.Revision != 20 {
+			t.Errorf("expected 20 revisions, got %d", self.Revision)
+		}
+	})
+
+	t.Run("SetNoteOnBeat", func(t *testing.T) {
+		node1 := createTestNode(t)
+		node2 := createTestNode(t)
+		jam1 := node1.JoinSession("jam", 60, false)
+		jam2 := node2.JoinSession("jam", 62, false)
+		jam2.ConnectToHost(node1)
+		waitFor(t, func() bool {
+			jam1.Notify()
+			return jam2.NoteStore.ActiveNotes() == 2
+		})
+
+		t.Run("changes the note on every node at the same beat", func(t *testing.T) {
+			effective, err := jam2.SetNoteOnBeat(64, false, 4)
+			if err != nil {
+				t.Fatal(err)
+			}
+			waitFor(t, func() bool {
+				jam2.Notify()
+				note, _ := jam1.NoteStore.LastRevision(jam2.NoteStore.SelfId())
+				return note.Note == 64
+			})
+			before := jam1.NoteStore.ActiveNoteNumbersAt(effective.Add(-time.Nanosecond))
+			after := jam1.NoteStore.ActiveNoteNumbersAt(effective)
+			if !reflect.DeepEqual(before, []int{60, 62}) || !reflect.DeepEqual(after, []int{60, 64}) {
+				t.Errorf("expected the change at %v, got %v before and %v after", effective, before, after)
+			}
+		})
+
+		t.Run("rejects invalid quantums", func(t *testing.T) {
+			if _, err := jam2.SetNoteOnBeat(64, false, 0); err == nil {
+				t.Error("scheduled a note with no quantum")
+			}
+			if _, err := jam2.SetNoteOnBeat(64, false, 1000); err == nil {
+				t.Error("scheduled a note peers would reject")
+			}
+		})
+	})
+}
@@ -0,0 +1,203 @@
+package loopnet
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	p2p "github.com/acruikshank/loopnet/pb"
+)
+
+// sessionAccess decides whose notes a store accepts: the nodes admitted
+// to a private session and the conductor commands issued to it. It is
+// shared by the note store implementations and locked on its own, so
+// that checking a note never waits for other notes to be stored.
+type sessionAccess struct {
+	selfId          string
+	admissions      map[string]admission    // nil when notes from any node are accepted
+	commands        map[string]*p2p.Command // latest conductor command of each kind
+	commandRevision uint32                  // highest command revision seen
+	accessMux       *sync.RWMutex
+}
+
+func newSessionAccess(selfId string) *sessionAccess {
+	return &sessionAccess{
+		selfId:    selfId,
+		commands:  make(map[string]*p2p.Command),
+		accessMux: &sync.RWMutex{},
+	}
+}
+
+// SelfId returns the id of the local node.
+func (sa *sessionAccess) SelfId() string {
+	return sa.selfId
+}
+
+// RequireAdmission restricts the store to notes from admitted nodes.
+// The session owner and this node are always admitted.
+func (sa *sessionAccess) RequireAdmission(owner string) {
+	sa.accessMux.Lock()
+	defer sa.accessMux.Unlock()
+
+	sa.admissions = make(map[string]admission)
+	sa.admissions[owner] = admission{}
+}
+
+// RequiresAdmission returns whether the store only accepts notes from admitted nodes.
+func (sa *sessionAccess) RequiresAdmission() bool {
+	sa.accessMux.RLock()
+	defer sa.accessMux.RUnlock()
+
+	return sa.admissions != nil
+}
+
+// Admit accepts notes from a node until the admission expires.
+// proof is the admission the node presented and is passed on to other
+// nodes along with its notes.
+func (sa *sessionAccess) Admit(nodeId string, expires time.Time, proof *p2p.Admission) {
+	sa.accessMux.Lock()
+	defer sa.accessMux.Unlock()
+
+	if sa.admissions == nil {
+		return
+	}
+
+	// keep the longest lasting admission
+	existing, found := sa.admissions[nodeId]
+	if found && (existing.expires.IsZero() || existing.expires.After(expires)) {
+		return
+	}
+	sa.admissions[nodeId] = admission{expires: expires, proof: proof}
+}
+
+// Admitted returns whether notes from a node are currently accepted.
+func (sa *sessionAccess) Admitted(nodeId string) bool {
+	sa.accessMux.RLock()
+	defer sa.accessMux.RUnlock()
+
+	return sa.admittedLocked(nodeId)
+}
+
+// Admission returns the proof of admission a node presented, if any.
+func (sa *sessionAccess) Admission(nodeId string) (*p2p.Admission, bool) {
+	sa.accessMux.RLock()
+	defer sa.accessMux.RUnlock()
+
+	if !sa.admittedLocked(nodeId) {
+		return nil, false
+	}
+	proof := sa.admissions[nodeId].proof
+	return proof, proof != nil
+}
+
+// callers must hold accessMux
+func (sa *sessionAccess) admittedLocked(nodeId string) bool {
+	if sa.admissions == nil || nodeId == sa.selfId {
+		return true
+	}
+	admission, found := sa.admissions[nodeId]
+	if !found {
+		return false
+	}
+	return admission.expires.IsZero() || time.Now().Before(admission.expires)
+}
+
+// accepts returns whether notes from a node may be stored: nodes that were
+// never invited to a private session or were kicked are ignored.
+func (sa *sessionAccess) accepts(nodeId string) bool {
+	sa.accessMux.RLock()
+	defer sa.accessMux.RUnlock()
+
+	return sa.admittedLocked(nodeId) && !sa.kickedLocked(nodeId)
+}
+
+// onCommand stores a conductor command if it is newer than the stored
+// command of the same kind and its revision is within reach of those seen.
+// Commands are ordered by revision, then by conductor id.
+func (sa *sessionAccess) onCommand(command p2p.Command) bool {
+	sa.accessMux.Lock()
+	defer sa.accessMux.Unlock()
+
+	if !revisionInBounds(command.Revision, sa.commandRevision) {
+		return false
+	}
+	if command.Revision > sa.commandRevision {
+		sa.commandRevision = command.Revision
+	}
+
+	key := commandKey(&command)
+	existing, found := sa.commands[key]
+	if found && !commandBefore(existing, &command) {
+		return false
+	}
+	sa.commands[key] = &command
+	return true
+}
+
+// Commands returns the latest command of each kind, oldest first.
+func (sa *sessionAccess) Commands() []*p2p.Command {
+	sa.accessMux.RLock()
+	defer sa.accessMux.RUnlock()
+
+	commands := make([]*p2p.Command, 0, len(sa.commands))
+	for _, command := range sa.commands {
+		commands = append(commands, command)
+	}
+	sort.Slice(commands, func(i, j int) bool {
+		return commandBefore(commands[i], commands[j])
+	})
+	return commands
+}
+
+// NextCommandRevision returns the revision for a new command so that
+// it supersedes every command seen so far.
+func (sa *sessionAccess) NextCommandRevision() uint32 {
+	sa.accessMux.RLock()
+	defer sa.accessMux.RUnlock()
+
+	return sa.commandRevision + 1
+}
+
+// Controls returns the session state set by conductor commands.
+func (sa *sessionAccess) Controls() Controls {
+	sa.accessMux.RLock()
+	defer sa.accessMux.RUnlock()
+
+	controls := Controls{}
+	if mute, found := sa.commands[commandKey(&p2p.Command{Type: p2p.Command_MUTE_ALL})]; found {
+		controls.Muted = mute.Type == p2p.Command_MUTE_ALL
+	}
+	if tempo, found := sa.commands[commandKey(&p2p.Command{Type: p2p.Command_SET_TEMPO})]; found {
+		controls.Tempo = tempo.Tempo
+	}
+	if scale, found := sa.commands[commandKey(&p2p.Command{Type: p2p.Command_SET_SCALE})]; found {
+		controls.Scale = scale.Scale
+	}
+	return controls
+}
+
+// callers must hold accessMux
+func (sa *sessionAccess) kickedLocked(nodeId string) bool {
+	_, kicked := sa.commands[commandKey(&p2p.Command{Type: p2p.Command_KICK, Target: nodeId})]
+	return kicked && nodeId != sa.selfId
+}
+
+// commands of the same kind supersede each other
+func commandKey(command *p2p.Command) string {
+	switch command.Type {
+	case p2p.Command_MUTE_ALL, p2p.Command_UNMUTE_ALL:
+		return "mute"
+	case p2p.Command_KICK:
+		return "kick/" + command.Target
+	default:
+		return command.Type.String()
+	}
+}
+
+// order commands by revision, breaking ties by conductor id
+func commandBefore(a, b *p2p.Command) bool {
+	if a.Revision != b.Revision {
+		return a.Revision < b.Revision
+	}
+	return a.NodeId < b.NodeId
+}
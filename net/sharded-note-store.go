@@ -0,0 +1,467 @@
+package loopnet
+
+import (
+	"hash/fnv"
+	"log/slog"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	p2p "github.com/acruikshank/loopnet/pb"
+)
+
+// shards of a ShardedNoteStore when none are given
+const defaultNoteShards = 32
+
+// ShardedNoteStore keeps the notes of a session in shards locked
+// independently, chosen by node id, so that notes from different nodes
+// are stored in parallel and readers of one node's note never wait for
+// another's. The nodes sampled for gossip are read from a copy-on-write
+// list of ids, and the pitches played from the same lock-free snapshot
+// as NoteStore. Only changes to the pitches are serialized.
+type ShardedNoteStore struct {
+	*sessionAccess
+	shards            []*noteShard
+	referenceRevision uint32       // read and advanced atomically
+	ids               atomic.Value // []string of stored notes in no particular order, for sampling
+	positions         map[string]int
+	idsMux            *sync.Mutex // serializes changes to ids and positions
+	index             *pitchIndex
+	indexMux          *sync.Mutex
+	clock             Clock
+	logger            *slog.Logger
+	settingsMux       *sync.RWMutex // guards clock and logger
+	metrics           *StoreMetrics
+	random            Random
+	randomMux         *sync.Mutex
+}
+
+// the notes of the nodes whose ids hash to a shard
+type noteShard struct {
+	notes    map[string]Note
+	shardMux *sync.RWMutex
+}
+
+// NewShardedNoteStore creates a store spreading notes over shards with
+// the initial revision of the local node's note. shards < 1 uses a default.
+func NewShardedNoteStore(self *p2p.NoteData, shards int) *ShardedNoteStore {
+	if shards < 1 {
+		shards = defaultNoteShards
+	}
+
+	n := &ShardedNoteStore{
+		sessionAccess: newSessionAccess(self.NodeId),
+		shards:        make([]*noteShard, shards),
+		positions:     make(map[string]int),
+		idsMux:        &sync.Mutex{},
+		index:         newPitchIndex(),
+		indexMux:      &sync.Mutex{},
+		clock:         localClock{},
+		logger:        NewLogging(nil).Logger(logStore),
+		settingsMux:   &sync.RWMutex{},
+		metrics:       &StoreMetrics{},
+		random:        cryptoRandom{},
+		randomMux:     &sync.Mutex{},
+	}
+	for i := range n.shards {
+		n.shards[i] = &noteShard{notes: make(map[string]Note), shardMux: &sync.RWMutex{}}
+	}
+	n.ids.Store([]string{})
+
+	note := Note{
+		revision: 0,
+		NoteData: self,
+		origin:   n.clock.Now().UnixNano(),
+	}
+	n.shardOf(self.NodeId).notes[self.NodeId] = note
+	n.addId(self.NodeId)
+	n.index.add(note, n.clock.Now())
+	n.index.publish(false)
+	return n
+}
+
+// OnNote takes a note from a node and adds it to the store if it represents a new
+// note or if its revision is higher than the revision currently stored.
+func (ns *ShardedNoteStore) OnNote(note p2p.NoteData) bool {
+	origin := int64(0)
+	if note.NodeId == ns.selfId {
+		origin = ns.now().UnixNano()
+	}
+	_, added := ns.onNote(note, 0, origin)
+	return added
+}
+
+// OnEnvelope takes a note relayed by gossip and stores it like OnNote,
+// remembering its hop count and origin to relay it further. It returns
+// whether the note was stored and whether its node is new to the store.
+func (ns *ShardedNoteStore) OnEnvelope(envelope p2p.NoteEnvelope) (stored bool, added bool) {
+	return ns.onNote(*envelope.Note, envelope.Hops, envelope.Origin)
+}
+
+func (ns *ShardedNoteStore) onNote(note p2p.NoteData, hops uint32, origin int64) (stored bool, added bool) {
+	shard := ns.shardOf(note.NodeId)
+	shard.shardMux.Lock()
+	defer shard.shardMux.Unlock()
+
+	if !ns.accepts(note.NodeId) {
+		return false, false
+	}
+
+	logger := ns.getLogger()
+	existingNote, found := shard.notes[note.NodeId]
+	if found {
+		// ignore stale information
+		if existingNote.Revision >= note.Revision {
+			ns.metrics.NotesStale.Inc()
+			logger.Debug("ignored stale note", "author", note.NodeId, "revision", note.Revision, "stored", existingNote.Revision)
+			return false, false
+		}
+
+		// start a new referenceRevision round if this node is up-to-date,
+		// unless another node just started one
+		reference := atomic.LoadUint32(&ns.referenceRevision)
+		if existingNote.revision >= reference {
+			atomic.CompareAndSwapUint32(&ns.referenceRevision, reference, reference+1)
+		}
+	}
+
+	// add or update node, keeping revisions still needed until this one takes effect
+	updated := Note{
+		revision: atomic.LoadUint32(&ns.referenceRevision),
+		NoteData: &note,
+		hops:     hops,
+		origin:   origin,
+	}
+	now := ns.now()
+	if !takesEffect(&note, now) {
+		updated.earlier = pendingStates(existingNote, now)
+	}
+	shard.notes[note.NodeId] = updated
+	if !found {
+		ns.addId(note.NodeId)
+	}
+
+	// the shard stays locked so that revisions of a node are indexed in order
+	ns.indexMux.Lock()
+	if found {
+		ns.index.remove(existingNote)
+	}
+	ns.index.settle(now)
+	ns.index.add(updated, now)
+	ns.index.publish(ns.Controls().Muted)
+	ns.indexMux.Unlock()
+
+	ns.metrics.NotesAccepted.Inc()
+	logger.Debug("stored note", "author", note.NodeId, "revision", note.Revision, "note", note.Note, "mute", note.Mute, "hops", hops)
+
+	return true, !found
+}
+
+// Stale returns whether a note is not newer than the stored revision of
+// its node, so that OnNote would ignore it.
+func (ns *ShardedNoteStore) Stale(note *p2p.NoteData) bool {
+	existingNote, found := ns.note(note.NodeId)
+	return found && existingNote.Revision >= note.Revision
+}
+
+// LastRevision takes a node id and returns whether the note
+// is currently being stored and its note message if so.
+func (ns *ShardedNoteStore) LastRevision(nodeId string) (p2p.NoteData, bool) {
+	note, ok := ns.note(nodeId)
+	if !ok {
+		return p2p.NoteData{}, ok
+	}
+	return *note.NoteData, ok
+}
+
+// the stored note of a node
+func (ns *ShardedNoteStore) note(nodeId string) (Note, bool) {
+	shard := ns.shardOf(nodeId)
+	shard.shardMux.RLock()
+	defer shard.shardMux.RUnlock()
+
+	note, found := shard.notes[nodeId]
+	return note, found
+}
+
+// every note of a node hashes to the same shard
+func (ns *ShardedNoteStore) shardOf(nodeId string) *noteShard {
+	h := fnv.New32a()
+	h.Write([]byte(nodeId))
+	return ns.shards[h.Sum32()%uint32(len(ns.shards))]
+}
+
+// ReferenceRevision returns the revision round notes are aged against.
+func (ns *ShardedNoteStore) ReferenceRevision() uint32 {
+	return atomic.LoadUint32(&ns.referenceRevision)
+}
+
+// ActiveNotes returns the number of currently stored notes.
+func (ns *ShardedNoteStore) ActiveNotes() int {
+	return len(ns.ids.Load().([]string))
+}
+
+// Notes returns the latest revision of every stored note, sorted by node id.
+func (ns *ShardedNoteStore) Notes() []p2p.NoteData {
+	notes := make([]p2p.NoteData, 0)
+	ns.eachNote(func(note Note) {
+		notes = append(notes, *note.NoteData)
+	})
+	sort.Slice(notes, func(i, j int) bool {
+		return notes[i].NodeId < notes[j].NodeId
+	})
+	return notes
+}
+
+// Statuses returns every stored note with its lag behind the reference
+// revision, sorted by node id.
+func (ns *ShardedNoteStore) Statuses() []NoteStatus {
+	reference := ns.ReferenceRevision()
+	statuses := make([]NoteStatus, 0)
+	ns.eachNote(func(note Note) {
+		statuses = append(statuses, NoteStatus{NoteData: *note.NoteData, Lag: reference - note.revision})
+	})
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].NodeId < statuses[j].NodeId
+	})
+	return statuses
+}
+
+// visit every stored note one shard at a time
+func (ns *ShardedNoteStore) eachNote(visit func(Note)) {
+	for _, shard := range ns.shards {
+		shard.shardMux.RLock()
+		for _, note := range shard.notes {
+			visit(note)
+		}
+		shard.shardMux.RUnlock()
+	}
+}
+
+// ClearDeadNotes removes any note that has failed to update within
+// deadNoteRevisions reference revisions, one shard at a time.
+func (ns *ShardedNoteStore) ClearDeadNotes() {
+	cleared := 0
+	for _, shard := range ns.shards {
+		shard.shardMux.Lock()
+		reference := atomic.LoadUint32(&ns.referenceRevision)
+		for nodeId, note := range shard.notes {
+			if reference-note.revision > deadNoteRevisions {
+				ns.removeNoteLocked(shard, nodeId)
+				cleared++
+			}
+		}
+		shard.shardMux.Unlock()
+	}
+
+	if cleared > 0 {
+		ns.metrics.DeadNotesCleared.Add(uint64(cleared))
+		ns.getLogger().Debug("cleared dead notes", "count", cleared, "referenceRevision", ns.ReferenceRevision())
+	}
+
+	ns.indexMux.Lock()
+	defer ns.indexMux.Unlock()
+
+	ns.index.settle(ns.now())
+	ns.index.publish(ns.Controls().Muted)
+}
+
+// OnCommand takes a conductor command and stores it if it is newer than the
+// stored command of the same kind. Kicked nodes are removed from the store.
+// The command must already be authenticated.
+func (ns *ShardedNoteStore) OnCommand(command p2p.Command) bool {
+	// never remove our own note, other nodes will ignore it
+	if command.Type == p2p.Command_KICK && command.Target != ns.selfId {
+		// the target's shard stays locked from recording the kick to removing
+		// the note, so a note accepted before the kick can't be stored after
+		shard := ns.shardOf(command.Target)
+		shard.shardMux.Lock()
+		stored := ns.onCommand(command)
+		if stored {
+			ns.removeNoteLocked(shard, command.Target)
+		}
+		shard.shardMux.Unlock()
+		if !stored {
+			return false
+		}
+	} else if !ns.onCommand(command) {
+		return false
+	}
+
+	ns.indexMux.Lock()
+	defer ns.indexMux.Unlock()
+
+	ns.index.publish(ns.Controls().Muted)
+	return true
+}
+
+// callers must hold the shard's shardMux
+func (ns *ShardedNoteStore) removeNoteLocked(shard *noteShard, nodeId string) {
+	note, found := shard.notes[nodeId]
+	if !found {
+		return
+	}
+
+	delete(shard.notes, nodeId)
+	ns.removeId(nodeId)
+
+	ns.indexMux.Lock()
+	defer ns.indexMux.Unlock()
+
+	ns.index.remove(note)
+	ns.index.publish(ns.Controls().Muted)
+}
+
+// appends an id. Readers hold shorter slices, so appending in place never
+// changes what they see.
+func (ns *ShardedNoteStore) addId(nodeId string) {
+	ns.idsMux.Lock()
+	defer ns.idsMux.Unlock()
+
+	ids := ns.ids.Load().([]string)
+	ns.positions[nodeId] = len(ids)
+	ns.ids.Store(append(ids, nodeId))
+}
+
+// replaces ids with a copy moving the last id into the removed id's place
+func (ns *ShardedNoteStore) removeId(nodeId string) {
+	ns.idsMux.Lock()
+	defer ns.idsMux.Unlock()
+
+	position, found := ns.positions[nodeId]
+	if !found {
+		return
+	}
+
+	ids := append([]string{}, ns.ids.Load().([]string)...)
+	last := ids[len(ids)-1]
+	ids[position] = last
+	ns.positions[last] = position
+	delete(ns.positions, nodeId)
+	ns.ids.Store(ids[:len(ids)-1])
+}
+
+// RandomNotes returns a slice of notes chosen randomly from active notes.
+// If count exceeds the number available, only the number available will
+// be returned. If excludeSelf is true, the note for this node will never
+// be included.
+func (ns *ShardedNoteStore) RandomNotes(count int, excludeSelf bool) []*p2p.NoteData {
+	out := make([]*p2p.NoteData, 0)
+	for _, note := range ns.randomNotes(count, excludeSelf) {
+		out = append(out, note.NoteData)
+	}
+	return out
+}
+
+// RandomEnvelopes returns notes chosen randomly like RandomNotes, wrapped
+// for relaying with their hop count incremented and their origin.
+func (ns *ShardedNoteStore) RandomEnvelopes(count int, excludeSelf bool) []*p2p.NoteEnvelope {
+	out := make([]*p2p.NoteEnvelope, 0)
+	for _, note := range ns.randomNotes(count, excludeSelf) {
+		out = append(out, note.envelope())
+	}
+	return out
+}
+
+// Envelope returns the stored note of a node wrapped for relaying like
+// RandomEnvelopes.
+func (ns *ShardedNoteStore) Envelope(nodeId string) (*p2p.NoteEnvelope, bool) {
+	note, found := ns.note(nodeId)
+	if !found {
+		return nil, false
+	}
+	return note.envelope(), true
+}
+
+// Picks notes from a snapshot of ids like NoteStore, skipping self and any
+// note removed since the snapshot was taken.
+func (ns *ShardedNoteStore) randomNotes(count int, excludeSelf bool) []Note {
+	ids := ns.ids.Load().([]string)
+	n := len(ids)
+	swapped := make(map[int]int, count+1)
+
+	position := func(i int) int {
+		if j, found := swapped[i]; found {
+			return j
+		}
+		return i
+	}
+
+	ns.randomMux.Lock()
+	defer ns.randomMux.Unlock()
+
+	out := make([]Note, 0, count)
+	for i := 0; i < n && len(out) < count; i++ {
+		j := i + ns.random.Intn(n-i)
+		picked := position(j)
+		swapped[j] = position(i)
+
+		if excludeSelf && ids[picked] == ns.selfId {
+			continue
+		}
+		if note, found := ns.note(ids[picked]); found {
+			out = append(out, note)
+		}
+	}
+	return out
+}
+
+// ActiveNoteNumbers returns a sorted list of the midi note numbers of all
+// stored notes in effect that are not muted. The returned slice must not
+// be modified.
+func (ns *ShardedNoteStore) ActiveNoteNumbers() []int {
+	return ns.ActiveNoteNumbersAt(ns.now())
+}
+
+// ActiveNoteNumbersAt returns the sorted midi note numbers of the notes
+// that are in effect and not muted at a time no earlier than the last
+// change to the store, without locking. The returned slice must not be
+// modified.
+func (ns *ShardedNoteStore) ActiveNoteNumbersAt(t time.Time) []int {
+	return ns.index.pitchesAt(t)
+}
+
+// Metrics returns the counts of changes to the store.
+func (ns *ShardedNoteStore) Metrics() *StoreMetrics {
+	return ns.metrics
+}
+
+// SetRandom sets the source of randomness picking notes to gossip.
+func (ns *ShardedNoteStore) SetRandom(random Random) {
+	ns.randomMux.Lock()
+	defer ns.randomMux.Unlock()
+
+	ns.random = random
+}
+
+// SetLogger sets the logger of the store.
+func (ns *ShardedNoteStore) SetLogger(logger *slog.Logger) {
+	ns.settingsMux.Lock()
+	defer ns.settingsMux.Unlock()
+
+	ns.logger = logger
+}
+
+// SetClock sets the clock deciding when scheduled notes take effect.
+func (ns *ShardedNoteStore) SetClock(clock Clock) {
+	ns.settingsMux.Lock()
+	defer ns.settingsMux.Unlock()
+
+	ns.clock = clock
+}
+
+func (ns *ShardedNoteStore) now() time.Time {
+	ns.settingsMux.RLock()
+	clock := ns.clock
+	ns.settingsMux.RUnlock()
+
+	return clock.Now()
+}
+
+func (ns *ShardedNoteStore) getLogger() *slog.Logger {
+	ns.settingsMux.RLock()
+	defer ns.settingsMux.RUnlock()
+
+	return ns.logger
+}
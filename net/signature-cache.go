@@ -0,0 +1,94 @@
+package loopnet
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"sync"
+
+	p2p "github.com/acruikshank/loopnet/pb"
+	"github.com/gogo/protobuf/proto"
+)
+
+// verified notes remembered by each node
+const signatureCacheSize = 4096
+
+// key of a verified note: its author, revision and a hash of its signature
+type signatureKey struct {
+	nodeId   string
+	revision uint32
+	sign     [sha256.Size]byte
+}
+
+type signatureEntry struct {
+	key  signatureKey
+	note *p2p.NoteData // copy of the verified note
+}
+
+// signatureCache remembers the most recently verified notes so that copies
+// gossiped again are not verified again. Safe for concurrent use.
+type signatureCache struct {
+	size     int
+	entries  map[signatureKey]*list.Element
+	order    *list.List // most recently used first
+	hits     Counter
+	misses   Counter
+	cacheMux *sync.Mutex
+}
+
+func newSignatureCache(size int) *signatureCache {
+	return &signatureCache{
+		size:     size,
+		entries:  make(map[signatureKey]*list.Element),
+		order:    list.New(),
+		cacheMux: &sync.Mutex{},
+	}
+}
+
+// verified returns whether an identical note was verified before. The whole
+// note is compared since its signature could be copied onto altered data.
+func (c *signatureCache) verified(note *p2p.NoteData) bool {
+	c.cacheMux.Lock()
+	defer c.cacheMux.Unlock()
+
+	element, found := c.entries[newSignatureKey(note)]
+	if !found || !proto.Equal(element.Value.(*signatureEntry).note, note) {
+		c.misses.Inc()
+		return false
+	}
+	c.order.MoveToFront(element)
+	c.hits.Inc()
+	return true
+}
+
+// add a verified note, evicting the least recently used if full
+func (c *signatureCache) add(note *p2p.NoteData) {
+	c.cacheMux.Lock()
+	defer c.cacheMux.Unlock()
+
+	key := newSignatureKey(note)
+	verified := *note
+	if element, found := c.entries[key]; found {
+		element.Value.(*signatureEntry).note = &verified
+		c.order.MoveToFront(element)
+		return
+	}
+
+	c.entries[key] = c.order.PushFront(&signatureEntry{key: key, note: &verified})
+	if c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*signatureEntry).key)
+	}
+}
+
+// the number of notes remembered
+func (c *signatureCache) count() int {
+	c.cacheMux.Lock()
+	defer c.cacheMux.Unlock()
+
+	return c.order.Len()
+}
+
+func newSignatureKey(note *p2p.NoteData) signatureKey {
+	return signatureKey{nodeId: note.NodeId, revision: note.Revision, sign: sha256.Sum256(note.Sign)}
+}
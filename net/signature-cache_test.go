@@ -0,0 +1,148 @@
+package loopnet
+
+import (
+	"testing"
+
+	p2p "github.com/acruikshank/loopnet/pb"
+)
+
+func TestSignatureCache(t *testing.T) {
+	node := createTestNode(t)
+	author := createTestNode(t)
+
+	t.Run("remembers verified notes", func(t *testing.T) {
+		note := author.NewNoteData("jam", 1, 60, false)
+		if !node.authenticateNote(note) {
+			t.Fatal("failed to authenticate note")
+		}
+
+		misses := node.signatures.misses.Value()
+		if !node.authenticateNote(note) {
+			t.Error("failed to authenticate note again")
+		}
+		if node.signatures.misses.Value() != misses {
+			t.Error("verified a note twice")
+		}
+	})
+
+	t.Run("rejects altered copies of a verified note", func(t *testing.T) {
+		note := author.NewNoteData("jam", 2, 60, false)
+		if !node.authenticateNote(note) {
+			t.Fatal("failed to authenticate note")
+		}
+
+		altered := *note
+		altered.Note = 72
+		if node.authenticateNote(&altered) {
+			t.Error("authenticated a note with a copied signature")
+		}
+	})
+
+	t.Run("does not remember notes failing verification", func(t *testing.T) {
+		note := author.NewNoteData("jam", 3, 60, false)
+		note.Mute = true
+		count := node.signatures.count()
+
+		if node.authenticateNote(note) {
+			t.Error("authenticated a note with an invalid signature")
+		}
+		if node.signatures.count() != count {
+			t.Error("remembered a note with an invalid signature")
+		}
+	})
+
+	t.Run("evicts the least recently used note", func(t *testing.T) {
+		cache := newSignatureCache(2)
+		notes := []*p2p.NoteData{
+			createNote("n1", 1, 60, false),
+			createNote("n2", 1, 60, false),
+			createNote("n3", 1, 60, false),
+		}
+		cache.add(notes[0])
+		cache.add(notes[1])
+		cache.verified(notes[0])
+		cache.add(notes[2])
+
+		if !cache.verified(notes[0]) || !cache.verified(notes[2]) {
+			t.Error("evicted a recently used note")
+		}
+		if cache.verified(notes[1]) {
+			t.Error("kept the least recently used note")
+		}
+	})
+
+	t.Run("notes not newer than the stored revision are not verified", func(t *testing.T) {
+		jam := node.JoinSession("jam", 62, false)
+		note := author.NewNoteData("jam", 4, 60, false)
+		jam.onMessage(&p2p.Message{Envelopes: []*p2p.NoteEnvelope{{Note: note}}}, "")
+
+		misses := node.signatures.misses.Value()
+		hits := node.signatures.hits.Value()
+		jam.onMessage(&p2p.Message{Envelopes: []*p2p.NoteEnvelope{{Note: note}}}, "")
+
+		if node.signatures.misses.Value() != misses || node.signatures.hits.Value() != hits {
+			t.Error("authenticated a note already stored")
+		}
+		if jam.Metrics.NotesSkipped.Value() != 1 {
+			t.Errorf("expected 1 note skipped, got %d", jam.Metrics.NotesSkipped.Value())
+		}
+	})
+}
+
+func BenchmarkAuthenticateNote(b *testing.B) {
+	node := createTestNode(b)
+	note := createTestNode(b).NewNoteData("jam", 1, 60, false)
+
+	b.Run("uncached", func(b *testing.B) {
+		node.signatures = newSignatureCache(0)
+		for i := 0; i < b.N; i++ {
+			node.authenticateNote(note)
+		}
+	})
+
+	b.Run("cached", func(b *testing.B) {
+		node.signatures = newSignatureCache(signatureCacheSize)
+		for i := 0; i < b.N; i++ {
+			node.authenticateNote(note)
+		}
+	})
+}
+
+// receive a full notification of notes from other nodes, as a node does
+// many times for every revision gossiped
+func BenchmarkReceiveNotes(b *testing.B) {
+	node := createTestNode(b)
+	message := &p2p.Message{}
+	for i := 0; i < maxNotesPerNotification; i++ {
+		note := createTestNode(b).NewNoteData("jam", 1, 60+i, false)
+		message.Envelopes = append(message.Envelopes, &p2p.NoteEnvelope{Note: note})
+	}
+	jam := node.JoinSession("jam", 60, false)
+	self, _ := jam.NoteStore.LastRevision(jam.NoteStore.SelfId())
+
+	b.Run("new revisions uncached", func(b *testing.B) {
+		node.signatures = newSignatureCache(0)
+		for i := 0; i < b.N; i++ {
+			jam.NoteStore = NewNoteStore(&self)
+			jam.onMessage(message, "")
+		}
+	})
+
+	b.Run("new revisions cached", func(b *testing.B) {
+		node.signatures = newSignatureCache(signatureCacheSize)
+		for i := 0; i < b.N; i++ {
+			jam.NoteStore = NewNoteStore(&self)
+			jam.onMessage(message, "")
+		}
+	})
+
+	b.Run("stored revisions", func(b *testing.B) {
+		node.signatures = newSignatureCache(0)
+		jam.NoteStore = NewNoteStore(&self)
+		jam.onMessage(message, "")
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			jam.onMessage(message, "")
+		}
+	})
+}
@@ -0,0 +1,55 @@
+package loopnet
+
+import (
+	"log/slog"
+	"time"
+
+	p2p "github.com/acruikshank/loopnet/pb"
+)
+
+// Store keeps the latest revision of every note in a session along with
+// the admissions and conductor commands deciding which notes it accepts.
+// NoteStore guards everything with one lock; ShardedNoteStore spreads
+// notes over independently locked shards so that notes from different
+// nodes are stored in parallel.
+type Store interface {
+	// SelfId returns the id of the local node.
+	SelfId() string
+
+	OnNote(note p2p.NoteData) bool
+	OnEnvelope(envelope p2p.NoteEnvelope) (stored bool, added bool)
+	Stale(note *p2p.NoteData) bool
+	LastRevision(nodeId string) (p2p.NoteData, bool)
+	ReferenceRevision() uint32
+	ActiveNotes() int
+	Notes() []p2p.NoteData
+	Statuses() []NoteStatus
+	ClearDeadNotes()
+
+	RandomNotes(count int, excludeSelf bool) []*p2p.NoteData
+	RandomEnvelopes(count int, excludeSelf bool) []*p2p.NoteEnvelope
+	Envelope(nodeId string) (*p2p.NoteEnvelope, bool)
+	ActiveNoteNumbers() []int
+	// ActiveNoteNumbersAt returns the pitches in effect at a time no earlier
+	// than the last change to the store; earlier times may see changes that
+	// have since taken effect.
+	ActiveNoteNumbersAt(t time.Time) []int
+
+	RequireAdmission(owner string)
+	RequiresAdmission() bool
+	Admit(nodeId string, expires time.Time, proof *p2p.Admission)
+	Admitted(nodeId string) bool
+	Admission(nodeId string) (*p2p.Admission, bool)
+
+	OnCommand(command p2p.Command) bool
+	Commands() []*p2p.Command
+	NextCommandRevision() uint32
+	Controls() Controls
+
+	// Metrics returns the counts of changes to the store.
+	Metrics() *StoreMetrics
+
+	SetClock(clock Clock)
+	SetLogger(logger *slog.Logger)
+	SetRandom(random Random)
+}
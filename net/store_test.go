@@ -0,0 +1,246 @@
+package loopnet
+
+import (
+	"fmt"
+	"math/rand"
+	"reflect"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	p2p "github.com/acruikshank/loopnet/pb"
+)
+
+// every Store implementation runs through the same tests
+var stores = []struct {
+	name   string
+	create func(self *p2p.NoteData) Store
+}{
+	{"NoteStore", func(self *p2p.NoteData) Store { return NewNoteStore(self) }},
+	{"ShardedNoteStore", func(self *p2p.NoteData) Store { return NewShardedNoteStore(self, 8) }},
+}
+
+func TestStore(t *testing.T) {
+	for _, impl := range stores {
+		t.Run(impl.name, func(t *testing.T) {
+			t.Run("keeps the latest revision of each node", func(t *testing.T) {
+				store := impl.create(createNote("self", 0, 60, false))
+				store.OnNote(*createNote("n1", 1, 62, false))
+				store.OnNote(*createNote("n1", 3, 64, false))
+				store.OnNote(*createNote("n1", 2, 66, false))
+
+				note, found := store.LastRevision("n1")
+				if !found || note.Revision != 3 {
+					t.Errorf("expected revision 3, got %d", note.Revision)
+				}
+				if !store.Stale(createNote("n1", 3, 64, false)) || store.Stale(createNote("n1", 4, 64, false)) {
+					t.Error("expected only revisions up to 3 to be stale")
+				}
+				if store.ActiveNotes() != 2 || len(store.Notes()) != 2 {
+					t.Errorf("expected 2 notes, got %d", store.ActiveNotes())
+				}
+				if store.Metrics().NotesStale.Value() != 1 {
+					t.Errorf("expected 1 stale note, got %d", store.Metrics().NotesStale.Value())
+				}
+			})
+
+			t.Run("samples notes without repeats", func(t *testing.T) {
+				store := impl.create(createNote("self", 0, 60, false))
+				for _, note := range createNotes(50) {
+					store.OnNote(note)
+				}
+				store.SetRandom(rand.New(rand.NewSource(1)))
+
+				seen := make(map[string]bool)
+				for _, note := range store.RandomNotes(50, true) {
+					if seen[note.NodeId] || note.NodeId == "self" {
+						t.Errorf("unexpected note from %s", note.NodeId)
+					}
+					seen[note.NodeId] = true
+				}
+				if len(seen) != 50 {
+					t.Errorf("expected 50 notes, got %d", len(seen))
+				}
+			})
+
+			t.Run("clears dead notes", func(t *testing.T) {
+				store := impl.create(createNote("self", 0, 60, false))
+				store.OnNote(*createNote("n1", 1, 62, false))
+				for revision := uint32(1); revision <= 30; revision++ {
+					store.OnNote(*createNote("n2", revision, 64, false))
+				}
+				store.ClearDeadNotes()
+
+				if _, found := store.LastRevision("n1"); found {
+					t.Error("expected n1 to be cleared")
+				}
+				if expected := []int{64}; !reflect.DeepEqual(store.ActiveNoteNumbers(), expected) {
+					t.Errorf("expected %v, got %v", expected, store.ActiveNoteNumbers())
+				}
+			})
+
+			t.Run("removes kicked nodes", func(t *testing.T) {
+				store := impl.create(createNote("self", 0, 60, false))
+				store.OnNote(*createNote("n1", 1, 62, false))
+				store.OnCommand(p2p.Command{Type: p2p.Command_KICK, Target: "n1", Revision: 1})
+				store.OnNote(*createNote("n1", 2, 62, false))
+
+				if store.ActiveNotes() != 1 {
+					t.Errorf("expected only self, got %d notes", store.ActiveNotes())
+				}
+				if expected := []int{60}; !reflect.DeepEqual(store.ActiveNoteNumbers(), expected) {
+					t.Errorf("expected %v, got %v", expected, store.ActiveNoteNumbers())
+				}
+			})
+
+			t.Run("only accepts admitted nodes", func(t *testing.T) {
+				store := impl.create(createNote("self", 0, 60, false))
+				store.RequireAdmission("owner")
+				store.OnNote(*createNote("owner", 1, 62, false))
+				store.OnNote(*createNote("n1", 1, 64, false))
+
+				if store.ActiveNotes() != 2 {
+					t.Errorf("expected self and owner, got %d notes", store.ActiveNotes())
+				}
+			})
+		})
+	}
+}
+
+// run with -race: writers storing the revisions of their own nodes in
+// order race readers, dead note clearing and conductor commands
+func TestStoreConcurrency(t *testing.T) {
+	const writers = 16
+	const revisions = 100
+
+	for _, impl := range stores {
+		t.Run(impl.name, func(t *testing.T) {
+			store := impl.create(createNote("self", 0, 60, false))
+
+			wg := &sync.WaitGroup{}
+			for w := 0; w < writers; w++ {
+				wg.Add(1)
+				go func(w int) {
+					defer wg.Done()
+					nodeId := fmt.Sprintf("node%d", w)
+					for revision := uint32(1); revision <= revisions; revision++ {
+						note := createNote(nodeId, revision, 40+revision%40, revision%7 == 0)
+						store.OnEnvelope(p2p.NoteEnvelope{Note: note, Hops: 1, Origin: 1})
+					}
+				}(w)
+			}
+
+			read := func(read func(i int)) {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for i := 0; i < revisions; i++ {
+						read(i)
+					}
+				}()
+			}
+			read(func(i int) { store.RandomEnvelopes(maxNotesPerNotification, true) })
+			read(func(i int) { store.Stale(createNote(fmt.Sprintf("node%d", i%writers), 50, 60, false)) })
+			read(func(i int) { store.Statuses() })
+			read(func(i int) { store.ActiveNoteNumbers() })
+			read(func(i int) { store.ClearDeadNotes() })
+			read(func(i int) {
+				store.OnCommand(p2p.Command{Type: p2p.Command_SET_TEMPO, Tempo: float32(i), Revision: uint32(i)})
+			})
+			wg.Wait()
+
+			// notes cleared while racing are added again by later revisions,
+			// so every remaining note is at its last revision
+			pitches := []int{}
+			for _, note := range store.Notes() {
+				if note.NodeId != "self" && note.Revision != revisions {
+					t.Errorf("expected %s at revision %d, got %d", note.NodeId, revisions, note.Revision)
+				}
+				if !note.Mute {
+					pitches = append(pitches, int(note.Note))
+				}
+			}
+			sort.Ints(pitches)
+
+			if !reflect.DeepEqual(store.ActiveNoteNumbers(), pitches) {
+				t.Errorf("expected pitches %v, got %v", pitches, store.ActiveNoteNumbers())
+			}
+			if store.ActiveNotes() != len(store.Notes()) {
+				t.Errorf("expected %d notes, got %d", len(store.Notes()), store.ActiveNotes())
+			}
+			if store.Metrics().NotesAccepted.Value() != writers*revisions {
+				t.Errorf("expected %d revisions accepted, got %d", writers*revisions, store.Metrics().NotesAccepted.Value())
+			}
+		})
+	}
+}
+
+// run with -race: a node keeps sending notes while it is kicked, and must
+// not be stored once the kick is
+func TestStoreKickRace(t *testing.T) {
+	for _, impl := range stores {
+		t.Run(impl.name, func(t *testing.T) {
+			for round := 0; round < 50; round++ {
+				store := impl.create(createNote("self", 0, 60, false))
+
+				wg := &sync.WaitGroup{}
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for revision := uint32(1); revision <= 20; revision++ {
+						store.OnNote(*createNote("n1", revision, 62, false))
+					}
+				}()
+				store.OnCommand(p2p.Command{Type: p2p.Command_KICK, Target: "n1", Revision: 1})
+				wg.Wait()
+
+				if _, found := store.LastRevision("n1"); found {
+					t.Fatalf("kicked node stored in round %d", round)
+				}
+			}
+		})
+	}
+}
+
+// notification handlers storing notes from many nodes while gossip reads
+// from the store, as a node in a large session does
+func BenchmarkStore(b *testing.B) {
+	mixes := []struct {
+		name   string
+		writes int // in every 10 operations
+	}{
+		{"writes", 10},
+		{"mixed", 5},
+		{"reads", 1},
+	}
+
+	for _, impl := range stores {
+		for _, mix := range mixes {
+			b.Run(fmt.Sprintf("%s/%s", impl.name, mix.name), func(b *testing.B) {
+				store := impl.create(createNote("self", 0, 60, false))
+				for _, note := range createNotes(1000) {
+					store.OnNote(note)
+				}
+
+				authors := int32(0)
+				b.SetParallelism(8)
+				b.ResetTimer()
+				b.RunParallel(func(pb *testing.PB) {
+					nodeId := fmt.Sprintf("node%d", atomic.AddInt32(&authors, 1))
+					revision := uint32(1000)
+					for i := 0; pb.Next(); i++ {
+						if i%10 < mix.writes {
+							revision++
+							store.OnEnvelope(p2p.NoteEnvelope{Note: createNote(nodeId, revision, 60, false)})
+						} else if i%2 == 0 {
+							store.RandomEnvelopes(maxNotesPerNotification, true)
+						} else {
+							store.LastRevision(nodeId)
+						}
+					}
+				})
+			})
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package loopnet
+
+import (
+	"hash/fnv"
+	"sync"
+
+	p2p "github.com/acruikshank/loopnet/pb"
+)
+
+// notes queued for each worker before streams wait for the pool
+const verificationQueueSize = 256
+
+// notes a worker takes from its queue at once
+const verificationBatchSize = 32
+
+// a received note waiting to be verified and stored
+type verification struct {
+	np       *NotificationProtocol
+	envelope *p2p.NoteEnvelope
+	from     string          // id of the peer that relayed the note
+	done     *sync.WaitGroup // released once the note is handled
+}
+
+// verificationPool verifies and stores the notes received by a node on a
+// bounded number of workers. Notes are queued by author, so the notes of
+// one node are handled in the order they arrive, one at a time; notes from
+// different nodes are verified in parallel. Streams submitting notes wait
+// while their worker's queue is full.
+type verificationPool struct {
+	queues  []chan verification
+	stop    chan struct{}
+	closed  bool
+	poolMux *sync.RWMutex
+}
+
+// newVerificationPool starts workers verifying notes.
+func newVerificationPool(workers int) *verificationPool {
+	if workers < 1 {
+		workers = 1
+	}
+
+	p := &verificationPool{
+		queues:  make([]chan verification, workers),
+		stop:    make(chan struct{}),
+		poolMux: &sync.RWMutex{},
+	}
+	for i := range p.queues {
+		p.queues[i] = make(chan verification, verificationQueueSize)
+		go p.work(p.queues[i])
+	}
+	return p
+}
+
+// verify queues a note for the session, waiting while the queue of its
+// author is full. done is released once the note has been handled.
+func (p *verificationPool) verify(np *NotificationProtocol, envelope *p2p.NoteEnvelope, from string, done *sync.WaitGroup) {
+	v := verification{np: np, envelope: envelope, from: from, done: done}
+	done.Add(1)
+
+	p.poolMux.RLock()
+	defer p.poolMux.RUnlock()
+
+	if p.closed {
+		v.run()
+		return
+	}
+	p.queues[p.queueOf(envelope.Note.NodeId)] <- v
+}
+
+// the number of notes waiting to be verified
+func (p *verificationPool) queued() int {
+	queued := 0
+	for _, queue := range p.queues {
+		queued += len(queue)
+	}
+	return queued
+}
+
+// stop the workers once they have handled the notes queued. Notes
+// submitted afterwards are handled by the submitting stream.
+func (p *verificationPool) close() {
+	p.poolMux.Lock()
+	defer p.poolMux.Unlock()
+
+	if p.closed {
+		return
+	}
+	p.closed = true
+	close(p.stop)
+}
+
+// handle queued notes in batches, taking whatever other streams have queued
+// since the last batch
+func (p *verificationPool) work(queue chan verification) {
+	batch := make([]verification, 0, verificationBatchSize)
+	for {
+		select {
+		case <-p.stop:
+			for {
+				select {
+				case v := <-queue:
+					v.run()
+				default:
+					return
+				}
+			}
+		case v := <-queue:
+			batch = append(batch, v)
+		}
+
+	drain:
+		for len(batch) < verificationBatchSize {
+			select {
+			case v := <-queue:
+				batch = append(batch, v)
+			default:
+				break drain
+			}
+		}
+
+		for _, v := range batch {
+			v.run()
+		}
+		batch = batch[:0]
+	}
+}
+
+func (v verification) run() {
+	v.np.onEnvelope(v.envelope, v.from)
+	v.done.Done()
+}
+
+// every note of an author goes through the same queue
+func (p *verificationPool) queueOf(nodeId string) int {
+	h := fnv.New32a()
+	h.Write([]byte(nodeId))
+	return int(h.Sum32() % uint32(len(p.queues)))
+}
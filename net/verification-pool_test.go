@@ -0,0 +1,166 @@
+package loopnet
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	p2p "github.com/acruikshank/loopnet/pb"
+	"github.com/gogo/protobuf/proto"
+	crypto "github.com/libp2p/go-libp2p-crypto"
+	peer "github.com/libp2p/go-libp2p-peer"
+)
+
+func TestVerificationPool(t *testing.T) {
+	node := createTestNode(t)
+	authors := []*Node{createTestNode(t), createTestNode(t), createTestNode(t)}
+
+	t.Run("stores each author's notes in the order they arrive", func(t *testing.T) {
+		jam := node.JoinSession("ordered", 60, false)
+		node.signatures = newSignatureCache(0)
+		defer func() { node.signatures = newSignatureCache(signatureCacheSize) }()
+
+		pool := newVerificationPool(4)
+		defer pool.close()
+
+		// each revision arrives on its own stream, the next one submitted
+		// as soon as the previous one is queued, and every stream waits for
+		// its note concurrently
+		streams := &sync.WaitGroup{}
+		for _, author := range authors {
+			queued := make(chan struct{})
+			close(queued)
+			for revision := 1; revision <= 20; revision++ {
+				next := make(chan struct{})
+				streams.Add(1)
+				go func(note *p2p.NoteData, previous chan struct{}, next chan struct{}) {
+					defer streams.Done()
+					<-previous
+					done := &sync.WaitGroup{}
+					pool.verify(jam, &p2p.NoteEnvelope{Note: note}, "", done)
+					close(next)
+					done.Wait()
+				}(author.NewNoteData("ordered", revision, 60+revision, false), queued, next)
+				queued = next
+			}
+		}
+		streams.Wait()
+
+		if accepted := jam.NoteStore.Metrics().NotesAccepted.Value(); accepted != 60 {
+			t.Errorf("expected every revision to be accepted, got %d", accepted)
+		}
+		if skipped := jam.Metrics.NotesSkipped.Value(); skipped != 0 {
+			t.Errorf("expected no revision to arrive out of order, got %d", skipped)
+		}
+	})
+
+	t.Run("handles every note of a notification before returning", func(t *testing.T) {
+		jam := node.JoinSession("batch", 60, false)
+		message := &p2p.Message{}
+		for _, author := range authors {
+			message.Envelopes = append(message.Envelopes, &p2p.NoteEnvelope{Note: author.NewNoteData("batch", 1, 62, false)})
+		}
+
+		jam.onMessage(message, "")
+		if jam.NoteStore.ActiveNotes() != 4 {
+			t.Errorf("expected 4 notes, got %d", jam.NoteStore.ActiveNotes())
+		}
+	})
+
+	t.Run("handles notes on the submitting stream once closed", func(t *testing.T) {
+		pool := newVerificationPool(2)
+		pool.close()
+
+		jam := node.JoinSession("closed", 60, false)
+		done := &sync.WaitGroup{}
+		pool.verify(jam, &p2p.NoteEnvelope{Note: authors[0].NewNoteData("closed", 1, 62, false)}, "", done)
+		done.Wait()
+
+		if jam.NoteStore.ActiveNotes() != 2 {
+			t.Errorf("expected 2 notes, got %d", jam.NoteStore.ActiveNotes())
+		}
+	})
+}
+
+// receive a note from each of 1,000 nodes in notifications of 10 notes
+// over many concurrent streams, verifying every signature
+func BenchmarkVerificationPool(b *testing.B) {
+	notes := signedTestNotes(b, "jam", 1000)
+	messages := make([]*p2p.Message, 0)
+	for i := 0; i < len(notes); i += maxNotesPerNotification {
+		message := &p2p.Message{}
+		for _, note := range notes[i : i+maxNotesPerNotification] {
+			message.Envelopes = append(message.Envelopes, &p2p.NoteEnvelope{Note: note})
+		}
+		messages = append(messages, message)
+	}
+
+	for _, workers := range []int{1, 4, 16} {
+		b.Run(fmt.Sprintf("%d workers", workers), func(b *testing.B) {
+			node := createTestNode(b)
+			node.verifier.close()
+			node.verifier = newVerificationPool(workers)
+			node.signatures = newSignatureCache(0)
+			jam := node.JoinSession("jam", 60, false)
+			self, _ := jam.NoteStore.LastRevision(jam.NoteStore.SelfId())
+
+			for i := 0; i < b.N; i++ {
+				jam.NoteStore = NewNoteStore(&self)
+
+				streams := &sync.WaitGroup{}
+				for _, message := range messages {
+					streams.Add(1)
+					go func(message *p2p.Message) {
+						defer streams.Done()
+						jam.onMessage(message, "")
+					}(message)
+				}
+				streams.Wait()
+
+				if jam.NoteStore.ActiveNotes() != len(notes)+1 {
+					b.Fatalf("expected %d notes, got %d", len(notes)+1, jam.NoteStore.ActiveNotes())
+				}
+			}
+		})
+	}
+}
+
+// notes signed by count different keys, without starting a node for each
+func signedTestNotes(b testing.TB, session string, count int) []*p2p.NoteData {
+	notes := make([]*p2p.NoteData, 0, count)
+	for i := 0; i < count; i++ {
+		priv, pub, err := crypto.GenerateKeyPair(crypto.Secp256k1, 256)
+		if err != nil {
+			b.Fatal(err)
+		}
+		id, err := peer.IDFromPublicKey(pub)
+		if err != nil {
+			b.Fatal(err)
+		}
+		pubKey, err := pub.Bytes()
+		if err != nil {
+			b.Fatal(err)
+		}
+
+		note := &p2p.NoteData{
+			ClientVersion: clientVersion,
+			Revision:      1,
+			Note:          uint32(48 + i%48),
+			NodeId:        peer.IDB58Encode(id),
+			Address:       "/ip4/127.0.0.1/tcp/1",
+			NodePubKey:    pubKey,
+			Sign:          make([]byte, 0),
+			Session:       session,
+		}
+		data, err := proto.Marshal(note)
+		if err != nil {
+			b.Fatal(err)
+		}
+		note.Sign, err = priv.Sign(data)
+		if err != nil {
+			b.Fatal(err)
+		}
+		notes = append(notes, note)
+	}
+	return notes
+}
@@ -0,0 +1,192 @@
+package loopnet
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// A minimal server side WebSocket (RFC 6455) for streaming text messages to
+// browsers. Messages from the client are read and dropped, except for close.
+
+// appended to the client key to accept a connection
+const websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// frame opcodes
+const (
+	websocketText  = 0x1
+	websocketClose = 0x8
+	websocketPing  = 0x9
+	websocketPong  = 0xa
+)
+
+// largest frame accepted from clients
+const websocketMaxFrame = 1 << 16
+
+// websocketConn is an accepted WebSocket connection.
+type websocketConn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+// accept the WebSocket handshake of a request
+func upgradeWebsocket(w http.ResponseWriter, r *http.Request) (*websocketConn, error) {
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if r.Method != http.MethodGet || key == "" ||
+		!strings.EqualFold(r.Header.Get("Upgrade"), "websocket") ||
+		!headerContains(r.Header, "Connection", "upgrade") {
+		http.Error(w, "expected a WebSocket upgrade", http.StatusBadRequest)
+		return nil, errors.New("not a WebSocket upgrade")
+	}
+	if !sameOrigin(r) {
+		http.Error(w, "cross-origin WebSocket upgrade", http.StatusForbidden)
+		return nil, errors.New("cross-origin WebSocket upgrade")
+	}
+
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "WebSocket not supported", http.StatusInternalServerError)
+		return nil, errors.New("response can't be hijacked")
+	}
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		return nil, err
+	}
+
+	accept := sha1.Sum([]byte(key + websocketGUID))
+	rw.WriteString("HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + base64.StdEncoding.EncodeToString(accept[:]) + "\r\n\r\n")
+	if err := rw.Flush(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &websocketConn{conn: conn, reader: rw.Reader}, nil
+}
+
+// sameOrigin reports whether a request comes from a page served by this
+// host. Browsers send the Origin of every WebSocket upgrade, so a page on
+// another site can't read the feed; clients without an Origin aren't
+// browsers and are allowed.
+func sameOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(u.Host, r.Host)
+}
+
+// WriteText sends a text message in a single unmasked frame.
+func (ws *websocketConn) WriteText(data []byte) error {
+	return ws.writeFrame(websocketText, data)
+}
+
+func (ws *websocketConn) writeFrame(opcode byte, data []byte) error {
+	header := []byte{0x80 | opcode} // final frame
+	switch {
+	case len(data) < 126:
+		header = append(header, byte(len(data)))
+	case len(data) <= 0xffff:
+		header = append(header, 126, 0, 0)
+		binary.BigEndian.PutUint16(header[2:], uint16(len(data)))
+	default:
+		header = append(header, 127, 0, 0, 0, 0, 0, 0, 0, 0)
+		binary.BigEndian.PutUint64(header[2:], uint64(len(data)))
+	}
+
+	if _, err := ws.conn.Write(append(header, data...)); err != nil {
+		return err
+	}
+	return nil
+}
+
+// ReadUntilClose reads and drops client messages, answering pings, until
+// the client closes the connection or it fails.
+func (ws *websocketConn) ReadUntilClose() error {
+	for {
+		opcode, payload, err := ws.readFrame()
+		if err != nil {
+			return err
+		}
+		switch opcode {
+		case websocketClose:
+			ws.writeFrame(websocketClose, nil)
+			return nil
+		case websocketPing:
+			ws.writeFrame(websocketPong, payload)
+		}
+	}
+}
+
+// read a frame from the client, unmasking its payload
+func (ws *websocketConn) readFrame() (byte, []byte, error) {
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(ws.reader, header); err != nil {
+		return 0, nil, err
+	}
+	opcode := header[0] & 0x0f
+	masked := header[1]&0x80 != 0
+	size := uint64(header[1] & 0x7f)
+
+	switch size {
+	case 126:
+		extended := make([]byte, 2)
+		if _, err := io.ReadFull(ws.reader, extended); err != nil {
+			return 0, nil, err
+		}
+		size = uint64(binary.BigEndian.Uint16(extended))
+	case 127:
+		extended := make([]byte, 8)
+		if _, err := io.ReadFull(ws.reader, extended); err != nil {
+			return 0, nil, err
+		}
+		size = binary.BigEndian.Uint64(extended)
+	}
+	if size > websocketMaxFrame {
+		return 0, nil, errors.New("WebSocket frame too large")
+	}
+
+	mask := make([]byte, 4)
+	if masked {
+		if _, err := io.ReadFull(ws.reader, mask); err != nil {
+			return 0, nil, err
+		}
+	}
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(ws.reader, payload); err != nil {
+		return 0, nil, err
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return opcode, payload, nil
+}
+
+// Close closes the underlying connection.
+func (ws *websocketConn) Close() error {
+	return ws.conn.Close()
+}
+
+// whether a comma separated header contains a token, ignoring case
+func headerContains(header http.Header, name string, token string) bool {
+	for _, value := range header[http.CanonicalHeaderKey(name)] {
+		for _, part := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(part), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -9,7 +9,18 @@ It is generated from these files:
 
 It has these top-level messages:
 	NoteData
+	Invitation
+	Admission
+	Credential
+	Command
+	Parameter
 	Message
+	ClockSample
+	NoteEnvelope
+	MerkleNode
+	NoteDigest
+	MerkleLeaf
+	Reconciliation
 */
 package protocols_p2p
 
@@ -28,6 +39,66 @@ var _ = math.Inf
 // proto package needs to be updated.
 const _ = proto.ProtoPackageIsVersion2 // please upgrade the proto package
 
+type Command_Type int32
+
+const (
+	Command_UNKNOWN    Command_Type = 0
+	Command_MUTE_ALL   Command_Type = 1
+	Command_UNMUTE_ALL Command_Type = 2
+	Command_SET_TEMPO  Command_Type = 3
+	Command_SET_SCALE  Command_Type = 4
+	Command_KICK       Command_Type = 5
+)
+
+var Command_Type_name = map[int32]string{
+	0: "UNKNOWN",
+	1: "MUTE_ALL",
+	2: "UNMUTE_ALL",
+	3: "SET_TEMPO",
+	4: "SET_SCALE",
+	5: "KICK",
+}
+var Command_Type_value = map[string]int32{
+	"UNKNOWN":    0,
+	"MUTE_ALL":   1,
+	"UNMUTE_ALL": 2,
+	"SET_TEMPO":  3,
+	"SET_SCALE":  4,
+	"KICK":       5,
+}
+
+func (x Command_Type) String() string {
+	return proto.EnumName(Command_Type_name, int32(x))
+}
+func (Command_Type) EnumDescriptor() ([]byte, []int) { return fileDescriptor0, []int{4, 0} }
+
+type Parameter_Name int32
+
+const (
+	Parameter_TEMPO Parameter_Name = 0
+	Parameter_SCALE Parameter_Name = 1
+	Parameter_ROOT  Parameter_Name = 2
+	Parameter_SWING Parameter_Name = 3
+)
+
+var Parameter_Name_name = map[int32]string{
+	0: "TEMPO",
+	1: "SCALE",
+	2: "ROOT",
+	3: "SWING",
+}
+var Parameter_Name_value = map[string]int32{
+	"TEMPO": 0,
+	"SCALE": 1,
+	"ROOT":  2,
+	"SWING": 3,
+}
+
+func (x Parameter_Name) String() string {
+	return proto.EnumName(Parameter_Name_name, int32(x))
+}
+func (Parameter_Name) EnumDescriptor() ([]byte, []int) { return fileDescriptor0, []int{5, 0} }
+
 type NoteData struct {
 	ClientVersion string `protobuf:"bytes,1,opt,name=clientVersion" json:"clientVersion,omitempty"`
 	Revision      uint32 `protobuf:"varint,2,opt,name=revision" json:"revision,omitempty"`
@@ -37,6 +108,8 @@ type NoteData struct {
 	Address       string `protobuf:"bytes,6,opt,name=address" json:"address,omitempty"`
 	NodePubKey    []byte `protobuf:"bytes,7,opt,name=nodePubKey,proto3" json:"nodePubKey,omitempty"`
 	Sign          []byte `protobuf:"bytes,8,opt,name=sign,proto3" json:"sign,omitempty"`
+	Session       string `protobuf:"bytes,9,opt,name=session" json:"session,omitempty"`
+	Effective     int64  `protobuf:"varint,10,opt,name=effective" json:"effective,omitempty"`
 }
 
 func (m *NoteData) Reset()                    { *m = NoteData{} }
@@ -100,15 +173,335 @@ func (m *NoteData) GetSign() []byte {
 	return nil
 }
 
+func (m *NoteData) GetSession() string {
+	if m != nil {
+		return m.Session
+	}
+	return ""
+}
+
+func (m *NoteData) GetEffective() int64 {
+	if m != nil {
+		return m.Effective
+	}
+	return 0
+}
+
+// an invitation to a session, issued by the session owner
+type Invitation struct {
+	Session      string   `protobuf:"bytes,1,opt,name=session" json:"session,omitempty"`
+	Bootstrap    []string `protobuf:"bytes,2,rep,name=bootstrap" json:"bootstrap,omitempty"`
+	NodeId       string   `protobuf:"bytes,3,opt,name=nodeId" json:"nodeId,omitempty"`
+	Expires      int64    `protobuf:"varint,4,opt,name=expires" json:"expires,omitempty"`
+	IssuerId     string   `protobuf:"bytes,5,opt,name=issuerId" json:"issuerId,omitempty"`
+	IssuerPubKey []byte   `protobuf:"bytes,6,opt,name=issuerPubKey,proto3" json:"issuerPubKey,omitempty"`
+	Sign         []byte   `protobuf:"bytes,7,opt,name=sign,proto3" json:"sign,omitempty"`
+}
+
+func (m *Invitation) Reset()                    { *m = Invitation{} }
+func (m *Invitation) String() string            { return proto.CompactTextString(m) }
+func (*Invitation) ProtoMessage()               {}
+func (*Invitation) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{1} }
+
+func (m *Invitation) GetSession() string {
+	if m != nil {
+		return m.Session
+	}
+	return ""
+}
+
+func (m *Invitation) GetBootstrap() []string {
+	if m != nil {
+		return m.Bootstrap
+	}
+	return nil
+}
+
+func (m *Invitation) GetNodeId() string {
+	if m != nil {
+		return m.NodeId
+	}
+	return ""
+}
+
+func (m *Invitation) GetExpires() int64 {
+	if m != nil {
+		return m.Expires
+	}
+	return 0
+}
+
+func (m *Invitation) GetIssuerId() string {
+	if m != nil {
+		return m.IssuerId
+	}
+	return ""
+}
+
+func (m *Invitation) GetIssuerPubKey() []byte {
+	if m != nil {
+		return m.IssuerPubKey
+	}
+	return nil
+}
+
+func (m *Invitation) GetSign() []byte {
+	if m != nil {
+		return m.Sign
+	}
+	return nil
+}
+
+// proof that a node has been admitted to a private session
+type Admission struct {
+	NodeId     string      `protobuf:"bytes,1,opt,name=nodeId" json:"nodeId,omitempty"`
+	Invitation *Invitation `protobuf:"bytes,2,opt,name=invitation" json:"invitation,omitempty"`
+}
+
+func (m *Admission) Reset()                    { *m = Admission{} }
+func (m *Admission) String() string            { return proto.CompactTextString(m) }
+func (*Admission) ProtoMessage()               {}
+func (*Admission) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{2} }
+
+func (m *Admission) GetNodeId() string {
+	if m != nil {
+		return m.NodeId
+	}
+	return ""
+}
+
+func (m *Admission) GetInvitation() *Invitation {
+	if m != nil {
+		return m.Invitation
+	}
+	return nil
+}
+
+// grants a node the conductor role in a session, issued by the session owner
+type Credential struct {
+	Session      string `protobuf:"bytes,1,opt,name=session" json:"session,omitempty"`
+	NodeId       string `protobuf:"bytes,2,opt,name=nodeId" json:"nodeId,omitempty"`
+	IssuerId     string `protobuf:"bytes,3,opt,name=issuerId" json:"issuerId,omitempty"`
+	IssuerPubKey []byte `protobuf:"bytes,4,opt,name=issuerPubKey,proto3" json:"issuerPubKey,omitempty"`
+	Sign         []byte `protobuf:"bytes,5,opt,name=sign,proto3" json:"sign,omitempty"`
+}
+
+func (m *Credential) Reset()                    { *m = Credential{} }
+func (m *Credential) String() string            { return proto.CompactTextString(m) }
+func (*Credential) ProtoMessage()               {}
+func (*Credential) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{3} }
+
+func (m *Credential) GetSession() string {
+	if m != nil {
+		return m.Session
+	}
+	return ""
+}
+
+func (m *Credential) GetNodeId() string {
+	if m != nil {
+		return m.NodeId
+	}
+	return ""
+}
+
+func (m *Credential) GetIssuerId() string {
+	if m != nil {
+		return m.IssuerId
+	}
+	return ""
+}
+
+func (m *Credential) GetIssuerPubKey() []byte {
+	if m != nil {
+		return m.IssuerPubKey
+	}
+	return nil
+}
+
+func (m *Credential) GetSign() []byte {
+	if m != nil {
+		return m.Sign
+	}
+	return nil
+}
+
+// a control command issued by a conductor and applied by every node in the session
+type Command struct {
+	Type       Command_Type `protobuf:"varint,1,opt,name=type,enum=protocols.p2p.Command_Type" json:"type,omitempty"`
+	Revision   uint32       `protobuf:"varint,2,opt,name=revision" json:"revision,omitempty"`
+	Session    string       `protobuf:"bytes,3,opt,name=session" json:"session,omitempty"`
+	Tempo      float32      `protobuf:"fixed32,4,opt,name=tempo" json:"tempo,omitempty"`
+	Scale      string       `protobuf:"bytes,5,opt,name=scale" json:"scale,omitempty"`
+	Target     string       `protobuf:"bytes,6,opt,name=target" json:"target,omitempty"`
+	NodeId     string       `protobuf:"bytes,7,opt,name=nodeId" json:"nodeId,omitempty"`
+	NodePubKey []byte       `protobuf:"bytes,8,opt,name=nodePubKey,proto3" json:"nodePubKey,omitempty"`
+	Credential *Credential  `protobuf:"bytes,9,opt,name=credential" json:"credential,omitempty"`
+	Sign       []byte       `protobuf:"bytes,10,opt,name=sign,proto3" json:"sign,omitempty"`
+}
+
+func (m *Command) Reset()                    { *m = Command{} }
+func (m *Command) String() string            { return proto.CompactTextString(m) }
+func (*Command) ProtoMessage()               {}
+func (*Command) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{4} }
+
+func (m *Command) GetType() Command_Type {
+	if m != nil {
+		return m.Type
+	}
+	return Command_UNKNOWN
+}
+
+func (m *Command) GetRevision() uint32 {
+	if m != nil {
+		return m.Revision
+	}
+	return 0
+}
+
+func (m *Command) GetSession() string {
+	if m != nil {
+		return m.Session
+	}
+	return ""
+}
+
+func (m *Command) GetTempo() float32 {
+	if m != nil {
+		return m.Tempo
+	}
+	return 0
+}
+
+func (m *Command) GetScale() string {
+	if m != nil {
+		return m.Scale
+	}
+	return ""
+}
+
+func (m *Command) GetTarget() string {
+	if m != nil {
+		return m.Target
+	}
+	return ""
+}
+
+func (m *Command) GetNodeId() string {
+	if m != nil {
+		return m.NodeId
+	}
+	return ""
+}
+
+func (m *Command) GetNodePubKey() []byte {
+	if m != nil {
+		return m.NodePubKey
+	}
+	return nil
+}
+
+func (m *Command) GetCredential() *Credential {
+	if m != nil {
+		return m.Credential
+	}
+	return nil
+}
+
+func (m *Command) GetSign() []byte {
+	if m != nil {
+		return m.Sign
+	}
+	return nil
+}
+
+// a proposed value for a musical parameter shared by every node in the session
+type Parameter struct {
+	Name       Parameter_Name `protobuf:"varint,1,opt,name=name,enum=protocols.p2p.Parameter_Name" json:"name,omitempty"`
+	Value      float32        `protobuf:"fixed32,2,opt,name=value" json:"value,omitempty"`
+	Scale      string         `protobuf:"bytes,3,opt,name=scale" json:"scale,omitempty"`
+	Revision   uint32         `protobuf:"varint,4,opt,name=revision" json:"revision,omitempty"`
+	Session    string         `protobuf:"bytes,5,opt,name=session" json:"session,omitempty"`
+	NodeId     string         `protobuf:"bytes,6,opt,name=nodeId" json:"nodeId,omitempty"`
+	NodePubKey []byte         `protobuf:"bytes,7,opt,name=nodePubKey,proto3" json:"nodePubKey,omitempty"`
+	Sign       []byte         `protobuf:"bytes,8,opt,name=sign,proto3" json:"sign,omitempty"`
+}
+
+func (m *Parameter) Reset()                    { *m = Parameter{} }
+func (m *Parameter) String() string            { return proto.CompactTextString(m) }
+func (*Parameter) ProtoMessage()               {}
+func (*Parameter) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{5} }
+
+func (m *Parameter) GetName() Parameter_Name {
+	if m != nil {
+		return m.Name
+	}
+	return Parameter_TEMPO
+}
+
+func (m *Parameter) GetValue() float32 {
+	if m != nil {
+		return m.Value
+	}
+	return 0
+}
+
+func (m *Parameter) GetScale() string {
+	if m != nil {
+		return m.Scale
+	}
+	return ""
+}
+
+func (m *Parameter) GetRevision() uint32 {
+	if m != nil {
+		return m.Revision
+	}
+	return 0
+}
+
+func (m *Parameter) GetSession() string {
+	if m != nil {
+		return m.Session
+	}
+	return ""
+}
+
+func (m *Parameter) GetNodeId() string {
+	if m != nil {
+		return m.NodeId
+	}
+	return ""
+}
+
+func (m *Parameter) GetNodePubKey() []byte {
+	if m != nil {
+		return m.NodePubKey
+	}
+	return nil
+}
+
+func (m *Parameter) GetSign() []byte {
+	if m != nil {
+		return m.Sign
+	}
+	return nil
+}
+
 // a notification is any number of NoteData and DeathNotice messages
 type Message struct {
-	Notes []*NoteData `protobuf:"bytes,1,rep,name=notes" json:"notes,omitempty"`
+	Notes      []*NoteData  `protobuf:"bytes,1,rep,name=notes" json:"notes,omitempty"`
+	Admissions []*Admission `protobuf:"bytes,2,rep,name=admissions" json:"admissions,omitempty"`
+	Commands   []*Command   `protobuf:"bytes,3,rep,name=commands" json:"commands,omitempty"`
+	Parameters []*Parameter    `protobuf:"bytes,4,rep,name=parameters" json:"parameters,omitempty"`
+	Envelopes  []*NoteEnvelope `protobuf:"bytes,5,rep,name=envelopes" json:"envelopes,omitempty"`
+	StateHash  []byte          `protobuf:"bytes,6,opt,name=stateHash,proto3" json:"stateHash,omitempty"`
 }
 
 func (m *Message) Reset()                    { *m = Message{} }
 func (m *Message) String() string            { return proto.CompactTextString(m) }
 func (*Message) ProtoMessage()               {}
-func (*Message) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{1} }
+func (*Message) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{6} }
 
 func (m *Message) GetNotes() []*NoteData {
 	if m != nil {
@@ -117,28 +510,324 @@ func (m *Message) GetNotes() []*NoteData {
 	return nil
 }
 
+func (m *Message) GetAdmissions() []*Admission {
+	if m != nil {
+		return m.Admissions
+	}
+	return nil
+}
+
+func (m *Message) GetCommands() []*Command {
+	if m != nil {
+		return m.Commands
+	}
+	return nil
+}
+
+func (m *Message) GetParameters() []*Parameter {
+	if m != nil {
+		return m.Parameters
+	}
+	return nil
+}
+
+func (m *Message) GetEnvelopes() []*NoteEnvelope {
+	if m != nil {
+		return m.Envelopes
+	}
+	return nil
+}
+
+func (m *Message) GetStateHash() []byte {
+	if m != nil {
+		return m.StateHash
+	}
+	return nil
+}
+
+// an NTP style clock exchange. The requester sets originate from its local clock,
+// the responder sets receive and transmit from its swarm clock. Times are unix nanoseconds.
+type ClockSample struct {
+	Originate int64 `protobuf:"varint,1,opt,name=originate" json:"originate,omitempty"`
+	Receive   int64 `protobuf:"varint,2,opt,name=receive" json:"receive,omitempty"`
+	Transmit  int64 `protobuf:"varint,3,opt,name=transmit" json:"transmit,omitempty"`
+}
+
+func (m *ClockSample) Reset()                    { *m = ClockSample{} }
+func (m *ClockSample) String() string            { return proto.CompactTextString(m) }
+func (*ClockSample) ProtoMessage()               {}
+func (*ClockSample) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{7} }
+
+func (m *ClockSample) GetOriginate() int64 {
+	if m != nil {
+		return m.Originate
+	}
+	return 0
+}
+
+func (m *ClockSample) GetReceive() int64 {
+	if m != nil {
+		return m.Receive
+	}
+	return 0
+}
+
+func (m *ClockSample) GetTransmit() int64 {
+	if m != nil {
+		return m.Transmit
+	}
+	return 0
+}
+
+// a note as relayed by gossip. The envelope is not signed, each node
+// forwarding the note updates hops.
+type NoteEnvelope struct {
+	Note   *NoteData `protobuf:"bytes,1,opt,name=note" json:"note,omitempty"`
+	Hops   uint32    `protobuf:"varint,2,opt,name=hops" json:"hops,omitempty"`
+	Origin int64     `protobuf:"varint,3,opt,name=origin" json:"origin,omitempty"`
+}
+
+func (m *NoteEnvelope) Reset()                    { *m = NoteEnvelope{} }
+func (m *NoteEnvelope) String() string            { return proto.CompactTextString(m) }
+func (*NoteEnvelope) ProtoMessage()               {}
+func (*NoteEnvelope) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{8} }
+
+func (m *NoteEnvelope) GetNote() *NoteData {
+	if m != nil {
+		return m.Note
+	}
+	return nil
+}
+
+func (m *NoteEnvelope) GetHops() uint32 {
+	if m != nil {
+		return m.Hops
+	}
+	return 0
+}
+
+func (m *NoteEnvelope) GetOrigin() int64 {
+	if m != nil {
+		return m.Origin
+	}
+	return 0
+}
+
+// the hash of a subtree of a Merkle tree over the notes of a session.
+// Notes are bucketed into leaves by the hash of their node id.
+type MerkleNode struct {
+	Level uint32 `protobuf:"varint,1,opt,name=level" json:"level,omitempty"`
+	Index uint32 `protobuf:"varint,2,opt,name=index" json:"index,omitempty"`
+	Hash  []byte `protobuf:"bytes,3,opt,name=hash,proto3" json:"hash,omitempty"`
+}
+
+func (m *MerkleNode) Reset()                    { *m = MerkleNode{} }
+func (m *MerkleNode) String() string            { return proto.CompactTextString(m) }
+func (*MerkleNode) ProtoMessage()               {}
+func (*MerkleNode) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{9} }
+
+func (m *MerkleNode) GetLevel() uint32 {
+	if m != nil {
+		return m.Level
+	}
+	return 0
+}
+
+func (m *MerkleNode) GetIndex() uint32 {
+	if m != nil {
+		return m.Index
+	}
+	return 0
+}
+
+func (m *MerkleNode) GetHash() []byte {
+	if m != nil {
+		return m.Hash
+	}
+	return nil
+}
+
+// the revision of a stored note
+type NoteDigest struct {
+	NodeId   string `protobuf:"bytes,1,opt,name=nodeId" json:"nodeId,omitempty"`
+	Revision uint32 `protobuf:"varint,2,opt,name=revision" json:"revision,omitempty"`
+}
+
+func (m *NoteDigest) Reset()                    { *m = NoteDigest{} }
+func (m *NoteDigest) String() string            { return proto.CompactTextString(m) }
+func (*NoteDigest) ProtoMessage()               {}
+func (*NoteDigest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{10} }
+
+func (m *NoteDigest) GetNodeId() string {
+	if m != nil {
+		return m.NodeId
+	}
+	return ""
+}
+
+func (m *NoteDigest) GetRevision() uint32 {
+	if m != nil {
+		return m.Revision
+	}
+	return 0
+}
+
+// the notes of a leaf bucket of a Merkle tree
+type MerkleLeaf struct {
+	Index   uint32        `protobuf:"varint,1,opt,name=index" json:"index,omitempty"`
+	Digests []*NoteDigest `protobuf:"bytes,2,rep,name=digests" json:"digests,omitempty"`
+}
+
+func (m *MerkleLeaf) Reset()                    { *m = MerkleLeaf{} }
+func (m *MerkleLeaf) String() string            { return proto.CompactTextString(m) }
+func (*MerkleLeaf) ProtoMessage()               {}
+func (*MerkleLeaf) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{11} }
+
+func (m *MerkleLeaf) GetIndex() uint32 {
+	if m != nil {
+		return m.Index
+	}
+	return 0
+}
+
+func (m *MerkleLeaf) GetDigests() []*NoteDigest {
+	if m != nil {
+		return m.Digests
+	}
+	return nil
+}
+
+// one step of a reconciliation between two nodes, answered until either
+// node has nothing left to send
+type Reconciliation struct {
+	Nodes      []*MerkleNode   `protobuf:"bytes,1,rep,name=nodes" json:"nodes,omitempty"`
+	Leaves     []*MerkleLeaf   `protobuf:"bytes,2,rep,name=leaves" json:"leaves,omitempty"`
+	Wanted     []string        `protobuf:"bytes,3,rep,name=wanted" json:"wanted,omitempty"`
+	Envelopes  []*NoteEnvelope `protobuf:"bytes,4,rep,name=envelopes" json:"envelopes,omitempty"`
+	Admissions []*Admission    `protobuf:"bytes,5,rep,name=admissions" json:"admissions,omitempty"`
+}
+
+func (m *Reconciliation) Reset()                    { *m = Reconciliation{} }
+func (m *Reconciliation) String() string            { return proto.CompactTextString(m) }
+func (*Reconciliation) ProtoMessage()               {}
+func (*Reconciliation) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{12} }
+
+func (m *Reconciliation) GetNodes() []*MerkleNode {
+	if m != nil {
+		return m.Nodes
+	}
+	return nil
+}
+
+func (m *Reconciliation) GetLeaves() []*MerkleLeaf {
+	if m != nil {
+		return m.Leaves
+	}
+	return nil
+}
+
+func (m *Reconciliation) GetWanted() []string {
+	if m != nil {
+		return m.Wanted
+	}
+	return nil
+}
+
+func (m *Reconciliation) GetEnvelopes() []*NoteEnvelope {
+	if m != nil {
+		return m.Envelopes
+	}
+	return nil
+}
+
+func (m *Reconciliation) GetAdmissions() []*Admission {
+	if m != nil {
+		return m.Admissions
+	}
+	return nil
+}
+
 func init() {
 	proto.RegisterType((*NoteData)(nil), "protocols.p2p.NoteData")
+	proto.RegisterType((*Invitation)(nil), "protocols.p2p.Invitation")
+	proto.RegisterType((*Admission)(nil), "protocols.p2p.Admission")
+	proto.RegisterType((*Credential)(nil), "protocols.p2p.Credential")
+	proto.RegisterType((*Command)(nil), "protocols.p2p.Command")
+	proto.RegisterType((*Parameter)(nil), "protocols.p2p.Parameter")
 	proto.RegisterType((*Message)(nil), "protocols.p2p.Message")
+	proto.RegisterType((*ClockSample)(nil), "protocols.p2p.ClockSample")
+	proto.RegisterType((*NoteEnvelope)(nil), "protocols.p2p.NoteEnvelope")
+	proto.RegisterType((*MerkleNode)(nil), "protocols.p2p.MerkleNode")
+	proto.RegisterType((*NoteDigest)(nil), "protocols.p2p.NoteDigest")
+	proto.RegisterType((*MerkleLeaf)(nil), "protocols.p2p.MerkleLeaf")
+	proto.RegisterType((*Reconciliation)(nil), "protocols.p2p.Reconciliation")
+	proto.RegisterEnum("protocols.p2p.Command_Type", Command_Type_name, Command_Type_value)
+	proto.RegisterEnum("protocols.p2p.Parameter_Name", Parameter_Name_name, Parameter_Name_value)
 }
 
 func init() { proto.RegisterFile("p2p.proto", fileDescriptor0) }
 
 var fileDescriptor0 = []byte{
-	// 232 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x54, 0x90, 0x31, 0x4b, 0x03, 0x41,
-	0x10, 0x85, 0x59, 0x93, 0xdc, 0x5d, 0x46, 0xaf, 0x99, 0x42, 0x07, 0x0b, 0x59, 0x82, 0xc5, 0x36,
-	0x5e, 0x71, 0x36, 0xfe, 0x00, 0x1b, 0x11, 0x45, 0xb6, 0xb0, 0xbf, 0xe4, 0x86, 0x70, 0x10, 0x77,
-	0x97, 0x9b, 0x8d, 0xe0, 0x5f, 0xf5, 0xd7, 0xc8, 0x8d, 0x46, 0x92, 0x6a, 0xdf, 0xfb, 0xde, 0x83,
-	0x59, 0x1e, 0x2c, 0x53, 0x9b, 0x9a, 0x34, 0xc6, 0x1c, 0xb1, 0xd6, 0x67, 0x13, 0x77, 0xd2, 0xa4,
-	0x36, 0xad, 0xbe, 0x0d, 0x54, 0xaf, 0x31, 0xf3, 0x63, 0x97, 0x3b, 0xbc, 0x85, 0x7a, 0xb3, 0x1b,
-	0x38, 0xe4, 0x77, 0x1e, 0x65, 0x88, 0x81, 0x8c, 0x35, 0x6e, 0xe9, 0x4f, 0x21, 0x5e, 0x43, 0x35,
-	0xf2, 0xe7, 0xa0, 0x85, 0x33, 0x6b, 0x5c, 0xed, 0xff, 0x3d, 0x22, 0xcc, 0x43, 0xcc, 0x4c, 0x33,
-	0xe5, 0xaa, 0x27, 0xf6, 0xb1, 0xcf, 0x4c, 0x73, 0x6b, 0x5c, 0xe5, 0x55, 0xe3, 0x25, 0x14, 0x21,
-	0xf6, 0xfc, 0xd4, 0xd3, 0x42, 0x4f, 0xfc, 0x39, 0x24, 0x28, 0xbb, 0xbe, 0x1f, 0x59, 0x84, 0x0a,
-	0x0d, 0x0e, 0x16, 0x6f, 0x00, 0xa6, 0xce, 0xdb, 0x7e, 0xfd, 0xcc, 0x5f, 0x54, 0x5a, 0xe3, 0x2e,
-	0xfc, 0x11, 0x99, 0xae, 0xc8, 0xb0, 0x0d, 0x54, 0x69, 0xa2, 0x7a, 0xf5, 0x00, 0xe5, 0x0b, 0x8b,
-	0x74, 0x5b, 0xc6, 0x3b, 0x58, 0x4c, 0x9f, 0x11, 0x32, 0x76, 0xe6, 0xce, 0xdb, 0xab, 0xe6, 0x64,
-	0x86, 0xe6, 0x30, 0x81, 0xff, 0x6d, 0xad, 0x0b, 0x8d, 0xef, 0x7f, 0x02, 0x00, 0x00, 0xff, 0xff,
-	0xb0, 0x98, 0x09, 0x51, 0x39, 0x01, 0x00, 0x00,
+	// 965 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x94, 0x55, 0xcd, 0x6e, 0x23, 0x45,
+	0x10, 0xde, 0xf9, 0xf1, 0xcf, 0x54, 0xe2, 0xc8, 0x6a, 0xa1, 0x65, 0x96, 0x05, 0x64, 0x8d, 0x38,
+	0x58, 0x42, 0x78, 0x15, 0xef, 0x85, 0xdc, 0x88, 0x8c, 0x05, 0x51, 0x1c, 0x27, 0xea, 0x38, 0x44,
+	0x5c, 0x58, 0x75, 0x66, 0x2a, 0xde, 0xd6, 0xce, 0x9f, 0xa6, 0x3b, 0x66, 0x73, 0xe4, 0x15, 0xf6,
+	0xc0, 0x95, 0xe7, 0xe0, 0x15, 0x78, 0x2a, 0xd4, 0x3d, 0xff, 0x5e, 0xc7, 0x2c, 0x27, 0xf7, 0x57,
+	0xfe, 0xa6, 0xab, 0xea, 0xab, 0x6f, 0x6a, 0xc0, 0x49, 0xa7, 0xe9, 0x24, 0xcd, 0x12, 0x99, 0x90,
+	0x81, 0xfe, 0xf1, 0x93, 0x50, 0x4c, 0xd2, 0x69, 0xea, 0x7d, 0x30, 0xa1, 0xbf, 0x4c, 0x24, 0xfe,
+	0xc8, 0x24, 0x23, 0xdf, 0xc0, 0xc0, 0x0f, 0x39, 0xc6, 0xf2, 0x17, 0xcc, 0x04, 0x4f, 0x62, 0xd7,
+	0x18, 0x19, 0x63, 0x87, 0xb6, 0x83, 0xe4, 0x0b, 0xe8, 0x67, 0xb8, 0xe1, 0x9a, 0x60, 0x8e, 0x8c,
+	0xf1, 0x80, 0x56, 0x98, 0x10, 0xb0, 0xe3, 0x44, 0xa2, 0x6b, 0xe9, 0xb8, 0x3e, 0xab, 0x58, 0xf4,
+	0x20, 0xd1, 0xb5, 0x47, 0xc6, 0xb8, 0x4f, 0xf5, 0x99, 0x3c, 0x87, 0x6e, 0x9c, 0x04, 0x78, 0x16,
+	0xb8, 0x1d, 0x9d, 0xa2, 0x40, 0xc4, 0x85, 0x1e, 0x0b, 0x82, 0x0c, 0x85, 0x70, 0xbb, 0xfa, 0x8f,
+	0x12, 0x92, 0xaf, 0x01, 0x14, 0xe7, 0xea, 0xe1, 0xee, 0x1c, 0x1f, 0xdd, 0xde, 0xc8, 0x18, 0x1f,
+	0xd2, 0x46, 0x44, 0x65, 0x11, 0x7c, 0x1d, 0xbb, 0x7d, 0xfd, 0x8f, 0x3e, 0xab, 0xdb, 0x04, 0x0a,
+	0x5d, 0xa8, 0x93, 0xdf, 0x56, 0x40, 0xf2, 0x25, 0x38, 0x78, 0x7f, 0x8f, 0xbe, 0xe4, 0x1b, 0x74,
+	0x61, 0x64, 0x8c, 0x2d, 0x5a, 0x07, 0xbc, 0x7f, 0x0c, 0x80, 0xb3, 0x78, 0xc3, 0x25, 0x93, 0x3c,
+	0x69, 0x5d, 0x63, 0x7c, 0x74, 0xcd, 0x5d, 0x92, 0x48, 0x21, 0x33, 0x96, 0xba, 0xe6, 0xc8, 0x1a,
+	0x3b, 0xb4, 0x0e, 0x34, 0x9a, 0xb4, 0xb6, 0x9b, 0xc4, 0xf7, 0x29, 0xcf, 0x50, 0x68, 0x4d, 0x2c,
+	0x5a, 0x42, 0x25, 0x2d, 0x17, 0xe2, 0x01, 0xb3, 0x4a, 0x98, 0x0a, 0x13, 0x0f, 0x0e, 0xf3, 0x73,
+	0x21, 0x41, 0x57, 0x37, 0xda, 0x8a, 0x55, 0x22, 0xf4, 0x6a, 0x11, 0xbc, 0xdf, 0xc0, 0x39, 0x0d,
+	0x22, 0x9e, 0x17, 0x5c, 0x97, 0x64, 0xb4, 0x4a, 0x3a, 0x01, 0xe0, 0x55, 0xc3, 0x7a, 0xaa, 0x07,
+	0xd3, 0x17, 0x93, 0x96, 0x55, 0x26, 0xb5, 0x22, 0xb4, 0x41, 0xf6, 0x3e, 0x18, 0x00, 0xb3, 0x0c,
+	0x03, 0x8c, 0x25, 0x67, 0xe1, 0x1e, 0xb1, 0xea, 0xdc, 0x66, 0x2b, 0x77, 0xb3, 0x69, 0xeb, 0x3f,
+	0x9a, 0xb6, 0xf7, 0x34, 0xdd, 0x69, 0x34, 0xfd, 0xa7, 0x05, 0xbd, 0x59, 0x12, 0x45, 0x2c, 0x0e,
+	0xc8, 0x2b, 0xb0, 0xe5, 0x63, 0x8a, 0xba, 0x9c, 0xa3, 0xe9, 0xcb, 0xad, 0xae, 0x0a, 0xd6, 0x64,
+	0xf5, 0x98, 0x22, 0xd5, 0xc4, 0xbd, 0x06, 0x6f, 0xb4, 0x67, 0xb5, 0xdb, 0xfb, 0x0c, 0x3a, 0x12,
+	0xa3, 0x34, 0xd1, 0x35, 0x9a, 0x34, 0x07, 0x2a, 0x2a, 0x7c, 0x16, 0x62, 0x31, 0xce, 0x1c, 0x28,
+	0x29, 0x24, 0xcb, 0xd6, 0x28, 0x0b, 0x97, 0x17, 0xa8, 0x21, 0x51, 0xaf, 0x25, 0x51, 0xdb, 0xfc,
+	0xfd, 0x8f, 0xcc, 0x7f, 0x02, 0xe0, 0x57, 0x23, 0x70, 0x9d, 0x9d, 0xe3, 0xab, 0x67, 0x44, 0x1b,
+	0xe4, 0x4a, 0x3d, 0x68, 0xa8, 0xf7, 0x2b, 0xd8, 0x4a, 0x0e, 0x72, 0x00, 0xbd, 0x9b, 0xe5, 0xf9,
+	0xf2, 0xf2, 0x76, 0x39, 0x7c, 0x46, 0x0e, 0xa1, 0x7f, 0x71, 0xb3, 0x9a, 0xbf, 0x39, 0x5d, 0x2c,
+	0x86, 0x06, 0x39, 0x02, 0xb8, 0x59, 0x56, 0xd8, 0x24, 0x03, 0x70, 0xae, 0xe7, 0xab, 0x37, 0xab,
+	0xf9, 0xc5, 0xd5, 0xe5, 0xd0, 0x2a, 0xe1, 0xf5, 0xec, 0x74, 0x31, 0x1f, 0xda, 0xa4, 0x0f, 0xf6,
+	0xf9, 0xd9, 0xec, 0x7c, 0xd8, 0xf1, 0xfe, 0x32, 0xc1, 0xb9, 0x62, 0x19, 0x8b, 0x50, 0x62, 0x46,
+	0x8e, 0xc1, 0x8e, 0x59, 0x54, 0x8e, 0xe6, 0xab, 0xad, 0x8a, 0x2b, 0xde, 0x64, 0xc9, 0x22, 0xa4,
+	0x9a, 0xaa, 0x04, 0xdd, 0xb0, 0xf0, 0x01, 0xf5, 0x64, 0x4c, 0x9a, 0x83, 0x5a, 0x66, 0xab, 0x29,
+	0x73, 0x73, 0x90, 0xf6, 0xd3, 0x83, 0xec, 0x3c, 0xe5, 0xd3, 0xee, 0x9e, 0x21, 0x7c, 0xd2, 0x06,
+	0xf2, 0x8e, 0xc1, 0x56, 0xb5, 0x13, 0x07, 0x3a, 0xb9, 0x34, 0xcf, 0xd4, 0x31, 0x97, 0xc5, 0x50,
+	0xb2, 0xd0, 0xcb, 0xcb, 0xd5, 0xd0, 0xd4, 0xc1, 0xdb, 0xb3, 0xe5, 0x4f, 0x43, 0xcb, 0xfb, 0xdb,
+	0x84, 0xde, 0x05, 0x0a, 0xc1, 0xd6, 0x48, 0xbe, 0x83, 0x8e, 0x5a, 0xa1, 0xc2, 0x35, 0x46, 0xd6,
+	0xf8, 0x60, 0xfa, 0xf9, 0x96, 0x40, 0xe5, 0xe2, 0xa6, 0x39, 0x8b, 0x7c, 0x0f, 0xc0, 0xca, 0x57,
+	0x5d, 0xe8, 0x7d, 0x74, 0x30, 0x75, 0xb7, 0x9e, 0xa9, 0x76, 0x01, 0x6d, 0x70, 0xc9, 0x14, 0xfa,
+	0x7e, 0xfe, 0x22, 0x08, 0xd7, 0xd2, 0xcf, 0x3d, 0xdf, 0xfd, 0x9e, 0xd0, 0x8a, 0xa7, 0xb2, 0xa5,
+	0xe5, 0x84, 0xd4, 0x26, 0xdb, 0x95, 0xad, 0x1a, 0x21, 0x6d, 0x70, 0xc9, 0x09, 0x38, 0x18, 0x6f,
+	0x30, 0x4c, 0x52, 0x14, 0x6e, 0x47, 0x3f, 0xf8, 0x72, 0x47, 0x6b, 0xf3, 0x82, 0x43, 0x6b, 0xb6,
+	0xda, 0xb8, 0x42, 0x32, 0x89, 0x3f, 0x33, 0xf1, 0xb6, 0x58, 0x81, 0x75, 0xc0, 0x63, 0x70, 0x30,
+	0x0b, 0x13, 0xff, 0xdd, 0x35, 0x8b, 0xd2, 0x10, 0x15, 0x39, 0xc9, 0xf8, 0x9a, 0xc7, 0x4c, 0xe6,
+	0x1e, 0xb3, 0x68, 0x1d, 0x50, 0x0e, 0xc8, 0xd0, 0x47, 0xbe, 0xc9, 0xbd, 0x64, 0xd1, 0x12, 0x2a,
+	0xdf, 0xc8, 0x8c, 0xc5, 0x22, 0xe2, 0x52, 0x1b, 0xca, 0xa2, 0x15, 0xf6, 0xd6, 0x70, 0xd8, 0xac,
+	0x8d, 0x7c, 0x5b, 0x7c, 0xf1, 0x8c, 0x91, 0xb1, 0x6f, 0x42, 0xd5, 0xa7, 0xf0, 0x6d, 0x92, 0x8a,
+	0x62, 0xab, 0xe8, 0xb3, 0xb2, 0x5b, 0x5e, 0x53, 0x91, 0xaa, 0x40, 0xde, 0x02, 0xe0, 0x02, 0xb3,
+	0x77, 0x21, 0x2e, 0x93, 0x40, 0x1b, 0x3c, 0xc4, 0x0d, 0x86, 0x3a, 0xcf, 0x80, 0xe6, 0x40, 0x45,
+	0x79, 0x1c, 0xe0, 0xfb, 0xe2, 0xc2, 0x1c, 0xe8, 0x2c, 0x4a, 0x1e, 0x2b, 0x37, 0xa2, 0x3a, 0x7b,
+	0x3f, 0x00, 0xe8, 0x5a, 0xf8, 0x1a, 0x85, 0x7c, 0xf2, 0x33, 0xb0, 0x67, 0xf3, 0x79, 0xb7, 0x65,
+	0x3d, 0x0b, 0x64, 0xf7, 0x75, 0x66, 0xa3, 0x99, 0xf9, 0x35, 0xf4, 0x02, 0x9d, 0xa1, 0x74, 0xdf,
+	0x8b, 0x5d, 0x7a, 0x68, 0x06, 0x2d, 0x99, 0xde, 0x1f, 0x26, 0x1c, 0x51, 0xf4, 0x93, 0xd8, 0xe7,
+	0x21, 0xcf, 0xbf, 0xb8, 0xaf, 0x94, 0xef, 0x83, 0xca, 0xf7, 0xdb, 0xb7, 0xd4, 0xba, 0xd0, 0x9c,
+	0x47, 0x8e, 0xa1, 0x1b, 0x22, 0xdb, 0xe0, 0x53, 0x79, 0xeb, 0xca, 0x69, 0x41, 0x54, 0x1a, 0xfc,
+	0xce, 0x62, 0x89, 0x81, 0x36, 0xbc, 0x43, 0x0b, 0xd4, 0x36, 0xa7, 0xfd, 0xbf, 0xcc, 0xd9, 0x7e,
+	0xff, 0x3a, 0x9f, 0xfe, 0xfe, 0xdd, 0x75, 0x35, 0xe9, 0xf5, 0xbf, 0x03, 0x00, 0x85, 0xd1, 0xb0,
+	0xd9, 0xa9, 0x09, 0x00, 0x00,
 }
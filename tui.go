@@ -0,0 +1,54 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"time"
+
+	loopnet "github.com/acruikshank/loopnet/net"
+)
+
+// time between dashboard redraws
+const tuiRefreshInterval = 250 * time.Millisecond
+
+// run the terminal dashboard for a session until the user quits
+func runTUI(np *loopnet.NotificationProtocol, arpeggiator *loopnet.Arpeggiator) error {
+	// read single key presses without echo, restoring the terminal after
+	if err := stty("raw", "-echo"); err != nil {
+		return err
+	}
+	defer stty("sane")
+	defer os.Stdout.WriteString("\x1b[?25h\x1b[H\x1b[2J") // show cursor, clear
+	os.Stdout.WriteString("\x1b[?25l")                    // hide cursor
+
+	keys := make(chan byte)
+	go func() {
+		buf := make([]byte, 1)
+		for {
+			if _, err := os.Stdin.Read(buf); err != nil {
+				close(keys)
+				return
+			}
+			keys <- buf[0]
+		}
+	}()
+
+	dashboard := loopnet.NewDashboard(np, arpeggiator)
+	for {
+		dashboard.Render(os.Stdout)
+
+		select {
+		case key, ok := <-keys:
+			if !ok || !dashboard.HandleKey(key) {
+				return nil
+			}
+		case <-time.After(tuiRefreshInterval):
+		}
+	}
+}
+
+func stty(args ...string) error {
+	cmd := exec.Command("stty", args...)
+	cmd.Stdin = os.Stdin
+	return cmd.Run()
+}